@@ -1,34 +1,57 @@
 package addressdata
 
 import (
+	"log"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/metachris/ethereum-go-experiments/core"
 	"github.com/metachris/go-ethutils/addressdetail"
-	"github.com/metachris/go-ethutils/smartcontracts"
 )
 
+// DefaultWalletTTL is how long a wallet from the AddressStore is used before the address is looked up again (a contract
+// can be deployed to an address that was a wallet before)
+const DefaultWalletTTL = 7 * 24 * time.Hour
+
+// AddressDetailService looks up address details in tiers: the in-memory Cache, the Store, and then the blockchain.
+// Details from the blockchain are written back to the Store.
 type AddressDetailService struct {
-	Client *ethclient.Client
+	Backend   ContractBackend // nil for no blockchain lookups
+	Store     AddressStore    // nil for no persistent cache
+	WalletTTL time.Duration   // wallets in the Store are looked up again after this time
+
+	LowApiCallMode bool // no blockchain lookups, only the Cache and the Store are used
 
 	// Initialize address cache with data from JSON
 	Cache map[string]addressdetail.AddressDetail
+	lock  sync.RWMutex // for Cache, as the analysis shards look up addresses concurrently
 }
 
-func NewAddressDetailService(client *ethclient.Client) *AddressDetailService {
-	return &AddressDetailService{
-		Client: client,
-		Cache:  GetAddressDetailMap(DATASET_BOTH),
+// NewAddressDetailService returns a service with the address details of the JSON files in the Cache
+func NewAddressDetailService(backend ContractBackend) (*AddressDetailService, error) {
+	cache, err := GetAddressDetailMap(DATASET_BOTH)
+	if err != nil {
+		return nil, err
 	}
+
+	return &AddressDetailService{
+		Backend:   backend,
+		WalletTTL: DefaultWalletTTL,
+		Cache:     cache,
+	}, nil
 }
 
-func (ads AddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {
+// EnsureIsLoaded loads the details of an address without details. If the lookup fails, the address stays without
+// details and the error is logged.
+func (ads *AddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {
 	if !a.IsInitial() {
 		return
 	}
 
-	b, _ := ads.GetAddressDetail(a.Address, ads.Client)
+	b, _, err := ads.GetAddressDetail(a.Address)
+	if err != nil {
+		log.Println("Error getting address detail:", err)
+	}
 	a.Address = b.Address
 	a.Type = b.Type
 	a.Name = b.Name
@@ -36,69 +59,93 @@ func (ads AddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {
 	a.Decimals = b.Decimals
 }
 
-// GetAddressDetail returns the addressdetail.AddressDetail from JSON. If not exists then query the Blockchain and caches it for future use
-func (ads *AddressDetailService) GetAddressDetail(address string, client *ethclient.Client) (detail addressdetail.AddressDetail, found bool) {
+// GetAddressDetail returns the addressdetail.AddressDetail from JSON or the Store. If not exists then query the Blockchain and caches it for future use.
+// Wallets are not kept in the in-memory cache, so that they are looked up again after WalletTTL in long runs. If the
+// blockchain lookup fails, the detail with just the address and the error are returned, and nothing is cached.
+func (ads *AddressDetailService) GetAddressDetail(address string) (detail addressdetail.AddressDetail, found bool, err error) {
 	// Check in Cache
+	ads.lock.RLock()
 	addr, found := ads.Cache[strings.ToLower(address)]
+	ads.lock.RUnlock()
 	if found {
-		return addr, true
+		return addr, true, nil
+	}
+
+	// Check in Store
+	if ads.Store != nil {
+		detail, updatedAt, found, err := ads.Store.GetAddressDetail(address)
+		found = found && !detail.IsInitial() // saved without lookup, e.g. with the stats of an analysis in LowApiCallMode
+		if err != nil {
+			log.Println("Error getting address detail from store:", err)
+		} else if found && detail.Type != addressdetail.AddressTypeWallet {
+			ads.AddAddressDetailToCache(detail)
+			return detail, true, nil
+		} else if found && time.Since(updatedAt) < ads.WalletTTL {
+			return detail, false, nil
+		}
 	}
 
 	// Without connection, return Detail with just address
-	if client == nil {
-		return addressdetail.NewAddressDetail(address), false
+	if ads.Backend == nil {
+		return addressdetail.NewAddressDetail(address), false, nil
 	} else {
 		detail = addressdetail.NewAddressDetail(address) // default
 	}
 
 	// Look up in Blockchain
-	if core.Cfg.LowApiCallMode {
-		return detail, found
+	if ads.LowApiCallMode {
+		return detail, found, nil
 	}
 
-	detail, found = GetAddressDetailFromBlockchain(address, client)
+	detail, found, err = GetAddressDetailFromBlockchain(address, ads.Backend)
+	if err != nil {
+		return addressdetail.NewAddressDetail(address), false, err
+	}
 	if found {
 		ads.AddAddressDetailToCache(detail)
 	}
-	return detail, found
+	if ads.Store != nil && !detail.IsInitial() {
+		if err := ads.Store.SaveAddressDetail(detail); err != nil {
+			log.Println("Error saving address detail to store:", err)
+		}
+	}
+	return detail, found, nil
 }
 
 func (ads *AddressDetailService) AddAddressDetailToCache(detail addressdetail.AddressDetail) {
+	ads.lock.Lock()
+	defer ads.lock.Unlock()
 	ads.Cache[strings.ToLower(detail.Address)] = detail
 }
 
-func GetAddressDetailFromBlockchain(address string, client *ethclient.Client) (detail addressdetail.AddressDetail, found bool) {
+// GetAddressDetailFromBlockchain detects the type of a smart contract with calls to it. Failed calls are expected for
+// contracts of other types and wallets, so only an error of the code lookup is returned.
+func GetAddressDetailFromBlockchain(address string, backend ContractBackend) (detail addressdetail.AddressDetail, found bool, err error) {
 	detail = addressdetail.NewAddressDetail(address)
 
 	// check fr erc721
-	isErc721, detail, _ := smartcontracts.IsErc721(address, client)
-	// if err != nil {
-	// 	return detail, found, err
-	// }
+	isErc721, detail, _ := IsErc721(address, backend)
 	if isErc721 {
-		return detail, true
+		return detail, true, nil
 	}
 
 	// check for erc20
-	isErc20, detail, _ := smartcontracts.IsErc20(address, client)
-	// if err != nil {
-	// 	return detail, found, err
-	// }
+	isErc20, detail, _ := IsErc20(address, backend)
 	if isErc20 {
-		return detail, true
+		return detail, true, nil
 	}
 
 	// check if any type of smart contract
-	isContract, _ := smartcontracts.IsContract(address, client)
-	// if err != nil {
-	// 	return detail, found, err
-	// }
+	isContract, err := IsContract(address, backend)
+	if err != nil {
+		return detail, false, err
+	}
 	if isContract {
 		detail.Type = addressdetail.AddressTypeOtherContract
-		return detail, true
+		return detail, true, nil
 	}
 
 	// return just a wallet
 	detail.Type = addressdetail.AddressTypeWallet
-	return detail, false
+	return detail, false, nil
 }
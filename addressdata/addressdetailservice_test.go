@@ -0,0 +1,160 @@
+package addressdata
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+const (
+	testContractAddress = "0x00000000000000000000000000000000000000c0"
+	testWalletAddress   = "0x00000000000000000000000000000000000000a0"
+)
+
+// stubContractBackend has code at testContractAddress, and fails all contract calls (no tokens). With codeErr, the code
+// lookups fail as well.
+type stubContractBackend struct {
+	numCodeLookups int
+	codeErr        error
+}
+
+func (b *stubContractBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	b.numCodeLookups++
+	if b.codeErr != nil {
+		return nil, b.codeErr
+	}
+	if contract == common.HexToAddress(testContractAddress) {
+		return []byte{1}, nil
+	}
+	return nil, nil
+}
+
+func (b *stubContractBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, errors.New("execution reverted")
+}
+
+func newTestService(backend ContractBackend, store AddressStore, walletTTL time.Duration) *AddressDetailService {
+	return &AddressDetailService{
+		Backend:   backend,
+		Store:     store,
+		WalletTTL: walletTTL,
+		Cache:     make(map[string]addressdetail.AddressDetail),
+	}
+}
+
+func TestAddressDetailServiceStore(t *testing.T) {
+	store, err := NewLevelDbAddressStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// The first run looks up both addresses on the blockchain, and writes them to the store
+	backend := &stubContractBackend{}
+	ads := newTestService(backend, store, time.Hour)
+	if detail, found, _ := ads.GetAddressDetail(testContractAddress); !found || detail.Type != addressdetail.AddressTypeOtherContract {
+		t.Errorf("contract: got %v, found=%t", detail, found)
+	}
+	if detail, found, _ := ads.GetAddressDetail(testWalletAddress); found || detail.Type != addressdetail.AddressTypeWallet {
+		t.Errorf("wallet: got %v, found=%t", detail, found)
+	}
+	if backend.numCodeLookups != 2 {
+		t.Errorf("first run: %d code lookups, want 2", backend.numCodeLookups)
+	}
+
+	// A new run gets both from the store
+	backend = &stubContractBackend{}
+	ads = newTestService(backend, store, time.Hour)
+	if detail, found, _ := ads.GetAddressDetail(testContractAddress); !found || detail.Type != addressdetail.AddressTypeOtherContract {
+		t.Errorf("contract from store: got %v, found=%t", detail, found)
+	}
+	if detail, _, _ := ads.GetAddressDetail(testWalletAddress); detail.Type != addressdetail.AddressTypeWallet {
+		t.Errorf("wallet from store: got %v", detail)
+	}
+	if backend.numCodeLookups != 0 {
+		t.Errorf("second run: %d code lookups, want 0", backend.numCodeLookups)
+	}
+	if _, cached := ads.Cache[testContractAddress]; !cached {
+		t.Error("contract from store is not in the in-memory cache")
+	}
+
+	// After the TTL, only the wallet is looked up again
+	ads = newTestService(backend, store, time.Nanosecond)
+	ads.GetAddressDetail(testContractAddress)
+	ads.GetAddressDetail(testWalletAddress)
+	if backend.numCodeLookups != 1 {
+		t.Errorf("after wallet TTL: %d code lookups, want 1", backend.numCodeLookups)
+	}
+}
+
+func TestAddressDetailServiceLookupError(t *testing.T) {
+	store, err := NewLevelDbAddressStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// A failed lookup is neither cached nor saved as wallet, so it's looked up again
+	nodeErr := errors.New("connection refused")
+	backend := &stubContractBackend{codeErr: nodeErr}
+	ads := newTestService(backend, store, time.Hour)
+	if detail, found, err := ads.GetAddressDetail(testContractAddress); !errors.Is(err, nodeErr) || found || detail.Address != testContractAddress {
+		t.Errorf("got %v, found=%t, error %v, want the node error", detail, found, err)
+	}
+	if _, _, found, _ := store.GetAddressDetail(testContractAddress); found {
+		t.Error("failed lookup was saved to the store")
+	}
+
+	backend.codeErr = nil
+	if detail, found, err := ads.GetAddressDetail(testContractAddress); err != nil || !found || detail.Type != addressdetail.AddressTypeOtherContract {
+		t.Errorf("after the node is back: got %v, found=%t, error %v", detail, found, err)
+	}
+}
+
+// mapAddressStore is an AddressStore that returns whatever was put into it, without the checks of the real stores
+type mapAddressStore map[string]addressdetail.AddressDetail
+
+func (s mapAddressStore) GetAddressDetail(address string) (detail addressdetail.AddressDetail, updatedAt time.Time, found bool, err error) {
+	detail, found = s[address]
+	return detail, time.Now(), found, nil
+}
+
+func (s mapAddressStore) SaveAddressDetail(detail addressdetail.AddressDetail) error {
+	s[detail.Address] = detail
+	return nil
+}
+
+func TestAddressDetailServiceStoreWithoutType(t *testing.T) {
+	// An address saved without lookup (e.g. with the stats of an analysis) is looked up on the blockchain
+	store := mapAddressStore{testContractAddress: addressdetail.NewAddressDetail(testContractAddress)}
+	backend := &stubContractBackend{}
+	ads := newTestService(backend, store, time.Hour)
+	if detail, found, err := ads.GetAddressDetail(testContractAddress); err != nil || !found || detail.Type != addressdetail.AddressTypeOtherContract {
+		t.Errorf("got %v, found=%t, error %v, want the contract from the blockchain", detail, found, err)
+	}
+	if backend.numCodeLookups != 1 {
+		t.Errorf("%d code lookups, want 1", backend.numCodeLookups)
+	}
+	if store[testContractAddress].Type != addressdetail.AddressTypeOtherContract {
+		t.Errorf("store has %v, want the looked up contract", store[testContractAddress])
+	}
+
+	// The stores don't save details without type
+	levelDbStore, err := NewLevelDbAddressStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer levelDbStore.Close()
+	if err = levelDbStore.SaveAddressDetail(addressdetail.NewAddressDetail(testWalletAddress)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, found, _ := levelDbStore.GetAddressDetail(testWalletAddress); found {
+		t.Error("detail without type was saved")
+	}
+}
@@ -0,0 +1,68 @@
+package addressdata
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// AddressStore keeps address details between runs. It's the cache tier of AddressDetailService between the in-memory
+// cache and the blockchain lookups. Implemented by the address table (database.StatsService) and LevelDbAddressStore.
+type AddressStore interface {
+	// GetAddressDetail returns the saved detail of an address, and when it was saved
+	GetAddressDetail(address string) (detail addressdetail.AddressDetail, updatedAt time.Time, found bool, err error)
+	SaveAddressDetail(detail addressdetail.AddressDetail) error
+}
+
+// LevelDbAddressStore is an AddressStore in a local LevelDB directory, for setups without a database
+type LevelDbAddressStore struct {
+	db *leveldb.DB
+}
+
+type levelDbAddressEntry struct {
+	Detail    addressdetail.AddressDetail `json:"detail"`
+	UpdatedAt time.Time                   `json:"updatedAt"`
+}
+
+// NewLevelDbAddressStore opens the store in dir, and creates it if it doesn't exist yet
+func NewLevelDbAddressStore(dir string) (*LevelDbAddressStore, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDbAddressStore{db: db}, nil
+}
+
+func (s *LevelDbAddressStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *LevelDbAddressStore) GetAddressDetail(address string) (detail addressdetail.AddressDetail, updatedAt time.Time, found bool, err error) {
+	b, err := s.db.Get([]byte(strings.ToLower(address)), nil)
+	if err == leveldb.ErrNotFound {
+		return detail, updatedAt, false, nil
+	} else if err != nil {
+		return detail, updatedAt, false, err
+	}
+
+	var entry levelDbAddressEntry
+	if err = json.Unmarshal(b, &entry); err != nil {
+		return detail, updatedAt, false, err
+	}
+	return entry.Detail, entry.UpdatedAt, true, nil
+}
+
+// SaveAddressDetail saves the detail of a looked up address. Details without type are not saved.
+func (s *LevelDbAddressStore) SaveAddressDetail(detail addressdetail.AddressDetail) error {
+	if detail.IsInitial() {
+		return nil
+	}
+	b, err := json.Marshal(levelDbAddressEntry{Detail: detail, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return s.db.Put([]byte(strings.ToLower(detail.Address)), b, nil)
+}
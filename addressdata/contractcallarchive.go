@@ -0,0 +1,160 @@
+package addressdata
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// FN_CONTRACT_CALLS is the file inside a block archive which stores the recorded contract calls
+const FN_CONTRACT_CALLS string = "contractcalls.json.gz"
+
+var ErrContractCallNotRecorded = errors.New("contract call not recorded")
+
+// ContractCallResult is the recorded response of a CodeAt or CallContract call
+type ContractCallResult struct {
+	Result string `json:"result"` // hex
+	Error  string `json:"error,omitempty"`
+}
+
+func (r ContractCallResult) get() ([]byte, error) {
+	if r.Error != "" {
+		return nil, errors.New(r.Error)
+	}
+	return hex.DecodeString(r.Result)
+}
+
+func codeAtKey(contract common.Address) string {
+	return fmt.Sprintf("code:%s", strings.ToLower(contract.Hex()))
+}
+
+func callContractKey(call ethereum.CallMsg) string {
+	to := ""
+	if call.To != nil {
+		to = strings.ToLower(call.To.Hex())
+	}
+	return fmt.Sprintf("call:%s:%s", to, hex.EncodeToString(call.Data))
+}
+
+// RecordingContractBackend passes all calls to the backend and records the responses, so the address detail lookups
+// can be replayed without a node (ReplayContractBackend)
+type RecordingContractBackend struct {
+	Backend ContractBackend
+
+	calls map[string]ContractCallResult
+	lock  sync.Mutex
+}
+
+func NewRecordingContractBackend(backend ContractBackend) *RecordingContractBackend {
+	return &RecordingContractBackend{
+		Backend: backend,
+		calls:   make(map[string]ContractCallResult),
+	}
+}
+
+func (b *RecordingContractBackend) record(key string, result []byte, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	entry := ContractCallResult{Result: hex.EncodeToString(result)}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	b.calls[key] = entry
+}
+
+func (b *RecordingContractBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	result, err := b.Backend.CodeAt(ctx, contract, blockNumber)
+	b.record(codeAtKey(contract), result, err)
+	return result, err
+}
+
+func (b *RecordingContractBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	result, err := b.Backend.CallContract(ctx, call, blockNumber)
+	b.record(callContractKey(call), result, err)
+	return result, err
+}
+
+// Save writes all recorded calls to a gzip compressed JSON file, merged with the calls already in that file
+func (b *RecordingContractBackend) Save(filename string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	calls, err := loadContractCalls(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		calls = make(map[string]ContractCallResult)
+	} else if err != nil {
+		return err
+	}
+	for k, v := range b.calls {
+		calls[k] = v
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	if err = json.NewEncoder(zw).Encode(calls); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func loadContractCalls(filename string) (calls map[string]ContractCallResult, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	err = json.NewDecoder(zr).Decode(&calls)
+	return calls, err
+}
+
+// ReplayContractBackend answers calls with the responses recorded by RecordingContractBackend
+type ReplayContractBackend struct {
+	calls map[string]ContractCallResult
+}
+
+func NewReplayContractBackend(filename string) (*ReplayContractBackend, error) {
+	calls, err := loadContractCalls(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayContractBackend{calls: calls}, nil
+}
+
+func (b *ReplayContractBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	entry, found := b.calls[codeAtKey(contract)]
+	if !found {
+		return nil, fmt.Errorf("%w: code at %s", ErrContractCallNotRecorded, contract.Hex())
+	}
+	return entry.get()
+}
+
+func (b *ReplayContractBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	entry, found := b.calls[callContractKey(call)]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrContractCallNotRecorded, callContractKey(call))
+	}
+	return entry.get()
+}
@@ -3,7 +3,7 @@ package addressdata
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,11 +20,12 @@ const ( // iota is reset to 0
 const FN_JSON_TOKENS string = "addressdata/tokens.json"
 const FN_JSON_ADDRESSES string = "addressdata/addresses.json"
 
-func GetAddressesFromJson(filename string) []addressdetail.AddressDetail {
+// GetAddressesFromJson reads a list of address details from a JSON file. Addresses without type are wallets.
+func GetAddressesFromJson(filename string) ([]addressdetail.AddressDetail, error) {
 	fn, _ := filepath.Abs(filename)
 	file, err := os.Open(fn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	defer file.Close()
@@ -34,7 +35,7 @@ func GetAddressesFromJson(filename string) []addressdetail.AddressDetail {
 	var addressDetails []addressdetail.AddressDetail
 	err = decoder.Decode(&addressDetails)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%s: %w", filename, err)
 	}
 
 	// type field is not mandatory In JSON. Use wallet as default.
@@ -45,19 +46,26 @@ func GetAddressesFromJson(filename string) []addressdetail.AddressDetail {
 		}
 	}
 
-	return addressDetails
+	return addressDetails, nil
 }
 
-func GetAddressDetailMap(dataset int) map[string]addressdetail.AddressDetail {
-	var list []addressdetail.AddressDetail
+func GetAddressDetailMap(dataset int) (map[string]addressdetail.AddressDetail, error) {
+	var filenames []string
 	if dataset == DATASET_ADDRESSES {
-		list = GetAddressesFromJson(FN_JSON_ADDRESSES)
+		filenames = []string{FN_JSON_ADDRESSES}
 	} else if dataset == DATASET_TOKENS {
-		list = GetAddressesFromJson(FN_JSON_TOKENS)
+		filenames = []string{FN_JSON_TOKENS}
 	} else if dataset == DATASET_BOTH {
-		list = GetAddressesFromJson(FN_JSON_ADDRESSES)
-		list2 := GetAddressesFromJson(FN_JSON_TOKENS)
-		list = append(list, list2...)
+		filenames = []string{FN_JSON_ADDRESSES, FN_JSON_TOKENS}
+	}
+
+	var list []addressdetail.AddressDetail
+	for _, filename := range filenames {
+		details, err := GetAddressesFromJson(filename)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, details...)
 	}
 
 	// Convert to map
@@ -66,5 +74,5 @@ func GetAddressDetailMap(dataset int) map[string]addressdetail.AddressDetail {
 		AddressDetailMap[strings.ToLower(v.Address)] = v
 	}
 
-	return AddressDetailMap
+	return AddressDetailMap, nil
 }
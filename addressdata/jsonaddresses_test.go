@@ -0,0 +1,37 @@
+package addressdata
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+func TestGetAddressesFromJson(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "addresses.json")
+	if err := os.WriteFile(filename, []byte(`[{"address": "0x00000000000000000000000000000000000000A0", "name": "Test"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	details, err := GetAddressesFromJson(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 1 || details[0].Address != testWalletAddress || details[0].Type != addressdetail.AddressTypeWallet {
+		t.Errorf("got %+v, want the wallet %s", details, testWalletAddress)
+	}
+
+	if _, err := GetAddressesFromJson(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got error %v, want os.ErrNotExist", err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"address": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAddressesFromJson(invalid); err == nil {
+		t.Error("invalid JSON: got no error")
+	}
+}
@@ -0,0 +1,87 @@
+package addressdata
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metachris/eth-go-bindings/erc165"
+	"github.com/metachris/eth-go-bindings/erc20"
+	"github.com/metachris/eth-go-bindings/erc721"
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+// ContractBackend is what is needed from a node to detect smart contract types (code lookup and read-only calls).
+// It's implemented by *ethclient.Client.
+type ContractBackend interface {
+	CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+func IsContract(address string, backend ContractBackend) (isContract bool, err error) {
+	addr := common.HexToAddress(address)
+	b, err := backend.CodeAt(context.Background(), addr, nil)
+	return len(b) > 0, err
+}
+
+// TODO: Currently returns true for every SC that supports INTERFACEID_ERC165. It should really be INTERFACEID_ERC721,
+// but that doesn't detect some SCs, eg. cryptokitties https://etherscan.io/address/0x06012c8cf97BEaD5deAe237070F9587f8E7A266d#readContract
+// As a quick fix, just checks ERC165 and count it as ERC721 address. Improve with further/better SC method checks.
+func IsErc721(address string, backend ContractBackend) (isErc721 bool, detail addressdetail.AddressDetail, err error) {
+	detail.Address = address
+
+	addr := common.HexToAddress(address)
+	instance, err := erc721.NewErc721Caller(addr, backend)
+	if err != nil {
+		return false, detail, err
+	}
+
+	isErc721, err = instance.SupportsInterface(nil, erc165.InterfaceIdErc165)
+	if err != nil || !isErc721 {
+		return isErc721, detail, err
+	}
+
+	// It appears to be ERC721
+	detail.Type = addressdetail.AddressTypeErc721
+
+	// Try to get a name and symbol (ignore errors, since we don't check erc721 metadata extension)
+	detail.Name, _ = instance.Name(nil)
+	detail.Symbol, _ = instance.Symbol(nil)
+	return true, detail, nil
+}
+
+func IsErc20(address string, backend ContractBackend) (isErc20 bool, detail addressdetail.AddressDetail, err error) {
+	detail.Address = address
+	addr := common.HexToAddress(address)
+	instance, err := erc20.NewErc20Caller(addr, backend)
+	if err != nil {
+		return false, detail, err
+	}
+
+	detail.Name, err = instance.Name(nil)
+	if err != nil || len(detail.Name) == 0 {
+		return false, detail, err
+	}
+
+	// Needs symbol
+	detail.Symbol, err = instance.Symbol(nil)
+	if err != nil || len(detail.Symbol) == 0 {
+		return false, detail, err
+	}
+
+	// Needs decimals
+	detail.Decimals, err = instance.Decimals(nil)
+	if err != nil {
+		return false, detail, err
+	}
+
+	// Needs totalSupply
+	_, err = instance.TotalSupply(nil)
+	if err != nil {
+		return false, detail, err
+	}
+
+	detail.Type = addressdetail.AddressTypeErc20
+	return true, detail, nil
+}
@@ -0,0 +1,72 @@
+// Sources for blocks with tx receipts: live RPC connection, local file archive and in-memory fixtures
+package blocksource
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// BlockSource provides blocks together with the receipts of all their transactions
+type BlockSource interface {
+	GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error)
+}
+
+// BlockError is a block that couldn't be fetched from the source
+type BlockError struct {
+	Height int64
+	Err    error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %d: %v", e.Height, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// BlockResult is a block fetched by GetBlocksWithTxReceipts, or the error if it couldn't be fetched
+type BlockResult struct {
+	Height int64
+	Block  *blockswithtx.BlockWithTxReceipts
+	Err    *BlockError
+}
+
+// GetBlocksWithTxReceipts gets a range of blocks (including endBlock) from the source, and sends a result for every
+// height to resultChan once it is ready. Uses concurrency parallel workers, so results are not necessarily sent in
+// order. A block that couldn't be fetched is sent with the error, so that consumers waiting for it can skip it.
+func GetBlocksWithTxReceipts(source BlockSource, resultChan chan<- BlockResult, startBlock int64, endBlock int64, concurrency int) {
+	var blockWorkerWg sync.WaitGroup
+	blockHeightChan := make(chan int64, 100) // blockHeight to fetch with receipts
+
+	// Start worker pool
+	for w := 1; w <= concurrency; w++ {
+		blockWorkerWg.Add(1)
+
+		go func() {
+			defer blockWorkerWg.Done()
+			for blockHeight := range blockHeightChan {
+				block, err := source.GetBlockWithTxReceipts(blockHeight)
+				if err != nil {
+					resultChan <- BlockResult{Height: blockHeight, Err: &BlockError{Height: blockHeight, Err: err}}
+					continue
+				}
+				resultChan <- BlockResult{Height: blockHeight, Block: block}
+			}
+		}()
+	}
+
+	// Push blocks into channel, for workers to pick up
+	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock; currentBlockNumber++ {
+		blockHeightChan <- currentBlockNumber
+	}
+
+	// Close worker channel and wait for workers to finish
+	close(blockHeightChan)
+	blockWorkerWg.Wait()
+}
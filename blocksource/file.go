@@ -0,0 +1,217 @@
+package blocksource
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// rlpReceipt stores the receipt fields used by the analysis. The consensus encoding of receipts doesn't include
+// GasUsed, TxHash and ContractAddress.
+type rlpReceipt struct {
+	Type              uint8
+	Status            uint64
+	CumulativeGasUsed uint64
+	GasUsed           uint64
+	TxHash            common.Hash
+	ContractAddress   common.Address
+	Logs              []*types.Log
+}
+
+// ArchiveVersion is increased on every incompatible change of the archive encoding
+const ArchiveVersion = 1
+
+var ErrUnsupportedArchiveVersion = errors.New("unsupported archive version")
+
+type rlpBlockWithTxReceipts struct {
+	Version  uint
+	Block    *types.Block
+	Receipts []rlpReceipt
+}
+
+// EncodeBlockWithTxReceipts writes the block with its receipts as RLP
+func EncodeBlockWithTxReceipts(w io.Writer, block *blockswithtx.BlockWithTxReceipts) error {
+	enc := rlpBlockWithTxReceipts{
+		Version:  ArchiveVersion,
+		Block:    block.Block,
+		Receipts: make([]rlpReceipt, 0, len(block.TxReceipts)),
+	}
+
+	// Receipts in order of the transactions (eth does not guarantee that every tx has a receipt)
+	for _, tx := range block.Block.Transactions() {
+		receipt, found := block.TxReceipts[tx.Hash()]
+		if !found || receipt == nil {
+			continue
+		}
+		enc.Receipts = append(enc.Receipts, rlpReceipt{
+			Type:              receipt.Type,
+			Status:            receipt.Status,
+			CumulativeGasUsed: receipt.CumulativeGasUsed,
+			GasUsed:           receipt.GasUsed,
+			TxHash:            tx.Hash(),
+			ContractAddress:   receipt.ContractAddress,
+			Logs:              receipt.Logs,
+		})
+	}
+
+	return rlp.Encode(w, &enc)
+}
+
+// DecodeBlockWithTxReceipts reads a block with receipts written by EncodeBlockWithTxReceipts. Derived fields of receipts
+// and logs (block hash and number, tx index, log index, bloom) are restored.
+func DecodeBlockWithTxReceipts(r io.Reader) (*blockswithtx.BlockWithTxReceipts, error) {
+	var dec rlpBlockWithTxReceipts
+	if err := rlp.Decode(r, &dec); err != nil {
+		return nil, err
+	}
+	if dec.Version != ArchiveVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedArchiveVersion, dec.Version)
+	}
+
+	txIndex := make(map[common.Hash]uint, len(dec.Block.Transactions()))
+	for i, tx := range dec.Block.Transactions() {
+		txIndex[tx.Hash()] = uint(i)
+	}
+
+	res := &blockswithtx.BlockWithTxReceipts{
+		Block:      dec.Block,
+		TxReceipts: make(map[common.Hash]*types.Receipt, len(dec.Receipts)),
+	}
+
+	logIndex := uint(0)
+	for _, r := range dec.Receipts {
+		receipt := &types.Receipt{
+			Type:              r.Type,
+			Status:            r.Status,
+			CumulativeGasUsed: r.CumulativeGasUsed,
+			GasUsed:           r.GasUsed,
+			TxHash:            r.TxHash,
+			ContractAddress:   r.ContractAddress,
+			Logs:              r.Logs,
+			BlockHash:         dec.Block.Hash(),
+			BlockNumber:       dec.Block.Number(),
+			TransactionIndex:  txIndex[r.TxHash],
+		}
+		if receipt.Logs == nil {
+			receipt.Logs = []*types.Log{}
+		}
+		for _, l := range receipt.Logs {
+			l.BlockNumber = dec.Block.NumberU64()
+			l.BlockHash = dec.Block.Hash()
+			l.TxHash = r.TxHash
+			l.TxIndex = receipt.TransactionIndex
+			l.Index = logIndex
+			logIndex++
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		res.TxReceipts[r.TxHash] = receipt
+	}
+
+	return res, nil
+}
+
+const blockFileExtension = ".rlp.gz"
+
+// FileBlockSource is an archive of blocks on disk: a directory with one gzip compressed RLP file per block (<number>.rlp.gz)
+type FileBlockSource struct {
+	Dir string
+}
+
+func NewFileBlockSource(dir string) *FileBlockSource {
+	return &FileBlockSource{Dir: dir}
+}
+
+func (s *FileBlockSource) BlockFilename(height int64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%d%s", height, blockFileExtension))
+}
+
+func (s *FileBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	f, err := os.Open(s.BlockFilename(height))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %d", ErrBlockNotFound, height)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return DecodeBlockWithTxReceipts(zr)
+}
+
+// WriteBlock saves a block to the archive. The file is written under a temporary name first, so an interrupted write
+// doesn't leave a broken block in the archive.
+func (s *FileBlockSource) WriteBlock(block *blockswithtx.BlockWithTxReceipts) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	filename := s.BlockFilename(block.Block.Number().Int64())
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(f)
+	err = EncodeBlockWithTxReceipts(zw, block)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename + ".tmp")
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// BlockNumbers returns the sorted numbers of all blocks in the archive
+func (s *FileBlockSource) BlockNumbers() ([]int64, error) {
+	files, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]int64, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), blockFileExtension) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), blockFileExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, n)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret, nil
+}
+
+// BlockRange returns the first and last block in the archive
+func (s *FileBlockSource) BlockRange() (startBlock int64, endBlock int64, err error) {
+	blocks, err := s.BlockNumbers()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(blocks) == 0 {
+		return 0, 0, fmt.Errorf("%w: archive %s is empty", ErrBlockNotFound, s.Dir)
+	}
+	return blocks[0], blocks[len(blocks)-1], nil
+}
@@ -0,0 +1,158 @@
+package blocksource_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/ethstats"
+	"github.com/metachris/ethereum-go-experiments/testutils"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+var (
+	alice = testutils.NewAccount("alice")
+	bob   = testutils.NewAccount("bob")
+	token = testutils.NewAccount("erc20-token")
+	nft   = testutils.NewAccount("erc721-token")
+)
+
+// newArchiveTestBlock returns a London block with a legacy tx, a dynamic-fee tx with token transfer logs, a failed
+// contract creation and a tx without receipt
+func newArchiveTestBlock(number int64, nonce uint64, parentHash common.Hash) *blockswithtx.BlockWithTxReceipts {
+	amount := new(big.Int).Mul(big.NewInt(number), testutils.Ether)
+	txLegacy := testutils.NewTx(alice, nonce, &bob.Address, amount, testutils.Gwei, nil)
+	txDynamicFee := testutils.NewDynamicFeeTx(alice, nonce+1, &token.Address, new(big.Int), testutils.Gwei, new(big.Int).Mul(big.NewInt(100), testutils.Gwei), testutils.TransferData(bob.Address, amount))
+	txCreate := testutils.NewTx(bob, nonce, nil, new(big.Int), testutils.Gwei, []byte{1, 2, 3})
+	txNoReceipt := testutils.NewTx(bob, nonce+1, &alice.Address, big.NewInt(1), testutils.Gwei, nil)
+
+	txs := []*types.Transaction{txLegacy, txDynamicFee, txCreate, txNoReceipt}
+	receipts := []*types.Receipt{
+		testutils.NewReceipt(txLegacy, true, 21000),
+		testutils.NewReceipt(txDynamicFee, true, 80000,
+			testutils.Erc20TransferLog(token.Address, alice.Address, bob.Address, amount),
+			testutils.Erc721TransferLog(nft.Address, bob.Address, alice.Address, big.NewInt(number))),
+		testutils.NewReceipt(txCreate, false, 60000),
+		nil,
+	}
+	return testutils.NewLondonBlock(number, 1630000000+uint64(number)*13, parentHash, big.NewInt(number), txs, receipts)
+}
+
+func TestEncodeDecodeBlockWithTxReceipts(t *testing.T) {
+	block := newArchiveTestBlock(100, 0, common.Hash{})
+
+	var buf bytes.Buffer
+	if err := blocksource.EncodeBlockWithTxReceipts(&buf, block); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := blocksource.DecodeBlockWithTxReceipts(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Block.Hash() != block.Block.Hash() || decoded.Block.BaseFee().Cmp(block.Block.BaseFee()) != 0 {
+		t.Fatalf("got block %s with base fee %s, want %s with %s", decoded.Block.Hash(), decoded.Block.BaseFee(), block.Block.Hash(), block.Block.BaseFee())
+	}
+	txs, decodedTxs := block.Block.Transactions(), decoded.Block.Transactions()
+	if len(decodedTxs) != len(txs) {
+		t.Fatalf("got %d transactions, want %d", len(decodedTxs), len(txs))
+	}
+	for i, tx := range txs {
+		if decodedTxs[i].Hash() != tx.Hash() || decodedTxs[i].Type() != tx.Type() {
+			t.Errorf("tx %d: got %s of type %d, want %s of type %d", i, decodedTxs[i].Hash(), decodedTxs[i].Type(), tx.Hash(), tx.Type())
+		}
+	}
+	if len(decoded.TxReceipts) != len(block.TxReceipts) {
+		t.Fatalf("got %d receipts, want %d", len(decoded.TxReceipts), len(block.TxReceipts))
+	}
+
+	logIndex := uint(0)
+	for i, tx := range txs {
+		receipt, found := block.TxReceipts[tx.Hash()]
+		decodedReceipt, decodedFound := decoded.TxReceipts[tx.Hash()]
+		if !found {
+			if decodedFound {
+				t.Errorf("tx %d: got a receipt for the tx without receipt", i)
+			}
+			continue
+		}
+		if !decodedFound {
+			t.Fatalf("tx %d: receipt missing", i)
+		}
+
+		if decodedReceipt.Type != receipt.Type || decodedReceipt.Status != receipt.Status || decodedReceipt.GasUsed != receipt.GasUsed || decodedReceipt.CumulativeGasUsed != receipt.CumulativeGasUsed || decodedReceipt.ContractAddress != receipt.ContractAddress {
+			t.Errorf("tx %d: got receipt %+v, want %+v", i, decodedReceipt, receipt)
+		}
+
+		// Derived fields
+		if decodedReceipt.BlockHash != block.Block.Hash() || decodedReceipt.BlockNumber.Cmp(block.Block.Number()) != 0 || decodedReceipt.TransactionIndex != uint(i) {
+			t.Errorf("tx %d: got block %s/%s and tx index %d", i, decodedReceipt.BlockHash, decodedReceipt.BlockNumber, decodedReceipt.TransactionIndex)
+		}
+		if decodedReceipt.Bloom != types.CreateBloom(types.Receipts{receipt}) {
+			t.Errorf("tx %d: wrong bloom", i)
+		}
+		if len(decodedReceipt.Logs) != len(receipt.Logs) {
+			t.Fatalf("tx %d: got %d logs, want %d", i, len(decodedReceipt.Logs), len(receipt.Logs))
+		}
+		for j, log := range receipt.Logs {
+			decodedLog := decodedReceipt.Logs[j]
+			if decodedLog.Address != log.Address || !reflect.DeepEqual(decodedLog.Topics, log.Topics) || !bytes.Equal(decodedLog.Data, log.Data) {
+				t.Errorf("tx %d log %d: got %+v, want %+v", i, j, decodedLog, log)
+			}
+			if decodedLog.Index != logIndex || decodedLog.TxHash != tx.Hash() || decodedLog.TxIndex != uint(i) || decodedLog.BlockHash != block.Block.Hash() || decodedLog.BlockNumber != block.Block.NumberU64() {
+				t.Errorf("tx %d log %d: wrong derived fields %+v", i, j, decodedLog)
+			}
+			logIndex++
+		}
+	}
+}
+
+// analyzeWithSource analyzes the blocks from source with a single shard, and returns the full JSON export
+func analyzeWithSource(t *testing.T, source blocksource.BlockSource, start int64, end int64) []byte {
+	t.Helper()
+	analyzer, err := ethstats.NewAnalyzer(
+		ethstats.WithBlockSource(source),
+		ethstats.WithAddressDetailService(testutils.NewFakeAddressDetailService(testutils.Erc20Detail(token, "Test Token", "TT", 18))),
+		ethstats.WithShards(1),
+		ethstats.WithOutput(ioutil.Discard),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis, err := analyzer.AnalyzeBlocks(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.MarshalIndent(core.NewFullAnalysisJsonExport(analysis), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReplayEqualsRecordedAnalysis(t *testing.T) {
+	blocks := make([]*blockswithtx.BlockWithTxReceipts, 5)
+	parentHash := common.Hash{}
+	for i := range blocks {
+		blocks[i] = newArchiveTestBlock(100+int64(i), 2*uint64(i), parentHash)
+		parentHash = blocks[i].Block.Hash()
+	}
+
+	dir := t.TempDir()
+	live := analyzeWithSource(t, blocksource.NewRecordingBlockSource(blocksource.NewMemoryBlockSource(blocks...), dir), 100, 104)
+	replay := analyzeWithSource(t, blocksource.NewFileBlockSource(dir), 100, 104)
+	if !bytes.Equal(replay, live) {
+		t.Errorf("replay differs from the recorded analysis:\nreplay: %s\nlive: %s", replay, live)
+	}
+
+	if start, end, err := blocksource.NewFileBlockSource(dir).BlockRange(); err != nil || start != 100 || end != 104 {
+		t.Errorf("got archive range %d-%d (error %v), want 100-104", start, end, err)
+	}
+}
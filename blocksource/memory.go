@@ -0,0 +1,41 @@
+package blocksource
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// MemoryBlockSource serves blocks from memory, eg. fixtures for tests
+type MemoryBlockSource struct {
+	blocks map[int64]*blockswithtx.BlockWithTxReceipts
+	lock   sync.RWMutex
+}
+
+func NewMemoryBlockSource(blocks ...*blockswithtx.BlockWithTxReceipts) *MemoryBlockSource {
+	s := &MemoryBlockSource{
+		blocks: make(map[int64]*blockswithtx.BlockWithTxReceipts),
+	}
+	for _, block := range blocks {
+		s.AddBlock(block)
+	}
+	return s
+}
+
+// AddBlock adds a block, replacing a previous block with the same number
+func (s *MemoryBlockSource) AddBlock(block *blockswithtx.BlockWithTxReceipts) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blocks[block.Block.Number().Int64()] = block
+}
+
+func (s *MemoryBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	block, found := s.blocks[height]
+	if !found {
+		return nil, fmt.Errorf("%w: %d", ErrBlockNotFound, height)
+	}
+	return block, nil
+}
@@ -0,0 +1,27 @@
+package blocksource
+
+import (
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// RecordingBlockSource gets blocks from another source and saves each of them to an archive, to be replayed later
+// with the archive as source
+type RecordingBlockSource struct {
+	Source  BlockSource
+	Archive *FileBlockSource
+}
+
+func NewRecordingBlockSource(source BlockSource, archiveDir string) *RecordingBlockSource {
+	return &RecordingBlockSource{
+		Source:  source,
+		Archive: NewFileBlockSource(archiveDir),
+	}
+}
+
+func (s *RecordingBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	block, err := s.Source.GetBlockWithTxReceipts(height)
+	if err != nil {
+		return block, err
+	}
+	return block, s.Archive.WriteBlock(block)
+}
@@ -0,0 +1,272 @@
+package blocksource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// ReceiptsMode is how RpcBlockSource fetches the receipts of a block
+type ReceiptsMode int
+
+const (
+	ReceiptsAuto   ReceiptsMode = iota // eth_getBlockReceipts if the node supports it, otherwise batches
+	ReceiptsBlock                      // one eth_getBlockReceipts call per block
+	ReceiptsBatch                      // eth_getTransactionReceipt calls in JSON-RPC batches
+	ReceiptsSingle                     // one eth_getTransactionReceipt call per transaction
+)
+
+func (m ReceiptsMode) String() string {
+	switch m {
+	case ReceiptsAuto:
+		return "auto"
+	case ReceiptsBlock:
+		return "eth_getBlockReceipts"
+	case ReceiptsBatch:
+		return "batched eth_getTransactionReceipt"
+	case ReceiptsSingle:
+		return "eth_getTransactionReceipt"
+	}
+	return fmt.Sprintf("ReceiptsMode(%d)", int(m))
+}
+
+// DefaultReceiptsBatchSize is the number of receipts per batch request (nodes and providers limit the batch size)
+const DefaultReceiptsBatchSize = 100
+
+// RpcBlockSource gets blocks and receipts from an Ethereum node. The receipts of a block are fetched with a single
+// eth_getBlockReceipts call if the node supports it, otherwise in batches of eth_getTransactionReceipt calls. In
+// ReceiptsAuto mode, the first block with transactions detects which one the node supports.
+type RpcBlockSource struct {
+	Client    *rpc.Client
+	BatchSize int
+
+	lock  sync.Mutex
+	mode  ReceiptsMode
+	start time.Time // of the first request
+	stats RpcStats
+}
+
+// RpcStats are the requests of an RpcBlockSource. A batch is one request.
+type RpcStats struct {
+	Mode        ReceiptsMode
+	NumBlocks   int
+	NumReceipts int
+	NumRequests int
+	Elapsed     time.Duration // from the first request until the last response
+}
+
+// NumRequestsSingle returns the number of requests with one call per block and one per receipt
+func (s RpcStats) NumRequestsSingle() int {
+	return s.NumBlocks + s.NumReceipts
+}
+
+// RequestRatio returns how many times more requests one call per block and receipt would need, or 0 without requests
+func (s RpcStats) RequestRatio() float64 {
+	if s.NumRequests == 0 {
+		return 0
+	}
+	return float64(s.NumRequestsSingle()) / float64(s.NumRequests)
+}
+
+func (s RpcStats) String() string {
+	if s.NumRequests == 0 {
+		return "no blocks fetched"
+	}
+	msg := fmt.Sprintf("%d blocks with %d receipts in %d requests using %s (%.1fx fewer than the %d requests with one call per receipt)", s.NumBlocks, s.NumReceipts, s.NumRequests, s.Mode, s.RequestRatio(), s.NumRequestsSingle())
+	if s.Elapsed > 0 {
+		msg += fmt.Sprintf(" in %s, %.1f blocks/sec", s.Elapsed.Round(time.Millisecond), float64(s.NumBlocks)/s.Elapsed.Seconds())
+	}
+	return msg
+}
+
+func NewRpcBlockSource(client *rpc.Client) *RpcBlockSource {
+	return &RpcBlockSource{Client: client, BatchSize: DefaultReceiptsBatchSize}
+}
+
+// SetMode sets how the receipts are fetched (default: ReceiptsAuto)
+func (s *RpcBlockSource) SetMode(mode ReceiptsMode) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mode = mode
+	s.stats.Mode = mode
+}
+
+// Stats returns the requests so far, and the receipts mode (detected in ReceiptsAuto)
+func (s *RpcBlockSource) Stats() RpcStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stats
+}
+
+func (s *RpcBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	s.startStats()
+	ctx := context.Background()
+	block, err := ethclient.NewClient(s.Client).BlockByNumber(ctx, big.NewInt(height))
+	s.addStats(0, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &blockswithtx.BlockWithTxReceipts{
+		Block:      block,
+		TxReceipts: make(map[common.Hash]*types.Receipt),
+	}
+	if len(block.Transactions()) == 0 {
+		s.addStats(1, 0, 0)
+		return res, nil
+	}
+
+	var receipts []*types.Receipt
+	switch mode := s.getMode(); mode {
+	case ReceiptsAuto:
+		receipts, err = s.getBlockReceipts(ctx, block)
+		if isMethodNotSupported(err) {
+			s.setDetectedMode(ReceiptsBatch)
+			receipts, err = s.getReceiptsBatched(ctx, block)
+		} else if err == nil {
+			s.setDetectedMode(ReceiptsBlock)
+		}
+	case ReceiptsBlock:
+		receipts, err = s.getBlockReceipts(ctx, block)
+	case ReceiptsBatch:
+		receipts, err = s.getReceiptsBatched(ctx, block)
+	default:
+		receipts, err = s.getReceiptsSingle(ctx, block)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, receipt := range receipts {
+		if receipt != nil { // missing receipts are skipped, like ethereum.NotFound in getReceiptsSingle
+			res.TxReceipts[receipt.TxHash] = receipt
+		}
+	}
+	s.addStats(1, len(res.TxReceipts), 0)
+	return res, nil
+}
+
+// errCodeMethodNotFound is the JSON-RPC error code for an unknown method
+const errCodeMethodNotFound = -32601
+
+// isMethodNotSupported returns true for the JSON-RPC error of a node without the method. Some providers don't use the
+// error code for it, but say so in the message. Other errors, e.g. timeouts or rate limits, don't mean the method isn't
+// supported.
+func isMethodNotSupported(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.ErrorCode() == errCodeMethodNotFound {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	return strings.Contains(msg, "not supported") || strings.Contains(msg, "method not found")
+}
+
+func (s *RpcBlockSource) getMode() ReceiptsMode {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.mode
+}
+
+// setDetectedMode sets the mode detected in ReceiptsAuto (several workers can detect it at the same time)
+func (s *RpcBlockSource) setDetectedMode(mode ReceiptsMode) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.mode == ReceiptsAuto {
+		s.mode = mode
+		s.stats.Mode = mode
+	}
+}
+
+// startStats starts the elapsed time of the stats with the first request
+func (s *RpcBlockSource) startStats() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+}
+
+func (s *RpcBlockSource) addStats(numBlocks int, numReceipts int, numRequests int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stats.NumBlocks += numBlocks
+	s.stats.NumReceipts += numReceipts
+	s.stats.NumRequests += numRequests
+	s.stats.Elapsed = time.Since(s.start)
+}
+
+func (s *RpcBlockSource) getBlockReceipts(ctx context.Context, block *types.Block) (receipts []*types.Receipt, err error) {
+	err = s.Client.CallContext(ctx, &receipts, "eth_getBlockReceipts", block.Hash())
+	s.addStats(0, 0, 1)
+	if err == nil && len(receipts) != len(block.Transactions()) {
+		return nil, fmt.Errorf("block %d: got %d receipts for %d transactions", block.NumberU64(), len(receipts), len(block.Transactions()))
+	}
+	return receipts, err
+}
+
+func (s *RpcBlockSource) getReceiptsBatched(ctx context.Context, block *types.Block) ([]*types.Receipt, error) {
+	txs := block.Transactions()
+	receipts := make([]*types.Receipt, len(txs))
+	batchSize := s.BatchSize
+	if batchSize < 1 {
+		batchSize = DefaultReceiptsBatchSize
+	}
+
+	for start := 0; start < len(txs); start += batchSize {
+		end := start + batchSize
+		if end > len(txs) {
+			end = len(txs)
+		}
+
+		batch := make([]rpc.BatchElem, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{txs[i].Hash()},
+				Result: &receipts[i],
+			})
+		}
+
+		err := s.Client.BatchCallContext(ctx, batch)
+		s.addStats(0, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, elem := range batch {
+			if elem.Error != nil {
+				return nil, elem.Error
+			}
+		}
+	}
+	return receipts, nil
+}
+
+func (s *RpcBlockSource) getReceiptsSingle(ctx context.Context, block *types.Block) ([]*types.Receipt, error) {
+	client := ethclient.NewClient(s.Client)
+	receipts := make([]*types.Receipt, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+		s.addStats(0, 0, 1)
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
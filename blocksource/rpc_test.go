@@ -0,0 +1,266 @@
+package blocksource
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/ethereum-go-experiments/testutils"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// stubEthService is the eth namespace of a stub node, serving the blocks
+type stubEthService struct {
+	blocks map[int64]*blockswithtx.BlockWithTxReceipts
+}
+
+func (s *stubEthService) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	block, found := s.blocks[number.Int64()]
+	if !found {
+		return nil, nil
+	}
+
+	var fields map[string]interface{}
+	b, err := json.Marshal(block.Block.Header())
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	fields["transactions"] = block.Block.Transactions()
+	fields["uncles"] = []common.Hash{}
+	return fields, nil
+}
+
+func (s *stubEthService) GetTransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	for _, block := range s.blocks {
+		if receipt, found := block.TxReceipts[hash]; found {
+			return receipt, nil
+		}
+	}
+	return nil, nil
+}
+
+// stubEthServiceWithBlockReceipts also supports eth_getBlockReceipts
+type stubEthServiceWithBlockReceipts struct {
+	stubEthService
+}
+
+func (s *stubEthServiceWithBlockReceipts) GetBlockReceipts(hash common.Hash) ([]*types.Receipt, error) {
+	for _, block := range s.blocks {
+		if block.Block.Hash() == hash {
+			receipts := make([]*types.Receipt, 0)
+			for _, tx := range block.Block.Transactions() {
+				receipts = append(receipts, block.TxReceipts[tx.Hash()])
+			}
+			return receipts, nil
+		}
+	}
+	return nil, nil
+}
+
+// stubRpcError is a JSON-RPC error with an error code
+type stubRpcError struct {
+	code int
+	msg  string
+}
+
+func (e stubRpcError) Error() string  { return e.msg }
+func (e stubRpcError) ErrorCode() int { return e.code }
+
+// stubEthServiceWithFailingBlockReceipts supports eth_getBlockReceipts, which fails with err
+type stubEthServiceWithFailingBlockReceipts struct {
+	stubEthService
+	err error
+}
+
+func (s *stubEthServiceWithFailingBlockReceipts) GetBlockReceipts(hash common.Hash) ([]*types.Receipt, error) {
+	return nil, s.err
+}
+
+// newStubService returns the eth service of a stub node with the blocks
+func newStubService(blocks ...*blockswithtx.BlockWithTxReceipts) stubEthService {
+	service := stubEthService{blocks: make(map[int64]*blockswithtx.BlockWithTxReceipts)}
+	for _, block := range blocks {
+		service.blocks[block.Block.Number().Int64()] = block
+	}
+	return service
+}
+
+// newStubNode serves the blocks over HTTP, with the latency added to every request
+func newStubNode(tb testing.TB, blockReceipts bool, latency time.Duration, blocks ...*blockswithtx.BlockWithTxReceipts) *rpc.Client {
+	service := newStubService(blocks...)
+	if blockReceipts {
+		return newStubServer(tb, &stubEthServiceWithBlockReceipts{service}, latency)
+	}
+	return newStubServer(tb, &service, latency)
+}
+
+// newStubServer serves the eth service over HTTP, with the latency added to every request
+func newStubServer(tb testing.TB, service interface{}, latency time.Duration) *rpc.Client {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		tb.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		server.ServeHTTP(w, r)
+	}))
+	tb.Cleanup(httpServer.Close)
+
+	client, err := rpc.DialHTTP(httpServer.URL)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return client
+}
+
+// newTestBlock returns a block with numTx transactions, and the receipt of the last one missing
+func newTestBlock(number int64, numTx int) *blockswithtx.BlockWithTxReceipts {
+	alice, bob := testutils.NewAccount("alice"), testutils.NewAccount("bob")
+	txs := make([]*types.Transaction, numTx)
+	receipts := make([]*types.Receipt, numTx)
+	for i := range txs {
+		txs[i] = testutils.NewTx(alice, uint64(i), &bob.Address, big.NewInt(int64(i)), big.NewInt(1), nil)
+		if i < numTx-1 {
+			receipts[i] = testutils.NewReceipt(txs[i], i%3 > 0, 21000)
+		}
+	}
+	return testutils.NewLondonBlock(number, 1630000000+uint64(number), common.Hash{}, big.NewInt(1), txs, receipts)
+}
+
+func TestRpcBlockSourceModes(t *testing.T) {
+	block := newTestBlock(100, 8)
+	emptyBlock := newTestBlock(101, 0)
+
+	for _, tc := range []struct {
+		blockReceipts bool
+		mode          ReceiptsMode
+		detectedMode  ReceiptsMode
+		numRequests   int
+	}{
+		{true, ReceiptsAuto, ReceiptsBlock, 3},
+		{false, ReceiptsAuto, ReceiptsBatch, 6}, // 2 blocks, eth_getBlockReceipts and 3 batches
+		{false, ReceiptsBatch, ReceiptsBatch, 5},
+		{true, ReceiptsBlock, ReceiptsBlock, 3},
+		{true, ReceiptsSingle, ReceiptsSingle, 10},
+	} {
+		t.Run(fmt.Sprintf("%s-blockReceipts-%t", tc.mode, tc.blockReceipts), func(t *testing.T) {
+			source := NewRpcBlockSource(newStubNode(t, tc.blockReceipts, 0, block, emptyBlock))
+			source.BatchSize = 3
+			source.SetMode(tc.mode)
+
+			res, err := source.GetBlockWithTxReceipts(100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Block.Hash() != block.Block.Hash() {
+				t.Errorf("got block %s, want %s", res.Block.Hash(), block.Block.Hash())
+			}
+			if len(res.TxReceipts) != len(block.TxReceipts) {
+				t.Errorf("got %d receipts, want %d", len(res.TxReceipts), len(block.TxReceipts))
+			}
+			for hash, expected := range block.TxReceipts {
+				if receipt := res.TxReceipts[hash]; receipt == nil || receipt.Status != expected.Status || receipt.GasUsed != expected.GasUsed {
+					t.Errorf("tx %s: got receipt %+v, want %+v", hash, receipt, expected)
+				}
+			}
+
+			if _, err = source.GetBlockWithTxReceipts(101); err != nil {
+				t.Fatal(err)
+			}
+			stats := source.Stats()
+			if stats.Mode != tc.detectedMode || stats.NumRequests != tc.numRequests || stats.NumBlocks != 2 || stats.NumReceipts != 7 || stats.Elapsed <= 0 {
+				t.Errorf("got stats %+v, want mode %s and %d requests", stats, tc.detectedMode, tc.numRequests)
+			}
+		})
+	}
+}
+
+func TestRpcStatsString(t *testing.T) {
+	for _, tc := range []struct {
+		stats    RpcStats
+		expected string
+	}{
+		{RpcStats{}, "no blocks fetched"},
+		{RpcStats{Mode: ReceiptsBlock, NumBlocks: 2, NumReceipts: 8, NumRequests: 4}, "2 blocks with 8 receipts in 4 requests using eth_getBlockReceipts (2.5x fewer than the 10 requests with one call per receipt)"},
+		{RpcStats{Mode: ReceiptsBatch, NumBlocks: 10, NumReceipts: 990, NumRequests: 20, Elapsed: 2500 * time.Millisecond}, "10 blocks with 990 receipts in 20 requests using batched eth_getTransactionReceipt (50.0x fewer than the 1000 requests with one call per receipt) in 2.5s, 4.0 blocks/sec"},
+		{RpcStats{Mode: ReceiptsSingle, NumBlocks: 1, NumReceipts: 3, NumRequests: 4, Elapsed: 1234567 * time.Microsecond}, "1 blocks with 3 receipts in 4 requests using eth_getTransactionReceipt (1.0x fewer than the 4 requests with one call per receipt) in 1.235s, 0.8 blocks/sec"},
+	} {
+		if actual := tc.stats.String(); actual != tc.expected {
+			t.Errorf("got %q, want %q", actual, tc.expected)
+		}
+	}
+	if ratio := (RpcStats{}).RequestRatio(); ratio != 0 {
+		t.Errorf("ratio without requests: got %f, want 0", ratio)
+	}
+}
+
+func TestRpcBlockSourceAutoModeErrors(t *testing.T) {
+	block := newTestBlock(100, 8)
+
+	for _, tc := range []struct {
+		err          error
+		detectedMode ReceiptsMode // ReceiptsAuto if the block can't be fetched
+	}{
+		{stubRpcError{-32601, "the method eth_getBlockReceipts does not exist/is not available"}, ReceiptsBatch},
+		{stubRpcError{-32000, "eth_getBlockReceipts is not supported"}, ReceiptsBatch},
+		{stubRpcError{-32005, "rate limit exceeded"}, ReceiptsAuto},
+		{stubRpcError{-32000, "request timed out"}, ReceiptsAuto},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			service := &stubEthServiceWithFailingBlockReceipts{newStubService(block), tc.err}
+			source := NewRpcBlockSource(newStubServer(t, service, 0))
+
+			_, err := source.GetBlockWithTxReceipts(100)
+			if mode := source.Stats().Mode; mode != tc.detectedMode {
+				t.Errorf("got mode %s, want %s", mode, tc.detectedMode)
+			}
+			if tc.detectedMode == ReceiptsAuto && err == nil {
+				t.Error("error of eth_getBlockReceipts: no error")
+			} else if tc.detectedMode != ReceiptsAuto && err != nil {
+				t.Errorf("batches after eth_getBlockReceipts is not supported: got error %v", err)
+			}
+		})
+	}
+}
+
+// BenchmarkRpcBlockSource compares the receipt modes for blocks with 200 transactions, with 2ms latency per request
+func BenchmarkRpcBlockSource(b *testing.B) {
+	blocks := make([]*blockswithtx.BlockWithTxReceipts, 5)
+	for i := range blocks {
+		blocks[i] = newTestBlock(int64(100+i), 200)
+	}
+
+	for _, mode := range []ReceiptsMode{ReceiptsSingle, ReceiptsBatch, ReceiptsBlock} {
+		b.Run(mode.String(), func(b *testing.B) {
+			source := NewRpcBlockSource(newStubNode(b, true, 2*time.Millisecond, blocks...))
+			source.SetMode(mode)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for _, block := range blocks {
+					wg.Add(1)
+					go func(height int64) {
+						defer wg.Done()
+						if _, err := source.GetBlockWithTxReceipts(height); err != nil {
+							b.Error(err)
+						}
+					}(block.Block.Number().Int64())
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(source.Stats().NumRequests)/float64(b.N), "requests/op")
+		})
+	}
+}
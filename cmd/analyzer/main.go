@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/ethereum-go-experiments/addressdata"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
 	"github.com/metachris/ethereum-go-experiments/consts"
 	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/database"
 	"github.com/metachris/ethereum-go-experiments/ethstats"
+	"github.com/metachris/ethereum-go-experiments/rpcpool"
 	"github.com/metachris/go-ethutils/addressdetail"
 	"github.com/metachris/go-ethutils/utils"
 )
@@ -24,49 +34,337 @@ func main() {
 	hourPtr := flag.Int("hour", 0, "hour (UTC)")
 	minPtr := flag.Int("min", 0, "hour (UTC)")
 	lenPtr := flag.String("len", "", "num blocks or timespan (4s, 5m, 1h, ...)")
-	// outJsonPtr := flag.String("out", "", "filename to store JSON output")
+	outJsonPtr := flag.String("out", "", "filename to store JSON output")
+	fullJsonPtr := flag.Bool("full", false, "JSON output with the stats of all addresses (to merge it with cmd/mergetool)")
 	blockHeightPtr := flag.Int("block", 0, "specific block to check")
-	// addToDbPtr := flag.Bool("addDb", false, "add to database")
+	addToDbPtr := flag.Bool("addDb", false, "add to database")
+	recordDirPtr := flag.String("record", "", "save blocks and contract calls to this archive directory")
+	replayDirPtr := flag.String("replay", "", "analyze blocks from this archive directory instead of the Ethereum node")
+	checkpointPtr := flag.String("checkpoint", "", "save checkpoints of the analysis to this file, to -resume an interrupted run")
+	checkpointIntervalPtr := flag.Int64("checkpointEvery", 500, "number of blocks between checkpoints")
+	resumePtr := flag.Bool("resume", false, "resume the analysis from the -checkpoint file (uses the block range of the checkpoint)")
+	followPtr := flag.Bool("follow", false, "analyze new blocks as they arrive, with snapshots of rolling windows (needs a websocket or IPC connection)")
+	windowsPtr := flag.String("windows", "5m,1h,24h", "rolling windows for -follow")
+	snapshotIntervalPtr := flag.Duration("snapshotEvery", time.Minute, "interval of the window snapshots for -follow")
+	outDirPtr := flag.String("outDir", "", "directory to store the JSON window snapshots of -follow")
+	configFlags := core.RegisterConfigFlags(flag.CommandLine)
 	flag.Parse()
 
-	if len(*datePtr) == 0 && *blockHeightPtr == 0 {
-		log.Fatal("Date or block missing, add with -date <yyyy-mm-dd> or -block <blockNum>")
+	cfg, err := configFlags.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	fmt.Println("Connecting to Ethereum node at", core.Cfg.EthNode)
-	client, err := ethclient.Dial(core.Cfg.EthNode)
-	utils.Perror(err)
-	startBlock, endBlock, err := utils.FindBlockRange(client, *blockHeightPtr, *datePtr, *hourPtr, *minPtr, *lenPtr)
+	if *followPtr {
+		windows, err := parseWindows(*windowsPtr)
+		utils.Perror(err)
+		runFollow(cfg, windows, *snapshotIntervalPtr, *outDirPtr, *addToDbPtr)
+		return
+	}
+
+	var checkpoint core.Checkpoint
+	if *resumePtr {
+		if len(*checkpointPtr) == 0 {
+			log.Fatal("-resume needs the -checkpoint file")
+		}
+		var err error
+		checkpoint, err = core.LoadCheckpoint(*checkpointPtr)
+		utils.Perror(err)
+	}
+
+	var source blocksource.BlockSource
+	var rpcSource *blocksource.RpcBlockSource
+	var backend addressdata.ContractBackend
+	var recordingBackend *addressdata.RecordingContractBackend
+	var startBlock, endBlock int64
+
+	if len(*replayDirPtr) > 0 {
+		if len(*recordDirPtr) > 0 {
+			log.Fatal("Cannot use -record and -replay together")
+		}
+
+		archive := blocksource.NewFileBlockSource(*replayDirPtr)
+		if *resumePtr {
+			startBlock, endBlock = checkpoint.StartHeight, checkpoint.EndHeight
+		} else {
+			startBlock, endBlock = getReplayBlockRange(archive, int64(*blockHeightPtr), *lenPtr)
+		}
+		source = archive
+
+		replayBackend, err := addressdata.NewReplayContractBackend(filepath.Join(*replayDirPtr, addressdata.FN_CONTRACT_CALLS))
+		if err == nil {
+			backend = replayBackend
+		} else if !errors.Is(err, os.ErrNotExist) {
+			utils.Perror(err)
+		}
+		fmt.Printf("Replaying blocks %d to %d from %s...\n", startBlock, endBlock, *replayDirPtr)
+
+	} else {
+		if len(*datePtr) == 0 && *blockHeightPtr == 0 && !*resumePtr {
+			log.Fatal("Date or block missing, add with -date <yyyy-mm-dd> or -block <blockNum>")
+		}
+
+		if len(cfg.EthNode) == 0 {
+			log.Fatal(core.ErrEthNodeMissing)
+		}
+
+		fmt.Println("Connecting to Ethereum node at", cfg.EthNode)
+		rpcClient, err := rpcpool.Dial(cfg)
+		utils.Perror(err)
+		client := ethclient.NewClient(rpcClient)
+		if *resumePtr {
+			startBlock, endBlock = checkpoint.StartHeight, checkpoint.EndHeight
+		} else {
+			startBlock, endBlock, err = utils.FindBlockRange(client, *blockHeightPtr, *datePtr, *hourPtr, *minPtr, *lenPtr)
+			utils.Perror(err)
+
+			head, err := client.BlockNumber(context.Background())
+			utils.Perror(err)
+			if confirmedHeight := int64(head) - cfg.ConfirmationDepth; endBlock > confirmedHeight {
+				if startBlock > confirmedHeight {
+					log.Fatalf("No blocks with %d confirmations in the range (head is block %d)", cfg.ConfirmationDepth, head)
+				}
+				fmt.Printf("Blocks after %d have less than %d confirmations and are skipped\n", confirmedHeight, cfg.ConfirmationDepth)
+				endBlock = confirmedHeight
+			}
+		}
+		fmt.Printf("Checking blocks %d to %d...\n", startBlock, endBlock)
+
+		rpcSource = blocksource.NewRpcBlockSource(rpcClient)
+		source = rpcSource
+		backend = client
+		if len(*recordDirPtr) > 0 {
+			source = blocksource.NewRecordingBlockSource(source, *recordDirPtr)
+			recordingBackend = addressdata.NewRecordingContractBackend(client)
+			backend = recordingBackend
+		}
+	}
+
+	ads, err := addressdata.NewAddressDetailService(backend)
 	utils.Perror(err)
-	fmt.Printf("Checking blocks %d to %d...\n", startBlock, endBlock)
+	ads.LowApiCallMode = cfg.LowApiCallMode
+	if len(*replayDirPtr) == 0 && len(*recordDirPtr) == 0 { // recordings need all contract calls, which the store would skip
+		store, closeStore := openAddressStore(cfg)
+		defer closeStore()
+		ads.Store = store
+	}
+
+	analyzer := newAnalyzer(cfg, source, ads)
+	var analysis *core.Analysis
+	if len(*checkpointPtr) > 0 {
+		analysis, err = analyzer.AnalyzeBlocksWithCheckpoints(startBlock, endBlock, *checkpointPtr, *checkpointIntervalPtr, *resumePtr)
+	} else {
+		analysis, err = analyzer.AnalyzeBlocks(startBlock, endBlock)
+	}
+	utils.Perror(err) // nothing is saved if the blocks are of different forks
+
+	if rpcSource != nil {
+		fmt.Println("Fetched", rpcSource.Stats())
+	}
 
-	analysis := ethstats.AnalyzeBlocks(client, startBlock, endBlock)
-	if !core.Cfg.HideOutput {
+	if recordingBackend != nil {
+		err := recordingBackend.Save(filepath.Join(*recordDirPtr, addressdata.FN_CONTRACT_CALLS))
+		utils.Perror(err)
+		fmt.Println("Recorded blocks and contract calls to", *recordDirPtr)
+	}
+
+	if !cfg.HideOutput {
 		fmt.Printf("\n===================\n  ANALYSIS RESULT  \n===================\n\n")
 		printResult(analysis)
 	}
 
 	timeNeeded := time.Since(timestampMainStart)
 	fmt.Printf("\nAnalysis of %s blocks, %s transactions finished in %.2fs\n", utils.NumberToHumanReadableString(analysis.Data.NumBlocks, 0), utils.NumberToHumanReadableString(analysis.Data.NumTransactions, 0), timeNeeded.Seconds())
+	printErrors(analysis.Data.Errors, 10)
 
-	// if *addToDbPtr {
-	// 	// Add to database
-	// 	fmt.Printf("\nSaving to database...\n")
-	// 	timeStartAddToDb := time.Now()
-	// 	db.AddAnalysisResultToDatabase(analysis)
-	// 	timeNeededAddToDb := time.Since(timeStartAddToDb)
-	// 	fmt.Printf("Saved to database (%.2fs)\n", timeNeededAddToDb.Seconds())
-	// }
+	if *addToDbPtr {
+		// Add to database
+		fmt.Printf("\nSaving to database...\n")
+		timeStartAddToDb := time.Now()
+		if analysisId, err := saveToDatabase(cfg, analysis); err != nil {
+			log.Println("Error saving to database:", err) // the JSON output is still saved
+		} else {
+			timeNeededAddToDb := time.Since(timeStartAddToDb)
+			fmt.Printf("Saved to database with id %d (%.2fs)\n", analysisId, timeNeededAddToDb.Seconds())
+		}
+	}
 
-	// if len(*outJsonPtr) > 0 {
-	// 	j, err := json.MarshalIndent(exportData, "", " ")
-	// 	if err != nil {
-	// 		panic(err)
-	// 	}
-	// 	outFn := *outJsonPtr
-	// 	_ = ioutil.WriteFile(outFn, j, 0644)
-	// 	fmt.Println("Saved to " + *outJsonPtr)
-	// }
+	if len(*outJsonPtr) > 0 {
+		err := analysis.SaveJson(*outJsonPtr, *fullJsonPtr)
+		utils.Perror(err)
+		fmt.Println("Saved to " + *outJsonPtr)
+	}
+}
+
+// saveToDatabase adds the analysis to the database, and returns its id
+func saveToDatabase(cfg core.Config, analysis *core.Analysis) (analysisId int, err error) {
+	db, err := database.NewStatsService(cfg.Database)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return db.AddAnalysisResultToDatabase(analysis)
+}
+
+// newAnalyzer returns an analyzer with the settings of cfg
+func newAnalyzer(cfg core.Config, source blocksource.BlockSource, ads *addressdata.AddressDetailService) *ethstats.Analyzer {
+	opts := []ethstats.Option{
+		ethstats.WithBlockSource(source),
+		ethstats.WithAddressDetailService(ads),
+		ethstats.WithConfig(cfg),
+	}
+	if cfg.DebugPrintFlashbotsTx {
+		opts = append(opts, ethstats.WithPrintFlashbotsTx())
+	}
+
+	analyzer, err := ethstats.NewAnalyzer(opts...)
+	utils.Perror(err)
+	return analyzer
+}
+
+// openAddressStore returns the persistent cache of address details: the address table if a database is configured,
+// otherwise a LevelDB store in ADDRESS_CACHE_DIR, or nil if that isn't set either
+func openAddressStore(cfg core.Config) (store addressdata.AddressStore, closeStore func()) {
+	if len(cfg.Database.Host) > 0 {
+		db, err := database.NewStatsService(cfg.Database)
+		utils.Perror(err)
+		return db, db.Close
+	}
+
+	if len(cfg.AddressCacheDir) > 0 {
+		levelDbStore, err := addressdata.NewLevelDbAddressStore(cfg.AddressCacheDir)
+		utils.Perror(err)
+		return levelDbStore, func() { levelDbStore.Close() }
+	}
+
+	return nil, func() {}
+}
+
+// getReplayBlockRange returns the full range of the archive, or if -block is given the range starting there (with the
+// number of blocks from -len, or until the end of the archive)
+func getReplayBlockRange(archive *blocksource.FileBlockSource, blockHeight int64, length string) (startBlock int64, endBlock int64) {
+	startBlock, endBlock, err := archive.BlockRange()
+	utils.Perror(err)
+
+	if blockHeight > 0 {
+		startBlock = blockHeight
+		if len(length) > 0 {
+			numBlocks, err := strconv.Atoi(length)
+			if err != nil {
+				log.Fatal("Replay only supports -len with a number of blocks")
+			}
+			endBlock = startBlock + int64(numBlocks) - 1
+		}
+	}
+	return startBlock, endBlock
+}
+
+// parseWindows parses a comma separated list of durations (eg. "5m,1h,24h")
+func parseWindows(s string) (windows []time.Duration, err error) {
+	for _, w := range strings.Split(s, ",") {
+		window, err := time.ParseDuration(strings.TrimSpace(w))
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// windowName returns the short form of the duration, used in the output and filenames (eg. "5m" instead of "5m0s")
+func windowName(window time.Duration) string {
+	name := window.String()
+	if strings.HasSuffix(name, "m0s") {
+		name = strings.TrimSuffix(name, "0s")
+	}
+	if strings.HasSuffix(name, "h0m") {
+		name = strings.TrimSuffix(name, "0m")
+	}
+	return name
+}
+
+// runFollow analyzes new blocks as they arrive, and emits snapshots of the rolling windows to stdout, JSON files in
+// outDir (window-<duration>.json, replaced with every snapshot) and the database
+func runFollow(cfg core.Config, windows []time.Duration, snapshotInterval time.Duration, outDir string, addToDb bool) {
+	if len(cfg.EthNode) == 0 {
+		log.Fatal(core.ErrEthNodeMissing)
+	}
+
+	fmt.Println("Connecting to Ethereum node at", cfg.EthNode)
+	rpcClient, err := rpcpool.Dial(cfg)
+	utils.Perror(err)
+	client := ethclient.NewClient(rpcClient)
+
+	var db *database.StatsService
+	if addToDb {
+		db, err = database.NewStatsService(cfg.Database)
+		utils.Perror(err)
+		defer db.Close()
+	}
+
+	onSnapshot := func(window time.Duration, analysis *core.Analysis) {
+		name := windowName(window)
+		data := analysis.Data
+		failedPercent := 0.0 // no transactions in the window yet
+		if data.NumTransactions > 0 {
+			failedPercent = float64(data.NumTransactionsFailed) / float64(data.NumTransactions) * 100
+		}
+		fmt.Printf("Window %-4s blocks %d-%d (%4d blocks) \t %6d tx \t %5.2f%% failed \t gas fees: %10s ETH (burned: %10s ETH) \t avg gas price: %6s gwei\n", name, data.StartBlockNumber, data.EndBlockNumber, data.NumBlocks, data.NumTransactions, failedPercent, utils.WeiBigIntToEthString(data.GasFeeTotal, 2), utils.WeiBigIntToEthString(data.GasFeeBurned, 2), core.WeiToGweiString(data.AvgEffectiveGasPrice()))
+
+		if len(outDir) > 0 {
+			if err := analysis.SaveJson(filepath.Join(outDir, "window-"+name+".json"), false); err != nil {
+				log.Println("Error saving snapshot:", err)
+			}
+		}
+
+		if db != nil && data.NumBlocks > 0 {
+			if _, err := db.AddWindowSnapshotToDatabase(name, analysis); err != nil {
+				log.Println("Error saving snapshot to database:", err)
+			}
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	ads, err := addressdata.NewAddressDetailService(client)
+	utils.Perror(err)
+	ads.LowApiCallMode = cfg.LowApiCallMode
+	store, closeStore := openAddressStore(cfg)
+	defer closeStore()
+	ads.Store = store
+
+	follower := newAnalyzer(cfg, blocksource.NewRpcBlockSource(rpcClient), ads).NewFollower(windows...)
+	if db != nil {
+		follower.OnRollback = func(fromNumber int64) {
+			if err := db.DeleteBlocksFrom(fromNumber); err != nil {
+				log.Println("Error rolling back blocks in the database:", err)
+			}
+		}
+	}
+	err = follower.Follow(ctx, client, snapshotInterval, onSnapshot)
+	if err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
+
+// printErrors prints the first max skipped blocks and transactions (all of them are in the JSON output)
+func printErrors(errs []core.AnalysisError, max int) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d blocks or transactions:\n", len(errs))
+	for i, e := range errs {
+		if i == max {
+			fmt.Printf("- ... (%d more)\n", len(errs)-max)
+			break
+		}
+		if len(e.TxHash) > 0 {
+			fmt.Printf("- block %d, tx %s: %s\n", e.BlockNumber, e.TxHash, e.Error)
+		} else {
+			fmt.Printf("- block %d: %s\n", e.BlockNumber, e.Error)
+		}
+	}
 }
 
 func printH1(msg string) {
@@ -104,6 +402,22 @@ func printTopAddr(msg string, list []core.AddressStats, max int) {
 	}
 }
 
+func printTopTokens(msg string, list []core.TokenStats) {
+	printH2(msg)
+	for _, v := range list {
+		volumeInUnit, tokenSymbol := utils.GetErc20TokensInUnit(v.Volume, v.Token)
+		tokenAmount := fmt.Sprintf("%s %-5v", formatBigFloat(volumeInUnit), tokenSymbol)
+		fmt.Printf("%s \t %8d transfers \t %7d senders \t %7d receivers \t %32v\n", AddressWithName(v.Token), v.NumTransfers, v.NumUniqueSenders, v.NumUniqueReceivers, tokenAmount)
+	}
+}
+
+func printTopMiners(msg string, list []core.MinerStats) {
+	printH2(msg)
+	for _, v := range list {
+		fmt.Printf("%s \t %5d blocks \t %4d empty \t %6.2f%% gas used \t %10v ETH tips \t %5.2f%% flashbots tx\n", AddressWithName(v.Miner), v.NumBlocks, v.NumBlocksEmpty, v.GasUtilization(), utils.WeiBigIntToEthString(v.TipsEarned, 2), v.FlashbotsShare())
+	}
+}
+
 // Processes a raw result into the export data structure, and prints the stats to stdout
 func printResult(analysis *core.Analysis) {
 	fmt.Println("Total blocks:", utils.NumberToHumanReadableString(analysis.Data.NumBlocks, 0))
@@ -119,23 +433,34 @@ func printResult(analysis *core.Analysis) {
 	fmt.Printf("- flashbots:       %s ok, %s failed \n", utils.NumberToHumanReadableString(analysis.Data.NumFlashbotsTransactionsSuccess, 0), utils.NumberToHumanReadableString(analysis.Data.NumFlashbotsTransactionsFailed, 0))
 	fmt.Println("")
 
-	fmt.Println("Total addresses:", utils.NumberToHumanReadableString(len(analysis.Addresses), 0))
+	if size := analysis.AddressSketchSize(); size > 0 {
+		fmt.Printf("Total addresses: not counted, top addresses approximated with %s counters per stat\n", utils.NumberToHumanReadableString(size, 0))
+	} else {
+		fmt.Println("Total addresses:", utils.NumberToHumanReadableString(len(analysis.Addresses), 0))
+	}
 	fmt.Println("Total value transferred:", utils.WeiBigIntToEthString(analysis.Data.ValueTotalWei, 2), "ETH")
 	fmt.Println("Total gas fees:", utils.WeiBigIntToEthString(analysis.Data.GasFeeTotal, 2), "ETH")
+	fmt.Println("- burned (base fee):", utils.WeiBigIntToEthString(analysis.Data.GasFeeBurned, 2), "ETH")
+	fmt.Println("- miner tips:", utils.WeiBigIntToEthString(analysis.Data.GasFeeTips, 2), "ETH")
+	fmt.Println("- max-fee headroom (not paid):", utils.WeiBigIntToEthString(analysis.Data.GasFeeMaxHeadroom, 2), "ETH")
 	fmt.Println("Gas for failed tx:", utils.WeiBigIntToEthString(analysis.Data.GasFeeFailedTx, 2), "ETH")
-
-	if core.Cfg.HideOutput {
-		fmt.Println("End because of config.HideOutput")
-		return
-	}
+	fmt.Println("Avg. effective gas price:", core.WeiToGweiString(analysis.Data.AvgEffectiveGasPrice()), "gwei")
 
 	fmt.Println("")
 	printH1("Transactions")
-	printTopTx("\nTop transactions by GAS FEE", analysis.Data.TopTransactions.GasFee)
-	printTopTx("\nTop transactions by ETH VALUE", analysis.Data.TopTransactions.Value)
-	printTopTx("\nTop transactions by MOST DATA", analysis.Data.TopTransactions.DataSize)
+	for _, ranker := range analysis.TxRankers() {
+		title := "\nTop transactions by " + ranker.Name
+		if ranker.Name == core.Erc20TransferValueTxRanker {
+			title += " (largest transfer in units of its token)"
+		}
+		printTopTx(title, analysis.Data.TopTransactions[ranker.Name])
+	}
 	printTopTx("\nTagged transactions", analysis.Data.TaggedTransactions)
 
+	fmt.Println("")
+	printH1("\nMiners")
+	printTopMiners("\nMiners by blocks produced", analysis.Data.TopMiners)
+
 	fmt.Println("")
 	printH1("\nSmart Contracts")
 
@@ -146,6 +471,11 @@ func printResult(analysis *core.Analysis) {
 		fmt.Printf("%s \t %8d erc20-tx \t %8d tx \t %32v\n", AddressWithName(v.AddressDetail), v.Get(consts.NumTxErc20Transfer), v.Get(consts.NumTxReceivedSuccess), tokenAmount)
 	}
 
+	for _, key := range consts.TokenStatsKeys {
+		fmt.Println("")
+		printTopTokens("ERC20 tokens by "+key, analysis.Data.TopTokens[key])
+	}
+
 	printH2("\nERC721: most token transfers")
 	for _, v := range analysis.Data.TopAddresses["NumTxErc721Transfer"] {
 		fmt.Printf("%-100s \t %8d erc721-tx \t %8d tx\n", AddressWithName(v.AddressDetail), v.Get(consts.NumTxErc721Transfer), v.Get(consts.NumTxReceivedSuccess))
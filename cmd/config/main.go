@@ -0,0 +1,43 @@
+// Prints the config that the commands load from the config file, profile, env vars and flags:
+//
+//	go run cmd/config/main.go print -profile dev -config ethstats.yaml
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/metachris/ethereum-go-experiments/core"
+)
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: config print [options]\n")
+		fs.PrintDefaults()
+	}
+}
+
+func main() {
+	fs := flag.NewFlagSet("print", flag.ExitOnError)
+	fs.Usage = usage(fs)
+	configFlags := core.RegisterConfigFlags(fs)
+
+	if len(os.Args) < 2 || os.Args[1] != "print" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(os.Args[2:])
+
+	// Invalid values are printed with the config, other errors (e.g. a missing config file) only on their own
+	cfg, err := configFlags.Load()
+	var configErrors core.ConfigErrors
+	if err == nil || errors.As(err, &configErrors) {
+		fmt.Print(cfg.Yaml())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
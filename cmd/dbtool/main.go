@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 
 	"github.com/metachris/ethereum-go-experiments/core"
 	"github.com/metachris/ethereum-go-experiments/database"
@@ -10,11 +11,23 @@ import (
 
 func main() {
 	resetPtr := flag.Bool("reset", false, "reset database")
+	configFlags := core.RegisterConfigFlags(flag.CommandLine)
 	flag.Parse()
 
+	cfg, err := configFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if *resetPtr {
-		db := database.NewStatsService(core.Cfg.Database)
-		db.Reset()
+		db, err := database.NewStatsService(cfg.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		if err = db.Reset(); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
@@ -0,0 +1,106 @@
+// Merges analyses of contiguous block ranges (e.g. hourly analyses) into one analysis (e.g. a daily report), without
+// processing the blocks again. The analyses are full JSON exports (analyzer -out <file> -full) or ids of analyses in
+// the database.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metachris/ethereum-go-experiments/addressdata"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/database"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+func main() {
+	dbIdsPtr := flag.String("db", "", "comma separated ids of analyses in the database to merge (instead of JSON files)")
+	outJsonPtr := flag.String("out", "", "filename to store JSON output")
+	fullJsonPtr := flag.Bool("full", false, "JSON output with the stats of all addresses (to merge it again)")
+	addToDbPtr := flag.Bool("addDb", false, "add merged analysis to database")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <analysis.json>...\n", "mergetool")
+		flag.PrintDefaults()
+	}
+	configFlags := core.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := configFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Address details come from the exports and the database, new lookups only from the JSON address data
+	ads, err := addressdata.NewAddressDetailService(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var db *database.StatsService
+	if len(*dbIdsPtr) > 0 || *addToDbPtr {
+		db, err = database.NewStatsService(cfg.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+	}
+
+	analyses := make([]*core.Analysis, 0)
+	if len(*dbIdsPtr) > 0 {
+		for _, idStr := range strings.Split(*dbIdsPtr, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				log.Fatalf("Invalid analysis id %q", idStr)
+			}
+			analysis, err := db.LoadAnalysis(cfg, id, ads)
+			if err != nil {
+				log.Fatalf("Analysis %d: %v", id, err)
+			}
+			analyses = append(analyses, analysis)
+		}
+		fmt.Println("Note: the database only has the stats of the top addresses, so the merged top addresses can be incomplete")
+	}
+
+	for _, filename := range flag.Args() {
+		analysis, err := core.LoadAnalysisJson(cfg, nil, filename, ads)
+		if err != nil {
+			log.Fatalf("%s: %v", filename, err)
+		}
+		analyses = append(analyses, analysis)
+	}
+
+	if len(analyses) == 0 {
+		flag.Usage()
+		log.Fatal("No analyses to merge")
+	}
+
+	sort.Slice(analyses, func(i, j int) bool { return analyses[i].Data.StartBlockNumber < analyses[j].Data.StartBlockNumber })
+	merged := analyses[0]
+	for _, analysis := range analyses[1:] {
+		err := merged.Merge(analysis)
+		utils.Perror(err)
+	}
+
+	merged.BuildTopAddresses()
+	merged.BuildTopTokens()
+	merged.BuildTopMiners()
+	merged.BuildTopTransactions()
+
+	fmt.Printf("Merged %d analyses: blocks %d to %d, %s blocks, %s transactions, %s addresses\n", len(analyses), merged.Data.StartBlockNumber, merged.Data.EndBlockNumber, utils.NumberToHumanReadableString(merged.Data.NumBlocks, 0), utils.NumberToHumanReadableString(merged.Data.NumTransactions, 0), utils.NumberToHumanReadableString(len(merged.Addresses), 0))
+
+	if *addToDbPtr {
+		analysisId, err := db.AddAnalysisResultToDatabase(merged)
+		utils.Perror(err)
+		fmt.Printf("Saved to database with id %d\n", analysisId)
+	}
+
+	if len(*outJsonPtr) > 0 {
+		err := merged.SaveJson(*outJsonPtr, *fullJsonPtr)
+		utils.Perror(err)
+		fmt.Println("Saved to " + *outJsonPtr)
+	}
+}
@@ -7,71 +7,111 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"path/filepath"
 
-	"github.com/metachris/ethereum-go-experiments/config"
-	"github.com/metachris/ethereum-go-experiments/ethstats"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/database"
 	"github.com/metachris/ethereum-go-experiments/templates"
+	"github.com/metachris/go-ethutils/utils"
 )
 
 func main() {
 	// datePtr := flag.String("date", "", "date (yyyy-mm-dd)")
 	idPtr := flag.Int("id", 0, "Analysis id")
-	outPtr := flag.String("out", "/tmp/ethstats.html", "Output filename (html)")
+	indexPtr := flag.Bool("index", false, "Render index page linking all analyses")
+	outPtr := flag.String("out", "", "Output filename (html). Default: /tmp/ethstats.html, or <outDir>/<analysis-filename>")
+	outDirPtr := flag.String("outDir", "", "Output directory. Analysis pages are saved with the filename the index links to")
+	configFlags := core.RegisterConfigFlags(flag.CommandLine)
 	flag.Parse()
 
-	if *idPtr == 0 {
-		log.Fatal("Missing -id argument")
+	cfg, err := configFlags.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	cfg := config.GetConfig()
-	fmt.Println(cfg)
+	if *idPtr == 0 && !*indexPtr {
+		log.Fatal("Missing -id or -index argument")
+	}
+
+	db, err := database.NewStatsService(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
 
-	db := ethstats.NewDatabaseConnection(cfg.Database)
+	if *idPtr > 0 {
+		analysis, err := db.Analysis(*idPtr)
+		if err != nil {
+			log.Fatal("Analysis with ID ", *idPtr, " not found: ", err)
+		}
 
-	analysis, found := ethstats.DbGetAnalysisById(db, *idPtr)
-	if !found {
-		log.Fatal("Analysis with ID ", *idPtr, " not found")
+		// Prepare some numbers
+		analysis.CalcNumbers()
+
+		fmt.Println("Getting stats entries...")
+		entries, err := db.AddressStatsForAnalysis(*idPtr)
+		utils.Perror(err)
+		fmt.Println(len(entries), "entries")
+
+		filename := getOutputFilename(*outPtr, *outDirPtr, templates.AnalysisHtmlFilename(analysis), "/tmp/ethstats.html")
+		SaveAnalysisToHtml(analysis, &entries, filename)
+		fmt.Println("Saved HTML to", filename)
 	}
 
-	// Prepare some numbers
-	analysis.CalcNumbers()
+	if *indexPtr {
+		analyses, err := db.AllAnalyses()
+		utils.Perror(err)
+		for i := range analyses {
+			analyses[i].CalcNumbers()
+		}
 
-	fmt.Println("Getting stats entries...")
-	entries, err := ethstats.DbGetAddressStatEntriesForAnalysisId(db, *idPtr)
-	ethstats.Perror(err)
-	fmt.Println(len(*entries), "entries")
+		filename := getOutputFilename("", *outDirPtr, "index.html", "/tmp/ethstats-index.html")
+		SaveIndexToHtml(analyses, filename)
+		fmt.Printf("Saved index of %d analyses to %s\n", len(analyses), filename)
+	}
+}
 
-	filename := *outPtr // "/tmp/foo.html"
-	SaveAnalysisToHtml(analysis, entries, filename)
-	fmt.Println("Saved HTML to", filename)
+func getOutputFilename(out string, outDir string, filenameInDir string, defaultFilename string) string {
+	if out != "" {
+		return out
+	} else if outDir != "" {
+		return filepath.Join(outDir, filenameInDir)
+	}
+	return defaultFilename
 }
 
-func SaveAnalysisToHtml(analysis ethstats.AnalysisEntry, stats *[]ethstats.AnalysisAddressStatsEntryWithAddress, filename string) {
-	// fmt.Println(analysis)
+func weiStrToHumanEth(weiStr string) string {
+	wei := new(big.Int)
+	wei.SetString(weiStr, 10)
+	return utils.WeiBigIntToEthString(wei, 2)
+}
 
+func renderTemplate(templateFilename string, funcs template.FuncMap, data interface{}, filename string) {
 	// Prepare HTML output file
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0600)
-	ethstats.Perror(err)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0644)
+	utils.Perror(err)
 	err = f.Truncate(0)
-	ethstats.Perror(err)
+	utils.Perror(err)
 	defer f.Close()
 
+	// Execute template
+	tmpl, err := template.New(filepath.Base(templateFilename)).Funcs(funcs).ParseFiles(templateFilename)
+	utils.Perror(err)
+	err = tmpl.Execute(f, data)
+	utils.Perror(err)
+}
+
+func SaveAnalysisToHtml(analysis database.AnalysisEntry, stats *[]database.AnalysisAddressStatsEntryWithAddress, filename string) {
 	// Prepare template data
 	tmplData := templates.TemplateData{
 		Analysis:     analysis,
 		AddressStats: stats,
 	}
 
-	weiStrToHumanEth := func(weiStr string) string {
-		wei := new(big.Int)
-		wei.SetString(weiStr, 10)
-		return ethstats.WeiBigIntToEthString(wei, 2)
-	}
-
 	// Prepare template functions
 	funcs := template.FuncMap{
 		"add":                     func(x, y int) int { return x + y },
-		"numberFormat":            ethstats.NumberToHumanReadableString,
+		"numberFormat":            utils.NumberToHumanReadableString,
 		"topErc20":                tmplData.GetTopErc20Transfer,
 		"topErc721":               tmplData.GetTopErc721Transfer,
 		"getTopFailedTxReceivers": tmplData.GetTopFailedTxReceivers,
@@ -79,9 +119,18 @@ func SaveAnalysisToHtml(analysis ethstats.AnalysisEntry, stats *[]ethstats.Analy
 		"weiStrToHumanEth":        weiStrToHumanEth,
 	}
 
-	// Execute template
-	tmpl, err := template.New("stats.html").Funcs(funcs).ParseFiles("templates/stats.html")
-	ethstats.Perror(err)
-	err = tmpl.Execute(f, tmplData)
-	ethstats.Perror(err)
+	renderTemplate("templates/stats.html", funcs, tmplData, filename)
+}
+
+func SaveIndexToHtml(analyses []database.AnalysisEntry, filename string) {
+	tmplData := templates.IndexTemplateData{
+		Analyses: analyses,
+	}
+
+	funcs := template.FuncMap{
+		"numberFormat": utils.NumberToHumanReadableString,
+		"htmlFilename": templates.AnalysisHtmlFilename,
+	}
+
+	renderTemplate("templates/index.html", funcs, tmplData, filename)
 }
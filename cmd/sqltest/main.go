@@ -1,14 +1,30 @@
 package main
 
 import (
-	"github.com/metachris/ethereum-go-experiments/config"
-	"github.com/metachris/ethereum-go-experiments/ethstats"
+	"fmt"
+
+	"github.com/metachris/ethereum-go-experiments/addressdata"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/database"
+	"github.com/metachris/go-ethutils/utils"
 )
 
 func main() {
-	db := ethstats.NewDatabaseConnection(config.GetConfig().Database)
-	ethstats.AddAddressesFromJsonToDatabase(db)
+	cfg, err := core.LoadConfig("", nil)
+	utils.Perror(err)
+
+	db, err := database.NewStatsService(cfg.Database)
+	utils.Perror(err)
+	defer db.Close()
+
+	// Add the addresses from JSON to the database
+	addressMap, err := addressdata.GetAddressDetailMap(addressdata.DATASET_BOTH)
+	utils.Perror(err)
+	for _, detail := range addressMap {
+		utils.Perror(db.SaveAddressDetail(detail))
+	}
 
-	// a, err := ethstats.GetAddressFromDatabase(db, "0xdac17f958d2ee523a2206206994597c13d831ec7")
-	// fmt.Println(a, err)
+	a, found, err := db.Address("0xdac17f958d2ee523a2206206994597c13d831ec7")
+	utils.Perror(err)
+	fmt.Println(a, found)
 }
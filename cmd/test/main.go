@@ -9,22 +9,23 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/metachris/ethereum-go-experiments/config"
-	"github.com/metachris/ethereum-go-experiments/ethstats"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/go-ethutils/utils"
 )
 
 func TxTest() {
-	config := config.GetConfig()
+	config, err := core.LoadConfig("", nil)
+	utils.Perror(err)
 	client, err := ethclient.Dial(config.EthNode)
-	ethstats.Perror(err)
+	utils.Perror(err)
 
 	txHash := common.HexToHash("0x3308ca87b00911f3b4aac572f526d41d7786c8b4d845950e83020ac8596353c0")
 	tx, _, err := client.TransactionByHash(context.Background(), txHash)
-	ethstats.Perror(err)
+	utils.Perror(err)
 	// fmt.Println(isPending)
 
 	receipt, err := client.TransactionReceipt(context.Background(), tx.Hash())
-	ethstats.Perror(err)
+	utils.Perror(err)
 	fee := float64((receipt.GasUsed * tx.GasPrice().Uint64())) / math.Pow10(18)
 	fmt.Println(receipt.GasUsed, tx.GasPrice(), fee)
 	fmt.Println(tx.Hash().Hex())
@@ -1,51 +1,340 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/metachris/ethereum-go-experiments/config"
-	"github.com/metachris/ethereum-go-experiments/ethstats"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/database"
 )
 
-func getAnalysis(c echo.Context) (err error) {
-	// Grab 'id' URL parameter
-	idStr := c.Param("id")
-	if len(idStr) < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+
+	maxTimeSeriesBuckets = 10_000
+)
+
+// ListResponse is returned by all list endpoints. NextCursor is empty if there are no more items.
+type ListResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type Server struct {
+	db *database.StatsService
+}
+
+// jsonErrorHandler returns all errors as JSON. Other errors than echo.HTTPError (e.g. of the database) are only logged,
+// and returned as internal server error without details.
+func jsonErrorHandler(err error, c echo.Context) {
+	code := http.StatusInternalServerError
+	msg := "internal server error"
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code = he.Code
+		msg = fmt.Sprint(he.Message)
+	} else {
+		c.Logger().Errorf("%s %s: %v", c.Request().Method, c.Request().URL, err)
+	}
+
+	if !c.Response().Committed {
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(code)
+		} else {
+			err = c.JSON(code, ErrorResponse{Error: msg})
+		}
+		if err != nil {
+			c.Logger().Error(err)
+		}
+	}
+}
+
+func getPageSize(c echo.Context) (int, error) {
+	limitStr := c.QueryParam("limit")
+	if limitStr == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+	}
+	return limit, nil
+}
+
+func getIdParam(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	return id, nil
+}
+
+// getNumberCursor returns the cursor of lists sorted by id/number, which is just the last number returned
+func getNumberCursor(c echo.Context) (int64, error) {
+	cursor := c.QueryParam("cursor")
+	if cursor == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || n < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+	}
+	return n, nil
+}
+
+// Address stats cursors encode the sort value and id of the last entry
+func encodeAddressStatsCursor(sortValue string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", sortValue, id)))
+}
+
+func decodeAddressStatsCursor(cursor string) (sortValue string, id int, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, err
+	}
+
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 2 {
+		return "", 0, errors.New("invalid cursor")
+	}
+
+	if _, ok := new(big.Float).SetString(parts[0]); !ok {
+		return "", 0, errors.New("invalid cursor")
+	}
+
+	id, err = strconv.Atoi(parts[1])
+	return parts[0], id, err
+}
+
+// GET /api/analysis?from=yyyy-mm-dd&to=yyyy-mm-dd&date=yyyy-mm-dd&cursor=<id>&limit=<n>
+func (srv *Server) listAnalyses(c echo.Context) error {
+	limit, err := getPageSize(c)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := getNumberCursor(c)
+	if err != nil {
+		return err
+	}
+
+	fromDate := c.QueryParam("from")
+	toDate := c.QueryParam("to")
+	if date := c.QueryParam("date"); date != "" {
+		fromDate, toDate = date, date
+	}
+
+	entries, err := srv.db.Analyses(fromDate, toDate, int(cursor), limit)
+	if err != nil {
+		return err
+	}
+
+	resp := ListResponse{Items: entries}
+	if len(entries) == limit {
+		resp.NextCursor = strconv.Itoa(entries[len(entries)-1].Id)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/analysis/:id
+func (srv *Server) getAnalysis(c echo.Context) error {
+	id, err := getIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	entry, err := srv.db.Analysis(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "analysis not found")
+	} else if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, entry)
+}
+
+// GET /api/analysis/:id/addresses?sort=<statsKey>&cursor=<cursor>&limit=<n>
+func (srv *Server) getAnalysisAddressStats(c echo.Context) error {
+	id, err := getIdParam(c)
+	if err != nil {
+		return err
 	}
 
-	// Convert id to int
-	id, err := strconv.Atoi(idStr)
+	limit, err := getPageSize(c)
 	if err != nil {
+		return err
+	}
+
+	sortKey := c.QueryParam("sort")
+	if sortKey == "" {
+		sortKey = "NumTxReceivedSuccess"
+	}
+	if _, found := database.AddressStatsSortColumns[sortKey]; !found {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s: %s", database.ErrInvalidSortKey, sortKey))
+	}
+
+	afterSortValue, afterId := "", 0
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		afterSortValue, afterId, err = decodeAddressStatsCursor(cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+		}
+	}
+
+	if _, err = srv.db.Analysis(id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "analysis not found")
+	} else if err != nil {
+		return err
+	}
+
+	page, err := srv.db.AddressStatsForAnalysisSorted(id, sortKey, afterSortValue, afterId, limit)
+	if errors.Is(err, database.ErrInvalidSortKey) {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return err
+	}
+
+	resp := ListResponse{Items: page.Entries}
+	if len(page.Entries) == limit {
+		resp.NextCursor = encodeAddressStatsCursor(page.LastSortValue, page.LastId)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/address/:address
+func (srv *Server) getAddress(c echo.Context) error {
+	address := c.Param("address")
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid address")
 	}
 
-	// Get entry from DB
-	db := ethstats.GetDatabase(config.GetConfig().Database)
-	entry, found := ethstats.DbGetAnalysisById(db, id)
+	addr, found, err := srv.db.Address(address)
+	if err != nil {
+		return err
+	}
 	if !found {
-		return echo.NewHTTPError(http.StatusNotFound)
+		return echo.NewHTTPError(http.StatusNotFound, "address not found")
 	}
+	return c.JSON(http.StatusOK, addr)
+}
 
-	// return
-	return c.JSON(http.StatusOK, entry)
+// GET /api/blocks?cursor=<number>&limit=<n>
+func (srv *Server) listBlocks(c echo.Context) error {
+	limit, err := getPageSize(c)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := getNumberCursor(c)
+	if err != nil {
+		return err
+	}
+
+	entries, err := srv.db.Blocks(cursor, limit)
+	if err != nil {
+		return err
+	}
+
+	resp := ListResponse{Items: entries}
+	if len(entries) == limit {
+		resp.NextCursor = strconv.FormatInt(entries[len(entries)-1].Number, 10)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func getTimestampParam(c echo.Context, name string, defaultValue uint64) (uint64, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	timestamp, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be a unix timestamp", name))
+	}
+	return timestamp, nil
 }
 
-// Handler
-func hello(c echo.Context) error {
-	return c.String(http.StatusOK, "Hello, World!")
+// GET /api/blocks/timeseries?bucket=minute|hour|day&from=<unix-timestamp>&to=<unix-timestamp>
+// Default is hourly buckets for the last 24 hours.
+func (srv *Server) getBlockTimeSeries(c echo.Context) error {
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		bucket = "hour"
+	}
+	bucketSec, found := database.TimeSeriesBuckets[bucket]
+	if !found {
+		return echo.NewHTTPError(http.StatusBadRequest, "bucket must be minute, hour or day")
+	}
+
+	toTime, err := getTimestampParam(c, "to", uint64(time.Now().Unix()))
+	if err != nil {
+		return err
+	}
+	defaultFromTime := uint64(0)
+	if toTime > 24*60*60 {
+		defaultFromTime = toTime - 24*60*60
+	}
+	fromTime, err := getTimestampParam(c, "from", defaultFromTime)
+	if err != nil {
+		return err
+	}
+
+	if fromTime >= toTime {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be before to")
+	}
+	if (toTime-fromTime)/bucketSec > maxTimeSeriesBuckets {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("too many buckets (max %d), use a larger bucket or a shorter time range", maxTimeSeriesBuckets))
+	}
+
+	entries, err := srv.db.BlockTimeSeries(bucket, fromTime, toTime)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ListResponse{Items: entries})
 }
 
 func main() {
-	config := config.GetConfig()
-	listenAddr := fmt.Sprintf("%s:%d", config.WebserverHost, config.WebserverPort)
+	configFlags := core.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := configFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	listenAddr := fmt.Sprintf("%s:%d", cfg.WebserverHost, cfg.WebserverPort)
+
+	db, err := database.NewStatsService(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := Server{
+		db: db,
+	}
+	defer srv.db.Close()
 
 	// Echo instance
 	e := echo.New()
+	e.HTTPErrorHandler = jsonErrorHandler
 
 	// Middleware
 	// e.Use(middleware.Logger()) // JSON logging
@@ -57,8 +346,12 @@ func main() {
 	e.Use(middleware.Recover())
 
 	// Routes
-	e.GET("/", hello)
-	e.GET("/api/analysis/:id", getAnalysis)
+	e.GET("/api/analysis", srv.listAnalyses)
+	e.GET("/api/analysis/:id", srv.getAnalysis)
+	e.GET("/api/analysis/:id/addresses", srv.getAnalysisAddressStats)
+	e.GET("/api/address/:address", srv.getAddress)
+	e.GET("/api/blocks", srv.listBlocks)
+	e.GET("/api/blocks/timeseries", srv.getBlockTimeSeries)
 
 	// Start server
 	e.Logger.Fatal(e.Start(listenAddr))
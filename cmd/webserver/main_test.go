@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestContext returns the context of a GET request to the target, with the route param id
+func newTestContext(target string, id string) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	e.Logger.SetOutput(ioutil.Discard)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return c, rec
+}
+
+// assertHTTPError checks that err is an echo.HTTPError with the status code
+func assertHTTPError(t *testing.T, err error, code int) {
+	t.Helper()
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		t.Fatalf("got error %v, want HTTP error %d", err, code)
+	}
+	if he.Code != code {
+		t.Errorf("got HTTP error %d (%v), want %d", he.Code, he.Message, code)
+	}
+}
+
+func TestAddressStatsCursor(t *testing.T) {
+	for _, tc := range []struct {
+		sortValue string
+		id        int
+	}{
+		{"0", 1},
+		{"1234", 56},
+		{"1.5", 7},
+		{"123456789012345678901234567890", 2147483647},
+	} {
+		sortValue, id, err := decodeAddressStatsCursor(encodeAddressStatsCursor(tc.sortValue, tc.id))
+		if err != nil || sortValue != tc.sortValue || id != tc.id {
+			t.Errorf("cursor of %s/%d: got %s/%d (error %v)", tc.sortValue, tc.id, sortValue, id, err)
+		}
+	}
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, cursor := range []string{
+		"not base64!",
+		encode("1234"),
+		encode("1234:56:7"),
+		encode("abc:56"),
+		encode("1); DROP TABLE analysis; --:56"),
+		encode("1234:abc"),
+		encode(":56"),
+	} {
+		if _, _, err := decodeAddressStatsCursor(cursor); err == nil {
+			t.Errorf("cursor %q: no error", cursor)
+		}
+	}
+}
+
+func TestGetPageSize(t *testing.T) {
+	for _, tc := range []struct {
+		limit    string
+		expected int // 0 for an invalid limit
+	}{
+		{"", defaultPageSize},
+		{"1", 1},
+		{"500", maxPageSize},
+		{"0", 0},
+		{"-1", 0},
+		{"501", 0},
+		{"abc", 0},
+	} {
+		c, _ := newTestContext("/api/blocks?limit="+tc.limit, "")
+		limit, err := getPageSize(c)
+		if tc.expected == 0 {
+			assertHTTPError(t, err, http.StatusBadRequest)
+		} else if err != nil || limit != tc.expected {
+			t.Errorf("limit %q: got %d (error %v), want %d", tc.limit, limit, err, tc.expected)
+		}
+	}
+}
+
+func TestGetNumberCursor(t *testing.T) {
+	c, _ := newTestContext("/api/blocks?cursor=1234", "")
+	if cursor, err := getNumberCursor(c); err != nil || cursor != 1234 {
+		t.Errorf("got cursor %d (error %v), want 1234", cursor, err)
+	}
+
+	for _, cursor := range []string{"0", "-5", "abc", "1.5"} {
+		c, _ := newTestContext("/api/blocks?cursor="+cursor, "")
+		_, err := getNumberCursor(c)
+		assertHTTPError(t, err, http.StatusBadRequest)
+	}
+}
+
+// The parameters are validated before the database is queried, so the server has no database
+func TestGetAnalysisAddressStatsInvalidParams(t *testing.T) {
+	srv := &Server{}
+	for _, tc := range []struct {
+		name   string
+		target string
+		id     string
+	}{
+		{"invalid id", "/api/analysis/x/addresses", "x"},
+		{"limit too low", "/api/analysis/1/addresses?limit=0", "1"},
+		{"limit too high", "/api/analysis/1/addresses?limit=501", "1"},
+		{"unknown sort key", "/api/analysis/1/addresses?sort=Unknown", "1"},
+		{"sort column instead of key", "/api/analysis/1/addresses?sort=NumTxSentSuccess%2BNumTxSentFailed", "1"},
+		{"invalid cursor", "/api/analysis/1/addresses?cursor=abc", "1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := newTestContext(tc.target, tc.id)
+			assertHTTPError(t, srv.getAnalysisAddressStats(c), http.StatusBadRequest)
+		})
+	}
+}
+
+func TestJsonErrorHandler(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		code     int
+		expected string
+	}{
+		{echo.NewHTTPError(http.StatusBadRequest, "invalid cursor"), http.StatusBadRequest, "invalid cursor"},
+		{echo.ErrNotFound, http.StatusNotFound, "Not Found"},
+		{errors.New("pq: password authentication failed for user stats"), http.StatusInternalServerError, "internal server error"},
+	} {
+		c, rec := newTestContext("/api/analysis", "")
+		jsonErrorHandler(tc.err, c)
+
+		var resp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tc.code || resp.Error != tc.expected {
+			t.Errorf("%v: got %d %q, want %d %q", tc.err, rec.Code, resp.Error, tc.code, tc.expected)
+		}
+		if strings.Contains(rec.Body.String(), "pq:") {
+			t.Errorf("%v: the response contains the error details: %s", tc.err, rec.Body.String())
+		}
+	}
+}
@@ -16,6 +16,8 @@ var (
 	NumTxWithDataSent     = "NumTxWithDataSent"
 	NumTxWithDataReceived = "NumTxWithDataReceived"
 
+	// Token transfer stats count the Transfer events: for the token contract (*Transfer, *Transferred), the token sender
+	// and the token receiver
 	NumTxErc20Sent      = "NumTxErc20Sent"
 	NumTxErc20Received  = "NumTxErc20Received"
 	NumTxErc20Transfer  = "NumTxErc20Transfer"
@@ -34,6 +36,12 @@ var (
 	GasFeeTotal    = "GasFeeTotal"
 	GasFeeFailedTx = "GasFeeFailedTx"
 
+	// EIP-1559 split of GasFeeTotal paid by the sender: burned base fee and priority tips to the miner. Max-fee headroom
+	// is what the sender allowed (GasFeeCap) but didn't pay.
+	GasFeeBurned      = "GasFeeBurned"
+	GasFeeTips        = "GasFeeTips"
+	GasFeeMaxHeadroom = "GasFeeMaxHeadroom"
+
 	FlashBotsFailedTxSent = "FlashBotsFailedTxSent"
 )
 
@@ -47,5 +55,17 @@ var AddressStatsKeys = [...]string{
 	ValueSentWei, ValueReceivedWei,
 	Erc20TokensSent, Erc20TokensReceived, Erc20TokensTransferred,
 	GasUsed, GasFeeTotal, GasFeeFailedTx,
+	GasFeeBurned, GasFeeTips, GasFeeMaxHeadroom,
 	FlashBotsFailedTxSent,
 }
+
+// Types of token stats (rankings of ERC20 tokens)
+var (
+	TokenNumTransfers       string = "NumTransfers"
+	TokenNumUniqueSenders          = "NumUniqueSenders"
+	TokenNumUniqueReceivers        = "NumUniqueReceivers"
+)
+
+var TokenStatsKeys = [...]string{
+	TokenNumTransfers, TokenNumUniqueSenders, TokenNumUniqueReceivers,
+}
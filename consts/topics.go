@@ -0,0 +1,10 @@
+package consts
+
+import "github.com/ethereum/go-ethereum/common"
+
+// Event topics
+var (
+	// Transfer(address indexed from, address indexed to, uint256 value) for ERC20, and
+	// Transfer(address indexed from, address indexed to, uint256 indexed tokenId) for ERC721
+	TopicTransfer = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrBlockHashMismatch is returned if a block doesn't extend the previous block
+var ErrBlockHashMismatch = errors.New("parent hash doesn't match the previous block")
+
+// BlockStats are the statistics of a single block, for the block table and time series
+type BlockStats struct {
+	Number     int64
+	Hash       string
+	ParentHash string // to check that the blocks of an analysis are from the same chain (see CheckBlockContinuity)
+	Time       uint64
+	Miner      string
+	BaseFee    *big.Int // zero before London
+	GasUsed    uint64
+	GasLimit   uint64
+
+	NumTx          int
+	NumTxByType    map[uint8]int // all transactions, including failed ones
+	NumTxFailed    int
+	NumFlashbotsTx int
+
+	GasFeeTotal  *big.Int
+	GasFeeBurned *big.Int
+	GasFeeTips   *big.Int
+	MaxTxValue   *big.Int
+}
+
+// NewBlockStats fills the fields that only depend on the block itself. The fee and failed/flashbots tx counts depend on
+// the receipts and are set while processing the transactions.
+func NewBlockStats(block *types.Block) BlockStats {
+	stats := BlockStats{
+		Number:     block.Number().Int64(),
+		Hash:       block.Hash().Hex(),
+		ParentHash: block.ParentHash().Hex(),
+		Time:       block.Time(),
+		Miner:      strings.ToLower(block.Coinbase().Hex()),
+		BaseFee:    new(big.Int),
+		GasUsed:    block.GasUsed(),
+		GasLimit:   block.GasLimit(),
+
+		NumTx:       len(block.Transactions()),
+		NumTxByType: make(map[uint8]int),
+
+		GasFeeTotal:  new(big.Int),
+		GasFeeBurned: new(big.Int),
+		GasFeeTips:   new(big.Int),
+		MaxTxValue:   new(big.Int),
+	}
+
+	if block.BaseFee() != nil {
+		stats.BaseFee = block.BaseFee()
+	}
+
+	for _, tx := range block.Transactions() {
+		stats.NumTxByType[tx.Type()] += 1
+		if tx.Value().Cmp(stats.MaxTxValue) == 1 {
+			stats.MaxTxValue = tx.Value()
+		}
+	}
+
+	return stats
+}
+
+// CheckBlockContinuity returns ErrBlockHashMismatch if the parent hash of a block is not the hash of the previous block,
+// i.e. the blocks of the analysis are from different forks (a chain reorg while the blocks were fetched).
+func (analysis *Analysis) CheckBlockContinuity() error {
+	for i := 1; i < len(analysis.Blocks); i++ {
+		prev, block := analysis.Blocks[i-1], analysis.Blocks[i]
+		if block.Number == prev.Number+1 && block.ParentHash != prev.Hash {
+			return fmt.Errorf("%w: parent of block %d is %s, but block %d is %s", ErrBlockHashMismatch, block.Number, block.ParentHash, prev.Number, prev.Hash)
+		}
+	}
+	return nil
+}
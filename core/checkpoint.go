@@ -0,0 +1,84 @@
+package core
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrCheckpointMismatch is returned when resuming from a checkpoint of another block range
+var ErrCheckpointMismatch = errors.New("checkpoint is for another block range")
+
+// Checkpoint is the state of the analysis of the blocks StartHeight to EndHeight, after processing all blocks before
+// NextHeight. The analysis is a full JSON export (see NewFullAnalysisJsonExport), before the Build* functions.
+type Checkpoint struct {
+	StartHeight int64              `json:"startHeight"`
+	EndHeight   int64              `json:"endHeight"`
+	NextHeight  int64              `json:"nextHeight"`
+	Analysis    AnalysisJsonExport `json:"analysis"`
+}
+
+func NewCheckpoint(analysis *Analysis, startHeight int64, endHeight int64, nextHeight int64) Checkpoint {
+	return Checkpoint{
+		StartHeight: startHeight,
+		EndHeight:   endHeight,
+		NextHeight:  nextHeight,
+		Analysis:    NewFullAnalysisJsonExport(analysis),
+	}
+}
+
+// CheckRange returns ErrCheckpointMismatch if the checkpoint is not for the blocks startHeight to endHeight
+func (cp Checkpoint) CheckRange(startHeight int64, endHeight int64) error {
+	if cp.StartHeight != startHeight || cp.EndHeight != endHeight {
+		return fmt.Errorf("%w: blocks %d-%d instead of %d-%d", ErrCheckpointMismatch, cp.StartHeight, cp.EndHeight, startHeight, endHeight)
+	}
+	return nil
+}
+
+// RestoreAnalysis returns the analysis of the checkpoint, to continue with the block NextHeight. txRankers must be the
+// rankings of the analysis that saved the checkpoint (see NewAnalysis).
+func (cp Checkpoint) RestoreAnalysis(cfg Config, txRankers []TxRanker, ads IAddressDetailService) (*Analysis, error) {
+	return NewAnalysisFromJsonExport(cfg, txRankers, cp.Analysis, ads)
+}
+
+// Save writes the checkpoint as gzip compressed JSON. The file is replaced only after the checkpoint was written
+// completely, so a crash while saving keeps the previous checkpoint.
+func (cp Checkpoint) Save(filename string) error {
+	tmpFilename := filename + ".tmp"
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	if err = json.NewEncoder(zw).Encode(cp); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func LoadCheckpoint(filename string) (cp Checkpoint, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return cp, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return cp, err
+	}
+	defer zr.Close()
+
+	err = json.NewDecoder(zr).Decode(&cp)
+	return cp, err
+}
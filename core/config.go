@@ -1,101 +1,372 @@
 package core
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 type PostgresConfig struct {
-	User       string
-	Password   string
-	Host       string
-	Name       string
-	DisableTLS bool
+	User       string `yaml:"user"`
+	Password   string `yaml:"password"`
+	Host       string `yaml:"host"`
+	Name       string `yaml:"name"`
+	DisableTLS bool   `yaml:"disableTLS"`
+}
+
+// RpcConfig configures the requests to EthNode and its fallbacks (see rpcpool.Dial)
+type RpcConfig struct {
+	FallbackNodes       []string      `yaml:"fallbackNodes"`       // HTTP endpoints used in this order when EthNode fails
+	RequestsPerSecond   float64       `yaml:"requestsPerSecond"`   // budget of each endpoint, 0 for no limit
+	MaxRetries          int           `yaml:"maxRetries"`          // retries of a failed request, on the next endpoint
+	Timeout             time.Duration `yaml:"timeout"`             // of a single request
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"` // unhealthy endpoints are checked again after this time
 }
 
+// Config is loaded in layers, each overriding the previous one: the defaults, a profile, a YAML config file,
+// environment variables and command line flags (see LoadConfig).
 type Config struct {
-	EthNode string
+	Profile string `yaml:"profile"`
+
+	EthNode string    `yaml:"ethNode"`
+	Rpc     RpcConfig `yaml:"rpc"`
+
+	Database PostgresConfig `yaml:"database"`
 
-	Database PostgresConfig
+	WebserverHost string `yaml:"webserverHost"`
+	WebserverPort int    `yaml:"webserverPort"`
 
-	WebserverHost string
-	WebserverPort int
+	NumTopAddresses    int `yaml:"numTopAddresses"`
+	NumTopTransactions int `yaml:"numTopTransactions"`
 
-	NumTopAddresses    int
-	NumTopTransactions int
+	// If > 0, the top addresses are approximated with this many counters per stats key (see AddressSketch), instead of
+	// keeping the stats of all addresses. Memory is then bounded, and the top values are upper bounds.
+	AddressSketchSize int `yaml:"addressSketchSize"`
 
-	EthplorerApiKey string // not needed
+	NumShards int `yaml:"numShards"` // number of workers processing blocks in parallel
+
+	ConfirmationDepth int64 `yaml:"confirmationDepth"` // blocks closer to the head than this are not analyzed yet, because they can still be reorged
+
+	EthplorerApiKey string `yaml:"ethplorerApiKey"` // not needed
+
+	AddressCacheDir string `yaml:"addressCacheDir"` // LevelDB cache of address details, used if no database is configured
 
 	// Debug helpers
-	Debug                 bool
-	HideOutput            bool
-	DebugPrintFlashbotsTx bool
-	LowApiCallMode        bool
+	Debug                 bool `yaml:"debug"`
+	HideOutput            bool `yaml:"hideOutput"`
+	DebugPrintFlashbotsTx bool `yaml:"debugPrintFlashbotsTx"`
+	LowApiCallMode        bool `yaml:"lowApiCallMode"`
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("eth:%s psql:%s@%s/%s, numAddr:%d, numTx:%d, debug=%t, lowApiCall=%t", c.EthNode, c.Database.User, c.Database.Host, c.Database.Name, c.NumTopAddresses, c.NumTopTransactions, c.Debug, c.LowApiCallMode)
+	return fmt.Sprintf("eth:%s psql:%s@%s/%s, numAddr:%d, numTx:%d, shards:%d, confirmations:%d, debug=%t, lowApiCall=%t", c.EthNode, c.Database.User, c.Database.Host, c.Database.Name, c.NumTopAddresses, c.NumTopTransactions, c.NumShards, c.ConfirmationDepth, c.Debug, c.LowApiCallMode)
 }
 
-func getEnvStr(key string, defaultVal string) string {
-	val, exists := os.LookupEnv(key)
-	if exists {
-		return val
-	} else {
-		return defaultVal
+// ErrEthNodeMissing is returned by commands that need a node connection, if no node is configured
+var ErrEthNodeMissing = errors.New("no Ethereum node configured (ETH_NODE, -ethNode or ethNode in the config file)")
+
+// ErrUnknownProfile is returned when loading a config with a profile that is not in Profiles
+var ErrUnknownProfile = errors.New("unknown config profile")
+
+const DefaultProfile = "mainnet"
+
+func DefaultConfig() Config {
+	return Config{
+		Profile: DefaultProfile,
+
+		WebserverPort: 8090,
+
+		EthplorerApiKey: "freekey",
+
+		NumTopAddresses:    25,
+		NumTopTransactions: 20,
+
+		NumShards: runtime.NumCPU(),
+
+		ConfirmationDepth: 12,
+
+		Rpc: RpcConfig{
+			MaxRetries:          5,
+			Timeout:             30 * time.Second,
+			HealthCheckInterval: 30 * time.Second,
+		},
 	}
 }
 
-func getEnvBool(key string, defaultVal bool) bool {
-	val, exists := os.LookupEnv(key)
-	if exists && len(val) > 0 {
-		return true
-	} else {
-		return defaultVal
+// Profiles change the defaults for common setups. They are applied before the config file, env vars and flags.
+var Profiles = map[string]func(cfg *Config){
+	"mainnet": func(cfg *Config) {}, // the defaults: a full node, and blocks with 12 confirmations
+
+	// Historical analyses with an archive node: more workers to hide the latency of the API calls, and longer top lists
+	"archive": func(cfg *Config) {
+		cfg.NumShards = 2 * runtime.NumCPU()
+		cfg.NumTopAddresses = 100
+		cfg.NumTopTransactions = 50
+		cfg.ConfirmationDepth = 64
+	},
+
+	// Local node and database (like .env.example), a single worker and no confirmations (dev chains don't reorg)
+	"dev": func(cfg *Config) {
+		cfg.EthNode = "http://localhost:8545"
+		cfg.Database = PostgresConfig{User: "user1", Password: "password", Host: "localhost", Name: "ethstats", DisableTLS: true}
+		cfg.WebserverHost = "localhost"
+		cfg.NumShards = 1
+		cfg.ConfirmationDepth = 0
+	},
+}
+
+// ProfileNames returns the names of the Profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
-func getEnvInt(key string, defaultVal int) int {
-	val, exists := os.LookupEnv(key)
-	if exists {
-		intVal, err := strconv.Atoi(val)
-		if err != nil {
-			panic(fmt.Sprintf("Invalid value for key %s = %s", key, val))
+// LoadConfig loads the config layers: the defaults, the profile, the YAML file (if filename is not empty), the env vars
+// and the flags that were set (flags can be nil). The profile is taken from the flags, the PROFILE env var or the
+// config file, in this order. Invalid values are returned as ConfigErrors.
+func LoadConfig(filename string, flags *ConfigFlags) (cfg Config, err error) {
+	var fileContent []byte
+	var fileProfile struct {
+		Profile string `yaml:"profile"`
+	}
+	if len(filename) > 0 {
+		if fileContent, err = ioutil.ReadFile(filename); err != nil {
+			return cfg, err
+		}
+		if err = yaml.Unmarshal(fileContent, &fileProfile); err != nil {
+			return cfg, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	cfg = DefaultConfig()
+	profile := DefaultProfile
+	if flags != nil && len(flags.Profile) > 0 {
+		profile = flags.Profile
+	} else if envProfile := os.Getenv("PROFILE"); len(envProfile) > 0 {
+		profile = envProfile
+	} else if len(fileProfile.Profile) > 0 {
+		profile = fileProfile.Profile
+	}
+	applyProfile, found := Profiles[profile]
+	if !found {
+		return cfg, fmt.Errorf("%w %q (available: %s)", ErrUnknownProfile, profile, strings.Join(ProfileNames(), ", "))
+	}
+	applyProfile(&cfg)
+
+	if len(fileContent) > 0 {
+		if err = yaml.UnmarshalStrict(fileContent, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", filename, err)
 		}
-		return intVal
-	} else {
-		return defaultVal
 	}
+	cfg.Profile = profile
+
+	if err = cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+
+	if flags != nil {
+		flags.fs.Visit(func(f *flag.Flag) {
+			if apply, found := flags.apply[f.Name]; found {
+				apply(&cfg)
+			}
+		})
+	}
+
+	return cfg, cfg.Validate()
 }
 
-// var config *Config
-var Cfg Config = Config{
-	Database: PostgresConfig{
-		User:       getEnvStr("DB_USER", ""),
-		Password:   getEnvStr("DB_PASS", ""),
-		Host:       getEnvStr("DB_HOST", ""),
-		Name:       getEnvStr("DB_NAME", ""),
-		DisableTLS: len(getEnvStr("DB_DISABLE_TLS", "")) > 0,
+// envVars set the config fields from the environment variables
+var envVars = map[string]func(cfg *Config, val string) error{
+	"ETH_NODE":           func(cfg *Config, val string) error { cfg.EthNode = val; return nil },
+	"ETH_NODE_FALLBACKS": func(cfg *Config, val string) error { cfg.Rpc.FallbackNodes = strings.Split(val, ","); return nil },
+	"RPC_RETRIES":        func(cfg *Config, val string) error { return parseEnvInt(val, &cfg.Rpc.MaxRetries) },
+	"RPC_RATE_LIMIT": func(cfg *Config, val string) (err error) {
+		cfg.Rpc.RequestsPerSecond, err = strconv.ParseFloat(val, 64)
+		return err
+	},
+	"RPC_TIMEOUT": func(cfg *Config, val string) (err error) {
+		cfg.Rpc.Timeout, err = time.ParseDuration(val)
+		return err
 	},
+	"DB_USER":           func(cfg *Config, val string) error { cfg.Database.User = val; return nil },
+	"DB_PASS":           func(cfg *Config, val string) error { cfg.Database.Password = val; return nil },
+	"DB_HOST":           func(cfg *Config, val string) error { cfg.Database.Host = val; return nil },
+	"DB_NAME":           func(cfg *Config, val string) error { cfg.Database.Name = val; return nil },
+	"DB_DISABLE_TLS":    func(cfg *Config, val string) error { cfg.Database.DisableTLS = len(val) > 0; return nil },
+	"WEBSERVER_HOST":    func(cfg *Config, val string) error { cfg.WebserverHost = val; return nil },
+	"WEBSERVER_PORT":    func(cfg *Config, val string) error { return parseEnvInt(val, &cfg.WebserverPort) },
+	"ETHPLORER_API_KEY": func(cfg *Config, val string) error { cfg.EthplorerApiKey = val; return nil },
+	"ADDRESS_CACHE_DIR": func(cfg *Config, val string) error { cfg.AddressCacheDir = val; return nil },
+	"NUM_TOP_ADDR":      func(cfg *Config, val string) error { return parseEnvInt(val, &cfg.NumTopAddresses) },
+	"NUM_TOP_TX":        func(cfg *Config, val string) error { return parseEnvInt(val, &cfg.NumTopTransactions) },
+	"ADDR_SKETCH_SIZE":  func(cfg *Config, val string) error { return parseEnvInt(val, &cfg.AddressSketchSize) },
+	"NUM_SHARDS":        func(cfg *Config, val string) error { return parseEnvInt(val, &cfg.NumShards) },
+	"CONFIRMATIONS": func(cfg *Config, val string) (err error) {
+		cfg.ConfirmationDepth, err = strconv.ParseInt(val, 10, 64)
+		return err
+	},
+	"DEBUG":       func(cfg *Config, val string) error { cfg.Debug = len(val) > 0; return nil },
+	"HIDE_OUTPUT": func(cfg *Config, val string) error { cfg.HideOutput = len(val) > 0; return nil },
+	"MEV":         func(cfg *Config, val string) error { cfg.DebugPrintFlashbotsTx = len(val) > 0; return nil },
+	"LOW_API":     func(cfg *Config, val string) error { cfg.LowApiCallMode = len(val) > 0; return nil },
+}
+
+func parseEnvInt(val string, dest *int) (err error) {
+	*dest, err = strconv.Atoi(val)
+	return err
+}
+
+func (cfg *Config) applyEnv() error {
+	errs := make(ConfigErrors, 0)
+	for key, apply := range envVars {
+		if val, exists := os.LookupEnv(key); exists {
+			if err := apply(cfg, val); err != nil {
+				errs = append(errs, fmt.Sprintf("env %s: invalid value %q", key, val))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errs
+	}
+	return nil
+}
 
-	WebserverHost: getEnvStr("WEBSERVER_HOST", ""),
-	WebserverPort: getEnvInt("WEBSERVER_PORT", 8090),
+// ConfigFlags are the command line flags of the config (see RegisterConfigFlags)
+type ConfigFlags struct {
+	File    string
+	Profile string
 
-	EthNode:         getEnvStr("ETH_NODE", ""),
-	EthplorerApiKey: getEnvStr("ETHPLORER_API_KEY", "freekey"),
+	fs     *flag.FlagSet
+	values Config                       // the parsed flag values
+	apply  map[string]func(cfg *Config) // copies the value of a flag into the config
+}
 
-	NumTopAddresses:    getEnvInt("NUM_TOP_ADDR", 25),
-	NumTopTransactions: getEnvInt("NUM_TOP_TX", 20),
+// RegisterConfigFlags adds -config, -profile and flags for the most used config fields to fs. After parsing, Load
+// returns the config with the flags that were set as the last layer.
+func RegisterConfigFlags(fs *flag.FlagSet) *ConfigFlags {
+	f := &ConfigFlags{fs: fs}
+	v := &f.values
+	fs.StringVar(&f.File, "config", os.Getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+	fs.StringVar(&f.Profile, "profile", "", fmt.Sprintf("config profile: %s (env PROFILE, default %s)", strings.Join(ProfileNames(), ", "), DefaultProfile))
+
+	fs.StringVar(&v.EthNode, "ethNode", "", "Ethereum node URL or IPC path (env ETH_NODE)")
+	fs.Float64Var(&v.Rpc.RequestsPerSecond, "rpcRateLimit", 0, "max. requests per second to each Ethereum node, 0 for no limit (env RPC_RATE_LIMIT)")
+	fs.StringVar(&v.Database.Host, "dbHost", "", "database host (env DB_HOST)")
+	fs.StringVar(&v.Database.Name, "dbName", "", "database name (env DB_NAME)")
+	fs.IntVar(&v.NumShards, "shards", 0, "number of workers processing blocks in parallel (env NUM_SHARDS, default: number of CPUs)")
+	fs.Int64Var(&v.ConfirmationDepth, "confirmations", 0, "only analyze blocks with at least this many confirmations, newer blocks can still be reorged (env CONFIRMATIONS, default 12)")
+	fs.IntVar(&v.NumTopAddresses, "numTopAddr", 0, "number of addresses in the top lists (env NUM_TOP_ADDR)")
+	fs.IntVar(&v.NumTopTransactions, "numTopTx", 0, "number of transactions in the top lists (env NUM_TOP_TX)")
+	fs.IntVar(&v.AddressSketchSize, "addressSketch", 0, "approximate the top addresses with this many counters per stat, for bounded memory (env ADDR_SKETCH_SIZE, default 0: exact)")
+	fs.StringVar(&v.AddressCacheDir, "addressCache", "", "LevelDB cache of address details, used if no database is configured (env ADDRESS_CACHE_DIR)")
+	fs.IntVar(&v.WebserverPort, "webserverPort", 0, "webserver port (env WEBSERVER_PORT)")
+	fs.BoolVar(&v.LowApiCallMode, "lowApi", false, "low-api-call mode (env LOW_API)")
+	fs.BoolVar(&v.HideOutput, "hideOutput", false, "don't print the analysis result (env HIDE_OUTPUT)")
+
+	f.apply = map[string]func(cfg *Config){
+		"ethNode":       func(cfg *Config) { cfg.EthNode = v.EthNode },
+		"rpcRateLimit":  func(cfg *Config) { cfg.Rpc.RequestsPerSecond = v.Rpc.RequestsPerSecond },
+		"dbHost":        func(cfg *Config) { cfg.Database.Host = v.Database.Host },
+		"dbName":        func(cfg *Config) { cfg.Database.Name = v.Database.Name },
+		"shards":        func(cfg *Config) { cfg.NumShards = v.NumShards },
+		"confirmations": func(cfg *Config) { cfg.ConfirmationDepth = v.ConfirmationDepth },
+		"numTopAddr":    func(cfg *Config) { cfg.NumTopAddresses = v.NumTopAddresses },
+		"numTopTx":      func(cfg *Config) { cfg.NumTopTransactions = v.NumTopTransactions },
+		"addressSketch": func(cfg *Config) { cfg.AddressSketchSize = v.AddressSketchSize },
+		"addressCache":  func(cfg *Config) { cfg.AddressCacheDir = v.AddressCacheDir },
+		"webserverPort": func(cfg *Config) { cfg.WebserverPort = v.WebserverPort },
+		"lowApi":        func(cfg *Config) { cfg.LowApiCallMode = v.LowApiCallMode },
+		"hideOutput":    func(cfg *Config) { cfg.HideOutput = v.HideOutput },
+	}
+	return f
+}
+
+// Load returns the config of the -config file and -profile, with the flags that were set (see LoadConfig)
+func (f *ConfigFlags) Load() (Config, error) {
+	return LoadConfig(f.File, f)
+}
+
+// ConfigErrors are all invalid values found while loading or validating a config
+type ConfigErrors []string
+
+func (errs ConfigErrors) Error() string {
+	return "invalid config:\n  - " + strings.Join(errs, "\n  - ")
+}
 
-	Debug:                 getEnvBool("DEBUG", false),
-	HideOutput:            getEnvBool("HIDE_OUTPUT", false),
-	DebugPrintFlashbotsTx: getEnvBool("MEV", false),
-	LowApiCallMode:        getEnvBool("LOW_API", false),
+// Validate returns ConfigErrors with all invalid values, or nil
+func (cfg Config) Validate() error {
+	errs := make(ConfigErrors, 0)
+	if parts := strings.SplitN(cfg.EthNode, "://", 2); len(parts) == 2 {
+		if scheme := parts[0]; scheme != "http" && scheme != "https" && scheme != "ws" && scheme != "wss" {
+			errs = append(errs, fmt.Sprintf("ethNode: unsupported scheme %q (use http, https, ws, wss or an IPC path)", scheme))
+		}
+	}
+	for _, node := range cfg.Rpc.FallbackNodes {
+		if !strings.HasPrefix(node, "http://") && !strings.HasPrefix(node, "https://") {
+			errs = append(errs, fmt.Sprintf("rpc.fallbackNodes: %q is not an HTTP endpoint", node))
+		}
+	}
+	if len(cfg.Rpc.FallbackNodes) > 0 && !strings.HasPrefix(cfg.EthNode, "http://") && !strings.HasPrefix(cfg.EthNode, "https://") {
+		errs = append(errs, "rpc.fallbackNodes: only supported if ethNode is an HTTP endpoint")
+	}
+	if cfg.Rpc.RequestsPerSecond < 0 {
+		errs = append(errs, fmt.Sprintf("rpc.requestsPerSecond: must not be negative, is %g", cfg.Rpc.RequestsPerSecond))
+	}
+	if cfg.Rpc.MaxRetries < 0 {
+		errs = append(errs, fmt.Sprintf("rpc.maxRetries: must not be negative, is %d", cfg.Rpc.MaxRetries))
+	}
+	if cfg.Rpc.Timeout <= 0 {
+		errs = append(errs, fmt.Sprintf("rpc.timeout: must be positive, is %s", cfg.Rpc.Timeout))
+	}
+	if db := cfg.Database; len(db.Host) > 0 || len(db.Name) > 0 || len(db.User) > 0 {
+		if len(db.Host) == 0 || len(db.Name) == 0 || len(db.User) == 0 {
+			errs = append(errs, "database: host, name and user are required if a database is configured")
+		}
+	}
+	if cfg.WebserverPort < 1 || cfg.WebserverPort > 65535 {
+		errs = append(errs, fmt.Sprintf("webserverPort: %d is not a valid port", cfg.WebserverPort))
+	}
+	if cfg.NumTopAddresses < 1 {
+		errs = append(errs, fmt.Sprintf("numTopAddresses: must be at least 1, is %d", cfg.NumTopAddresses))
+	}
+	if cfg.NumTopTransactions < 1 {
+		errs = append(errs, fmt.Sprintf("numTopTransactions: must be at least 1, is %d", cfg.NumTopTransactions))
+	}
+	if cfg.AddressSketchSize != 0 && cfg.AddressSketchSize < cfg.NumTopAddresses {
+		errs = append(errs, fmt.Sprintf("addressSketchSize: must be 0 or at least numTopAddresses (%d), is %d", cfg.NumTopAddresses, cfg.AddressSketchSize))
+	}
+	if cfg.NumShards < 1 {
+		errs = append(errs, fmt.Sprintf("numShards: must be at least 1, is %d", cfg.NumShards))
+	}
+	if cfg.ConfirmationDepth < 0 {
+		errs = append(errs, fmt.Sprintf("confirmationDepth: must not be negative, is %d", cfg.ConfirmationDepth))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-func init() {
-	if len(Cfg.EthNode) == 0 {
-		panic("ETH_NODE environment variable not found")
+// Yaml returns the config in the format of the config file, with the database password masked
+func (cfg Config) Yaml() string {
+	if len(cfg.Database.Password) > 0 {
+		cfg.Database.Password = "********"
 	}
+	b, _ := yaml.Marshal(cfg)
+	return string(b)
 }
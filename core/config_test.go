@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// setenv sets an environment variable for the test (t.Setenv needs Go 1.17)
+func setenv(t *testing.T, key string, value string) {
+	t.Helper()
+	prev, found := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if found {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	filename := writeConfigFile(t, `
+profile: dev
+numTopAddresses: 40
+numTopTransactions: 30
+database:
+  name: ethstats_test
+rpc:
+  timeout: 5s
+`)
+	setenv(t, "NUM_TOP_TX", "35")
+	setenv(t, "NUM_SHARDS", "4")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", filename, "-shards", "8"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+	}{
+		{"profile from the file", cfg.Profile, "dev"},
+		{"value of the profile", cfg.EthNode, "http://localhost:8545"},
+		{"nested value of the profile", cfg.Database.User, "user1"},
+		{"nested value from the file", cfg.Database.Name, "ethstats_test"},
+		{"duration from the file", cfg.Rpc.Timeout, 5 * time.Second},
+		{"value from the file", cfg.NumTopAddresses, 40},
+		{"env overrides the file", cfg.NumTopTransactions, 35},
+		{"flag overrides the env", cfg.NumShards, 8},
+		{"flag that was not set", cfg.ConfirmationDepth, int64(0)},
+		{"default", cfg.WebserverPort, 8090},
+	} {
+		if tc.actual != tc.expected {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.actual, tc.expected)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	if _, err := LoadConfig(writeConfigFile(t, "profile: devnet\n"), nil); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("unknown profile: got error %v", err)
+	}
+	if _, err := LoadConfig(writeConfigFile(t, "numShard: 2\n"), nil); err == nil {
+		t.Error("unknown key in the config file: got no error")
+	}
+
+	setenv(t, "NUM_SHARDS", "many")
+	var configErrors ConfigErrors
+	if _, err := LoadConfig("", nil); !errors.As(err, &configErrors) || len(configErrors) != 1 {
+		t.Errorf("invalid env var: got error %v", err)
+	}
+
+	setenv(t, "NUM_SHARDS", "0")
+	setenv(t, "ETH_NODE", "ftp://localhost")
+	setenv(t, "DB_HOST", "localhost")
+	setenv(t, "ADDR_SKETCH_SIZE", "10") // fewer counters than top addresses
+	if _, err := LoadConfig("", nil); !errors.As(err, &configErrors) || len(configErrors) != 4 {
+		t.Errorf("invalid values: got error %v, want 4 ConfigErrors", err)
+	}
+}
@@ -0,0 +1,421 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+// AnalysisJsonExportVersion is increased on every incompatible change of the JSON export schema
+//
+// Version 2: topTransactions is keyed by the TxRanker names ("GasFee" instead of "gasFee")
+const AnalysisJsonExportVersion = 2
+
+// AddressStatsJson is AddressStats with all values as decimal strings
+type AddressStatsJson struct {
+	AddressDetail addressdetail.AddressDetail `json:"addressDetail"`
+	Stats         map[string]string           `json:"stats"`
+}
+
+// TxStatsJson is TxStats with all big.Int values as decimal strings
+type TxStatsJson struct {
+	Hash              string                      `json:"hash"`
+	FromAddr          addressdetail.AddressDetail `json:"fromAddr"`
+	ToAddr            addressdetail.AddressDetail `json:"toAddr"`
+	GasUsed           string                      `json:"gasUsed"`
+	GasFee            string                      `json:"gasFee"`
+	GasFeeBurned      string                      `json:"gasFeeBurned"`
+	GasFeeTip         string                      `json:"gasFeeTip"`
+	EffectiveGasPrice string                      `json:"effectiveGasPrice"`
+	Value             string                      `json:"value"`
+	DataSize          int                         `json:"dataSize"`
+	NumLogs           int                         `json:"numLogs"`
+	Success           bool                        `json:"success"`
+	Tag               string                      `json:"tag,omitempty"`
+
+	Erc20TransferToken addressdetail.AddressDetail `json:"erc20TransferToken"`
+	Erc20TransferValue string                      `json:"erc20TransferValue"`
+}
+
+// AddressSketchJson is an AddressSketch with all values as decimal strings, counters highest first
+type AddressSketchJson struct {
+	Key      string               `json:"key"`
+	Capacity int                  `json:"capacity"`
+	Total    string               `json:"total"`
+	Counters []AddressCounterJson `json:"counters"`
+}
+
+// AddressCounterJson is an AddressCounter with the values as decimal strings
+type AddressCounterJson struct {
+	Address string `json:"address"`
+	Count   string `json:"count"`
+	Error   string `json:"error"`
+}
+
+// TokenStatsJson is TokenStats with the volume as decimal string
+type TokenStatsJson struct {
+	Token              addressdetail.AddressDetail `json:"token"`
+	NumTransfers       int                         `json:"numTransfers"`
+	NumUniqueSenders   int                         `json:"numUniqueSenders"`
+	NumUniqueReceivers int                         `json:"numUniqueReceivers"`
+	Volume             string                      `json:"volume"`
+}
+
+// AddressTokenStatsJson is AddressTokenStats with the amounts as decimal strings
+type AddressTokenStatsJson struct {
+	Address        string `json:"address"`
+	Token          string `json:"token"`
+	NumSent        int    `json:"numSent"`
+	NumReceived    int    `json:"numReceived"`
+	AmountSent     string `json:"amountSent"`
+	AmountReceived string `json:"amountReceived"`
+}
+
+// BlockStatsJson is BlockStats with all big.Int values as decimal strings
+type BlockStatsJson struct {
+	Number     int64  `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"`
+	Time       uint64 `json:"time"`
+	Miner      string `json:"miner"`
+	BaseFee    string `json:"baseFee"`
+	GasUsed    uint64 `json:"gasUsed"`
+	GasLimit   uint64 `json:"gasLimit"`
+
+	NumTx          int           `json:"numTx"`
+	NumTxByType    map[uint8]int `json:"numTxByType"`
+	NumTxFailed    int           `json:"numTxFailed"`
+	NumFlashbotsTx int           `json:"numFlashbotsTx"`
+
+	GasFeeTotal  string `json:"gasFeeTotal"`
+	GasFeeBurned string `json:"gasFeeBurned"`
+	GasFeeTips   string `json:"gasFeeTips"`
+	MaxTxValue   string `json:"maxTxValue"`
+}
+
+// MinerStatsJson is MinerStats with the tips as decimal string, and the calculated shares
+type MinerStatsJson struct {
+	Miner          addressdetail.AddressDetail `json:"miner"`
+	NumBlocks      int                         `json:"numBlocks"`
+	NumBlocksEmpty int                         `json:"numBlocksEmpty"`
+	NumTx          int                         `json:"numTx"`
+	NumFlashbotsTx int                         `json:"numFlashbotsTx"`
+	GasUsed        uint64                      `json:"gasUsed"`
+	GasLimit       uint64                      `json:"gasLimit"`
+	TipsEarned     string                      `json:"tipsEarned"`
+
+	GasUtilization float64 `json:"gasUtilization"` // percent
+	FlashbotsShare float64 `json:"flashbotsShare"` // percent of tx
+}
+
+// AnalysisJsonExport is the versioned JSON document of AnalysisData. big.Int values are encoded as decimal strings.
+type AnalysisJsonExport struct {
+	Version int `json:"version"`
+
+	StartBlockNumber    int64  `json:"startBlockNumber"`
+	StartBlockTimestamp uint64 `json:"startBlockTimestamp"`
+	EndBlockNumber      int64  `json:"endBlockNumber"`
+	EndBlockTimestamp   uint64 `json:"endBlockTimestamp"`
+
+	TopAddresses       map[string][]AddressStatsJson `json:"topAddresses"`
+	TopTokens          map[string][]TokenStatsJson   `json:"topTokens"`
+	TopMiners          []MinerStatsJson              `json:"topMiners"`
+	TopTransactions    map[string][]TxStatsJson      `json:"topTransactions"`
+	TaggedTransactions []TxStatsJson                 `json:"taggedTransactions"`
+
+	TxTypes       map[uint8]int `json:"txTypes"`
+	ValueTotalWei string        `json:"valueTotalWei"`
+
+	NumBlocks            int    `json:"numBlocks"`
+	NumBlocksWithoutTx   int    `json:"numBlocksWithoutTx"`
+	GasUsed              string `json:"gasUsed"`
+	GasFeeTotal          string `json:"gasFeeTotal"`
+	GasFeeFailedTx       string `json:"gasFeeFailedTx"`
+	GasFeeBurned         string `json:"gasFeeBurned"`
+	GasFeeTips           string `json:"gasFeeTips"`
+	GasFeeMaxHeadroom    string `json:"gasFeeMaxHeadroom"`
+	AvgEffectiveGasPrice string `json:"avgEffectiveGasPrice"`
+
+	NumAddresses      int `json:"numAddresses"`
+	AddressSketchSize int `json:"addressSketchSize,omitempty"` // if > 0, the top addresses are approximate (see Config.AddressSketchSize)
+
+	NumTransactions              int `json:"numTransactions"`
+	NumTransactionsFailed        int `json:"numTransactionsFailed"`
+	NumTransactionsWithZeroValue int `json:"numTransactionsWithZeroValue"`
+	NumTransactionsWithData      int `json:"numTransactionsWithData"`
+
+	NumTransactionsErc20Transfer  int `json:"numTransactionsErc20Transfer"`
+	NumTransactionsErc721Transfer int `json:"numTransactionsErc721Transfer"`
+
+	NumFlashbotsTransactionsSuccess int `json:"numFlashbotsTransactionsSuccess"`
+	NumFlashbotsTransactionsFailed  int `json:"numFlashbotsTransactionsFailed"`
+
+	Errors []AnalysisError `json:"errors,omitempty"`
+
+	// Stats of all addresses, tokens, miners and blocks, only in full exports (see NewFullAnalysisJsonExport). Full
+	// exports can be loaded and merged with other analyses (see LoadAnalysisJson).
+	Full            bool                    `json:"full,omitempty"`
+	Addresses       []AddressStatsJson      `json:"addresses,omitempty"`
+	AddressSketches []AddressSketchJson     `json:"addressSketches,omitempty"` // instead of the addresses
+	AddressTokens   []AddressTokenStatsJson `json:"addressTokens,omitempty"`
+	Miners          []MinerStatsJson        `json:"miners,omitempty"`
+	Blocks          []BlockStatsJson        `json:"blocks,omitempty"`
+}
+
+func bigIntToJson(i *big.Int) string {
+	if i == nil {
+		return "0"
+	}
+	return i.String()
+}
+
+func NewAddressStatsJson(stats AddressStats) AddressStatsJson {
+	ret := AddressStatsJson{
+		AddressDetail: stats.AddressDetail,
+		Stats:         make(map[string]string, len(stats.Stats)),
+	}
+	for k, v := range stats.Stats {
+		ret.Stats[k] = bigIntToJson(v)
+	}
+	return ret
+}
+
+func NewAddressSketchJson(sketch *AddressSketch) AddressSketchJson {
+	ret := AddressSketchJson{
+		Key:      sketch.Key,
+		Capacity: sketch.Capacity,
+		Total:    bigIntToJson(sketch.Total),
+	}
+	for _, counter := range sketch.Sorted() {
+		ret.Counters = append(ret.Counters, AddressCounterJson{Address: counter.Address, Count: bigIntToJson(counter.Count), Error: bigIntToJson(counter.Error)})
+	}
+	return ret
+}
+
+func NewTokenStatsJson(stats TokenStats) TokenStatsJson {
+	return TokenStatsJson{
+		Token:              stats.Token,
+		NumTransfers:       stats.NumTransfers,
+		NumUniqueSenders:   stats.NumUniqueSenders,
+		NumUniqueReceivers: stats.NumUniqueReceivers,
+		Volume:             bigIntToJson(stats.Volume),
+	}
+}
+
+func NewAddressTokenStatsJson(stats AddressTokenStats) AddressTokenStatsJson {
+	return AddressTokenStatsJson{
+		Address:        stats.Address,
+		Token:          stats.Token,
+		NumSent:        stats.NumSent,
+		NumReceived:    stats.NumReceived,
+		AmountSent:     bigIntToJson(stats.AmountSent),
+		AmountReceived: bigIntToJson(stats.AmountReceived),
+	}
+}
+
+func NewBlockStatsJson(stats BlockStats) BlockStatsJson {
+	return BlockStatsJson{
+		Number:     stats.Number,
+		Hash:       stats.Hash,
+		ParentHash: stats.ParentHash,
+		Time:       stats.Time,
+		Miner:      stats.Miner,
+		BaseFee:    bigIntToJson(stats.BaseFee),
+		GasUsed:    stats.GasUsed,
+		GasLimit:   stats.GasLimit,
+
+		NumTx:          stats.NumTx,
+		NumTxByType:    stats.NumTxByType,
+		NumTxFailed:    stats.NumTxFailed,
+		NumFlashbotsTx: stats.NumFlashbotsTx,
+
+		GasFeeTotal:  bigIntToJson(stats.GasFeeTotal),
+		GasFeeBurned: bigIntToJson(stats.GasFeeBurned),
+		GasFeeTips:   bigIntToJson(stats.GasFeeTips),
+		MaxTxValue:   bigIntToJson(stats.MaxTxValue),
+	}
+}
+
+func NewMinerStatsJson(stats MinerStats) MinerStatsJson {
+	return MinerStatsJson{
+		Miner:          stats.Miner,
+		NumBlocks:      stats.NumBlocks,
+		NumBlocksEmpty: stats.NumBlocksEmpty,
+		NumTx:          stats.NumTx,
+		NumFlashbotsTx: stats.NumFlashbotsTx,
+		GasUsed:        stats.GasUsed,
+		GasLimit:       stats.GasLimit,
+		TipsEarned:     bigIntToJson(stats.TipsEarned),
+		GasUtilization: stats.GasUtilization(),
+		FlashbotsShare: stats.FlashbotsShare(),
+	}
+}
+
+func NewTxStatsJson(stats TxStats) TxStatsJson {
+	return TxStatsJson{
+		Hash:              stats.Hash,
+		FromAddr:          stats.FromAddr,
+		ToAddr:            stats.ToAddr,
+		GasUsed:           bigIntToJson(stats.GasUsed),
+		GasFee:            bigIntToJson(stats.GasFee),
+		GasFeeBurned:      bigIntToJson(stats.GasFeeBurned),
+		GasFeeTip:         bigIntToJson(stats.GasFeeTip),
+		EffectiveGasPrice: bigIntToJson(stats.EffectiveGasPrice),
+		Value:             bigIntToJson(stats.Value),
+		DataSize:          stats.DataSize,
+		NumLogs:           stats.NumLogs,
+		Success:           stats.Success,
+		Tag:               stats.Tag,
+
+		Erc20TransferToken: stats.Erc20TransferToken,
+		Erc20TransferValue: bigIntToJson(stats.Erc20TransferValue),
+	}
+}
+
+func newTxStatsJsonList(list []TxStats) []TxStatsJson {
+	ret := make([]TxStatsJson, len(list))
+	for i, v := range list {
+		ret[i] = NewTxStatsJson(v)
+	}
+	return ret
+}
+
+// NewAnalysisJsonExport converts the analysis into the versioned JSON export structure
+func NewAnalysisJsonExport(analysis *Analysis) AnalysisJsonExport {
+	data := analysis.Data
+	export := AnalysisJsonExport{
+		Version: AnalysisJsonExportVersion,
+
+		StartBlockNumber:    data.StartBlockNumber,
+		StartBlockTimestamp: data.StartBlockTimestamp,
+		EndBlockNumber:      data.EndBlockNumber,
+		EndBlockTimestamp:   data.EndBlockTimestamp,
+
+		TopAddresses:       make(map[string][]AddressStatsJson, len(data.TopAddresses)),
+		TopTokens:          make(map[string][]TokenStatsJson, len(data.TopTokens)),
+		TopMiners:          make([]MinerStatsJson, len(data.TopMiners)),
+		TopTransactions:    make(map[string][]TxStatsJson, len(data.TopTransactions)),
+		TaggedTransactions: newTxStatsJsonList(data.TaggedTransactions),
+
+		TxTypes:       data.TxTypes,
+		ValueTotalWei: bigIntToJson(data.ValueTotalWei),
+
+		NumBlocks:            data.NumBlocks,
+		NumBlocksWithoutTx:   data.NumBlocksWithoutTx,
+		GasUsed:              bigIntToJson(data.GasUsed),
+		GasFeeTotal:          bigIntToJson(data.GasFeeTotal),
+		GasFeeFailedTx:       bigIntToJson(data.GasFeeFailedTx),
+		GasFeeBurned:         bigIntToJson(data.GasFeeBurned),
+		GasFeeTips:           bigIntToJson(data.GasFeeTips),
+		GasFeeMaxHeadroom:    bigIntToJson(data.GasFeeMaxHeadroom),
+		AvgEffectiveGasPrice: bigIntToJson(data.AvgEffectiveGasPrice()),
+
+		NumAddresses:      len(analysis.Addresses),
+		AddressSketchSize: analysis.AddressSketchSize(),
+
+		NumTransactions:              data.NumTransactions,
+		NumTransactionsFailed:        data.NumTransactionsFailed,
+		NumTransactionsWithZeroValue: data.NumTransactionsWithZeroValue,
+		NumTransactionsWithData:      data.NumTransactionsWithData,
+
+		NumTransactionsErc20Transfer:  data.NumTransactionsErc20Transfer,
+		NumTransactionsErc721Transfer: data.NumTransactionsErc721Transfer,
+
+		NumFlashbotsTransactionsSuccess: data.NumFlashbotsTransactionsSuccess,
+		NumFlashbotsTransactionsFailed:  data.NumFlashbotsTransactionsFailed,
+
+		Errors: data.Errors,
+	}
+
+	for key, list := range data.TopAddresses {
+		export.TopAddresses[key] = make([]AddressStatsJson, len(list))
+		for i, v := range list {
+			export.TopAddresses[key][i] = NewAddressStatsJson(v)
+		}
+	}
+
+	for key, list := range data.TopTokens {
+		export.TopTokens[key] = make([]TokenStatsJson, len(list))
+		for i, v := range list {
+			export.TopTokens[key][i] = NewTokenStatsJson(v)
+		}
+	}
+
+	for key, list := range data.TopTransactions {
+		export.TopTransactions[key] = newTxStatsJsonList(list)
+	}
+
+	for i, v := range data.TopMiners {
+		export.TopMiners[i] = NewMinerStatsJson(v)
+	}
+
+	return export
+}
+
+// NewFullAnalysisJsonExport is NewAnalysisJsonExport with the stats of all addresses, tokens and miners (sorted by
+// address) and blocks, which are needed to merge analyses. The top transactions are taken from the top lists, so they
+// are also exported before BuildTopTransactions (e.g. for checkpoints).
+func NewFullAnalysisJsonExport(analysis *Analysis) AnalysisJsonExport {
+	export := NewAnalysisJsonExport(analysis)
+	export.Full = true
+
+	for name, list := range analysis.TxTopLists {
+		export.TopTransactions[name] = newTxStatsJsonList(list.Sorted())
+	}
+
+	export.Addresses = make([]AddressStatsJson, 0, len(analysis.Addresses))
+	for _, stats := range analysis.Addresses {
+		export.Addresses = append(export.Addresses, NewAddressStatsJson(*stats))
+	}
+	sort.Slice(export.Addresses, func(i, j int) bool {
+		return export.Addresses[i].AddressDetail.Address < export.Addresses[j].AddressDetail.Address
+	})
+
+	for _, sketch := range analysis.addressSketches {
+		export.AddressSketches = append(export.AddressSketches, NewAddressSketchJson(sketch))
+	}
+	sort.Slice(export.AddressSketches, func(i, j int) bool {
+		return export.AddressSketches[i].Key < export.AddressSketches[j].Key
+	})
+
+	export.AddressTokens = make([]AddressTokenStatsJson, 0, len(analysis.AddressTokens))
+	for _, stats := range analysis.AddressTokens {
+		export.AddressTokens = append(export.AddressTokens, NewAddressTokenStatsJson(*stats))
+	}
+	sort.Slice(export.AddressTokens, func(i, j int) bool {
+		return addressTokenKey(export.AddressTokens[i].Address, export.AddressTokens[i].Token) < addressTokenKey(export.AddressTokens[j].Address, export.AddressTokens[j].Token)
+	})
+
+	export.Miners = make([]MinerStatsJson, 0, len(analysis.Miners))
+	for _, stats := range analysis.Miners {
+		export.Miners = append(export.Miners, NewMinerStatsJson(*stats))
+	}
+	sort.Slice(export.Miners, func(i, j int) bool {
+		return export.Miners[i].Miner.Address < export.Miners[j].Miner.Address
+	})
+
+	export.Blocks = make([]BlockStatsJson, len(analysis.Blocks))
+	for i, stats := range analysis.Blocks {
+		export.Blocks[i] = NewBlockStatsJson(stats)
+	}
+
+	return export
+}
+
+// SaveJson writes the analysis as JSON export to a file. full also includes the stats of all addresses (see
+// NewFullAnalysisJsonExport).
+func (analysis *Analysis) SaveJson(filename string, full bool) error {
+	export := NewAnalysisJsonExport(analysis)
+	if full {
+		export = NewFullAnalysisJsonExport(analysis)
+	}
+
+	j, err := json.MarshalIndent(export, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, j, 0644)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// TxFees splits the fee of a transaction according to EIP-1559. Before London (baseFee is nil) nothing is burned and the
+// whole fee goes to the miner as tip.
+type TxFees struct {
+	EffectiveGasPrice *big.Int // price per gas the sender actually paid
+	EffectiveGasTip   *big.Int // part of the effective gas price that goes to the miner
+
+	GasFee         *big.Int // gasUsed * EffectiveGasPrice
+	Burned         *big.Int // gasUsed * baseFee
+	Tip            *big.Int // gasUsed * EffectiveGasTip
+	MaxFeeHeadroom *big.Int // gasUsed * (GasFeeCap - EffectiveGasPrice): allowed by the sender, but not paid
+}
+
+func NewTxFees(tx *types.Transaction, gasUsed *big.Int, baseFee *big.Int) TxFees {
+	effectiveGasPrice := tx.GasPrice() // legacy and access list tx, and any tx before London
+	effectiveGasTip := tx.GasPrice()
+	burnedPerGas := new(big.Int)
+
+	if baseFee != nil {
+		burnedPerGas = baseFee
+		effectiveGasTip = tx.EffectiveGasTipValue(baseFee) // min(GasTipCap, GasFeeCap - baseFee)
+
+		// GasFeeCap below baseFee is only possible in invalid blocks
+		if effectiveGasTip.Sign() < 0 {
+			effectiveGasTip = new(big.Int)
+		}
+		effectiveGasPrice = new(big.Int).Add(baseFee, effectiveGasTip)
+	}
+
+	headroomPerGas := new(big.Int).Sub(tx.GasFeeCap(), effectiveGasPrice)
+	if headroomPerGas.Sign() < 0 {
+		headroomPerGas = new(big.Int)
+	}
+
+	return TxFees{
+		EffectiveGasPrice: effectiveGasPrice,
+		EffectiveGasTip:   effectiveGasTip,
+
+		GasFee:         new(big.Int).Mul(gasUsed, effectiveGasPrice),
+		Burned:         new(big.Int).Mul(gasUsed, burnedPerGas),
+		Tip:            new(big.Int).Mul(gasUsed, effectiveGasTip),
+		MaxFeeHeadroom: new(big.Int).Mul(gasUsed, headroomPerGas),
+	}
+}
+
+// GetTxSender returns the sender of any transaction type. utils.GetTxSender only knows legacy transactions.
+func GetTxSender(tx *types.Transaction) (from common.Address, err error) {
+	from, err = types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		from, err = types.Sender(types.HomesteadSigner{}, tx)
+	}
+	return from, err
+}
+
+// WeiToGweiString formats a price per gas in gwei, with 2 decimals
+func WeiToGweiString(wei *big.Int) string {
+	if wei == nil {
+		return "0.00"
+	}
+	gwei := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.GWei))
+	return gwei.Text('f', 2)
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedExportVersion is returned when loading a JSON export with another schema version
+	ErrUnsupportedExportVersion = errors.New("unsupported JSON export version")
+
+	// ErrIncompleteExport is returned when loading a JSON export without the stats of all addresses
+	ErrIncompleteExport = errors.New("JSON export doesn't have the stats of all addresses (save it as full export)")
+)
+
+// bigIntParser parses decimal strings of a JSON export, and keeps the first error
+type bigIntParser struct {
+	err error
+}
+
+func (p *bigIntParser) parse(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		if p.err == nil {
+			p.err = fmt.Errorf("invalid number %q", s)
+		}
+		return new(big.Int)
+	}
+	return i
+}
+
+func (p *bigIntParser) txStats(j TxStatsJson) TxStats {
+	return TxStats{
+		Hash:              j.Hash,
+		FromAddr:          j.FromAddr,
+		ToAddr:            j.ToAddr,
+		GasUsed:           p.parse(j.GasUsed),
+		GasFee:            p.parse(j.GasFee),
+		GasFeeBurned:      p.parse(j.GasFeeBurned),
+		GasFeeTip:         p.parse(j.GasFeeTip),
+		EffectiveGasPrice: p.parse(j.EffectiveGasPrice),
+		Value:             p.parse(j.Value),
+		DataSize:          j.DataSize,
+		NumLogs:           j.NumLogs,
+		Success:           j.Success,
+		Tag:               j.Tag,
+
+		Erc20TransferToken: j.Erc20TransferToken,
+		Erc20TransferValue: p.parse(j.Erc20TransferValue),
+	}
+}
+
+// NewAnalysisFromJsonExport restores the analysis of a full JSON export (see NewFullAnalysisJsonExport), e.g. to merge
+// it with other analyses. The top lists are built with the sizes of cfg, and the top transactions of txRankers (see
+// NewAnalysis) are restored.
+func NewAnalysisFromJsonExport(cfg Config, txRankers []TxRanker, export AnalysisJsonExport, ads IAddressDetailService) (*Analysis, error) {
+	if export.Version != AnalysisJsonExportVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedExportVersion, export.Version)
+	}
+	if !export.Full {
+		return nil, ErrIncompleteExport
+	}
+
+	p := bigIntParser{}
+	analysis := NewAnalysis(cfg, txRankers, ads)
+	data := &analysis.Data
+
+	data.StartBlockNumber = export.StartBlockNumber
+	data.StartBlockTimestamp = export.StartBlockTimestamp
+	data.EndBlockNumber = export.EndBlockNumber
+	data.EndBlockTimestamp = export.EndBlockTimestamp
+
+	for txType, num := range export.TxTypes {
+		data.TxTypes[txType] = num
+	}
+	data.ValueTotalWei = p.parse(export.ValueTotalWei)
+
+	data.NumBlocks = export.NumBlocks
+	data.NumBlocksWithoutTx = export.NumBlocksWithoutTx
+	data.GasUsed = p.parse(export.GasUsed)
+	data.GasFeeTotal = p.parse(export.GasFeeTotal)
+	data.GasFeeFailedTx = p.parse(export.GasFeeFailedTx)
+	data.GasFeeBurned = p.parse(export.GasFeeBurned)
+	data.GasFeeTips = p.parse(export.GasFeeTips)
+	data.GasFeeMaxHeadroom = p.parse(export.GasFeeMaxHeadroom)
+
+	data.NumTransactions = export.NumTransactions
+	data.NumTransactionsFailed = export.NumTransactionsFailed
+	data.NumTransactionsWithZeroValue = export.NumTransactionsWithZeroValue
+	data.NumTransactionsWithData = export.NumTransactionsWithData
+	data.NumTransactionsErc20Transfer = export.NumTransactionsErc20Transfer
+	data.NumTransactionsErc721Transfer = export.NumTransactionsErc721Transfer
+	data.NumFlashbotsTransactionsSuccess = export.NumFlashbotsTransactionsSuccess
+	data.NumFlashbotsTransactionsFailed = export.NumFlashbotsTransactionsFailed
+	data.Errors = append(data.Errors, export.Errors...)
+
+	for _, j := range export.TaggedTransactions {
+		data.TaggedTransactions = append(data.TaggedTransactions, p.txStats(j))
+	}
+
+	// The top transactions of the merged analysis are always in the top lists of the parts, as long as NUM_TOP_TX is
+	// not larger than when the export was saved
+	for name, list := range export.TopTransactions {
+		if txTopList, found := analysis.TxTopLists[name]; found {
+			for _, j := range list {
+				txTopList.Add(p.txStats(j))
+			}
+		}
+	}
+
+	for _, j := range export.Addresses {
+		addr := strings.ToLower(j.AddressDetail.Address)
+		stats := NewAddressStats(addr)
+		stats.AddressDetail = j.AddressDetail
+		for key, val := range j.Stats {
+			stats.Stats[key] = p.parse(val)
+		}
+		if analysis.addressSketches != nil {
+			analysis.addToAddressSketches(addr, stats.Stats)
+		} else {
+			analysis.Addresses[addr] = stats
+		}
+	}
+
+	if len(export.AddressSketches) > 0 && analysis.addressSketches == nil {
+		analysis.enableAddressSketches(export.AddressSketchSize)
+	}
+	for _, j := range export.AddressSketches {
+		sketch := NewAddressSketch(j.Key, j.Capacity)
+		sketch.Total = p.parse(j.Total)
+		for _, c := range j.Counters {
+			sketch.push(&AddressCounter{Address: c.Address, Count: p.parse(c.Count), Error: p.parse(c.Error)})
+		}
+		if analysisSketch := analysis.addressSketches[j.Key]; analysisSketch != nil {
+			analysisSketch.Merge(sketch)
+		}
+	}
+
+	for _, j := range export.AddressTokens {
+		stats := NewAddressTokenStats(j.Address, j.Token)
+		stats.NumSent = j.NumSent
+		stats.NumReceived = j.NumReceived
+		stats.AmountSent = p.parse(j.AmountSent)
+		stats.AmountReceived = p.parse(j.AmountReceived)
+		analysis.AddressTokens[addressTokenKey(j.Address, j.Token)] = stats
+	}
+
+	for _, j := range export.Miners {
+		stats := NewMinerStats(j.Miner.Address)
+		stats.Miner = j.Miner
+		stats.NumBlocks = j.NumBlocks
+		stats.NumBlocksEmpty = j.NumBlocksEmpty
+		stats.NumTx = j.NumTx
+		stats.NumFlashbotsTx = j.NumFlashbotsTx
+		stats.GasUsed = j.GasUsed
+		stats.GasLimit = j.GasLimit
+		stats.TipsEarned = p.parse(j.TipsEarned)
+		analysis.Miners[strings.ToLower(j.Miner.Address)] = stats
+	}
+
+	for _, j := range export.Blocks {
+		stats := BlockStats{
+			Number:     j.Number,
+			Hash:       j.Hash,
+			ParentHash: j.ParentHash,
+			Time:       j.Time,
+			Miner:      j.Miner,
+			BaseFee:    p.parse(j.BaseFee),
+			GasUsed:    j.GasUsed,
+			GasLimit:   j.GasLimit,
+
+			NumTx:          j.NumTx,
+			NumTxByType:    make(map[uint8]int, len(j.NumTxByType)),
+			NumTxFailed:    j.NumTxFailed,
+			NumFlashbotsTx: j.NumFlashbotsTx,
+
+			GasFeeTotal:  p.parse(j.GasFeeTotal),
+			GasFeeBurned: p.parse(j.GasFeeBurned),
+			GasFeeTips:   p.parse(j.GasFeeTips),
+			MaxTxValue:   p.parse(j.MaxTxValue),
+		}
+		for txType, num := range j.NumTxByType {
+			stats.NumTxByType[txType] = num
+		}
+		analysis.Blocks = append(analysis.Blocks, stats)
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	return analysis, nil
+}
+
+// LoadAnalysisJson loads a full JSON export saved with SaveJson (see NewAnalysisFromJsonExport)
+func LoadAnalysisJson(cfg Config, txRankers []TxRanker, filename string, ads IAddressDetailService) (*Analysis, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var export AnalysisJsonExport
+	if err = json.Unmarshal(b, &export); err != nil {
+		return nil, err
+	}
+	return NewAnalysisFromJsonExport(cfg, txRankers, export, ads)
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrNonContiguousRange is returned by Merge if an analysis doesn't start at the block after the end of the other
+var ErrNonContiguousRange = errors.New("block ranges are not contiguous")
+
+// Merge adds the analysis other, which must start at the block after the end of analysis, into analysis. This
+// combines e.g. hourly analyses into a daily one, without processing the blocks again. Counters, address/token/miner
+// stats and top lists are summed up, blocks and tagged transactions are appended in order.
+//
+// Merge must be called before the Build* functions, as TopAddresses, TopTokens, TopMiners and TopTransactions are built
+// from the merged stats. other is not modified, so it can be merged into several analyses (e.g. rolling windows).
+func (analysis *Analysis) Merge(other *Analysis) error {
+	if analysis.Data.NumBlocks > 0 && other.Data.NumBlocks > 0 && other.Data.StartBlockNumber != analysis.Data.EndBlockNumber+1 {
+		return fmt.Errorf("%w: %d-%d and %d-%d", ErrNonContiguousRange, analysis.Data.StartBlockNumber, analysis.Data.EndBlockNumber, other.Data.StartBlockNumber, other.Data.EndBlockNumber)
+	}
+
+	analysis.MergeUnchecked(other)
+	return nil
+}
+
+// MergeUnchecked is Merge without the check for contiguous block ranges, for the partial analyses of one block range,
+// which have gaps where blocks couldn't be fetched (see ethstats.Analyzer.AnalyzeBlocks)
+func (analysis *Analysis) MergeUnchecked(other *Analysis) {
+	analysis.Data.merge(&other.Data)
+
+	// If one of the analyses approximates the top addresses, the merged analysis does too
+	if other.addressSketches != nil && analysis.addressSketches == nil {
+		analysis.enableAddressSketches(other.AddressSketchSize())
+	}
+	for key, otherSketch := range other.addressSketches {
+		analysis.addressSketches[key].Merge(otherSketch)
+	}
+
+	for addr, otherStats := range other.Addresses {
+		if analysis.addressSketches != nil {
+			analysis.addToAddressSketches(addr, otherStats.Stats)
+			continue
+		}
+
+		stats, found := analysis.Addresses[addr]
+		if !found {
+			stats = NewAddressStats(addr)
+			analysis.Addresses[addr] = stats
+		}
+		if stats.AddressDetail.IsInitial() {
+			stats.AddressDetail = otherStats.AddressDetail
+		}
+		for key, val := range otherStats.Stats {
+			stats.Add(key, val)
+		}
+	}
+
+	for key, otherStats := range other.AddressTokens {
+		stats, found := analysis.AddressTokens[key]
+		if !found {
+			stats = NewAddressTokenStats(otherStats.Address, otherStats.Token)
+			analysis.AddressTokens[key] = stats
+		}
+		stats.NumSent += otherStats.NumSent
+		stats.NumReceived += otherStats.NumReceived
+		stats.AmountSent = new(big.Int).Add(stats.AmountSent, otherStats.AmountSent)
+		stats.AmountReceived = new(big.Int).Add(stats.AmountReceived, otherStats.AmountReceived)
+	}
+
+	for miner, otherStats := range other.Miners {
+		stats, found := analysis.Miners[miner]
+		if !found {
+			stats = NewMinerStats(miner)
+			analysis.Miners[miner] = stats
+		}
+		if stats.Miner.IsInitial() {
+			stats.Miner = otherStats.Miner
+		}
+		stats.merge(otherStats)
+	}
+
+	analysis.Blocks = append(analysis.Blocks, other.Blocks...)
+
+	for name, otherList := range other.TxTopLists {
+		list, found := analysis.TxTopLists[name]
+		if !found {
+			list = NewTxTopList(otherList.Ranker, otherList.Size)
+			analysis.TxTopLists[name] = list
+		}
+		list.Merge(otherList)
+	}
+}
+
+// merge adds the counters of other, and extends the block range to the end of other
+func (data *AnalysisData) merge(other *AnalysisData) {
+	if other.NumBlocks > 0 {
+		if data.NumBlocks == 0 {
+			data.StartBlockNumber = other.StartBlockNumber
+			data.StartBlockTimestamp = other.StartBlockTimestamp
+		}
+		data.EndBlockNumber = other.EndBlockNumber
+		data.EndBlockTimestamp = other.EndBlockTimestamp
+	}
+
+	data.TaggedTransactions = append(data.TaggedTransactions, other.TaggedTransactions...)
+	data.Errors = append(data.Errors, other.Errors...)
+
+	for txType, num := range other.TxTypes {
+		data.TxTypes[txType] += num
+	}
+	data.ValueTotalWei = new(big.Int).Add(data.ValueTotalWei, other.ValueTotalWei)
+
+	data.NumBlocks += other.NumBlocks
+	data.NumBlocksWithoutTx += other.NumBlocksWithoutTx
+	data.GasUsed = new(big.Int).Add(data.GasUsed, other.GasUsed)
+	data.GasFeeTotal = new(big.Int).Add(data.GasFeeTotal, other.GasFeeTotal)
+	data.GasFeeFailedTx = new(big.Int).Add(data.GasFeeFailedTx, other.GasFeeFailedTx)
+	data.GasFeeBurned = new(big.Int).Add(data.GasFeeBurned, other.GasFeeBurned)
+	data.GasFeeTips = new(big.Int).Add(data.GasFeeTips, other.GasFeeTips)
+	data.GasFeeMaxHeadroom = new(big.Int).Add(data.GasFeeMaxHeadroom, other.GasFeeMaxHeadroom)
+
+	data.NumTransactions += other.NumTransactions
+	data.NumTransactionsFailed += other.NumTransactionsFailed
+	data.NumTransactionsWithZeroValue += other.NumTransactionsWithZeroValue
+	data.NumTransactionsWithData += other.NumTransactionsWithData
+	data.NumTransactionsErc20Transfer += other.NumTransactionsErc20Transfer
+	data.NumTransactionsErc721Transfer += other.NumTransactionsErc721Transfer
+	data.NumFlashbotsTransactionsSuccess += other.NumFlashbotsTransactionsSuccess
+	data.NumFlashbotsTransactionsFailed += other.NumFlashbotsTransactionsFailed
+}
+
+func (stats *MinerStats) merge(other *MinerStats) {
+	stats.NumBlocks += other.NumBlocks
+	stats.NumBlocksEmpty += other.NumBlocksEmpty
+	stats.NumTx += other.NumTx
+	stats.NumFlashbotsTx += other.NumFlashbotsTx
+	stats.GasUsed += other.GasUsed
+	stats.GasLimit += other.GasLimit
+	stats.TipsEarned = new(big.Int).Add(stats.TipsEarned, other.TipsEarned)
+}
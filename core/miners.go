@@ -0,0 +1,91 @@
+package core
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+// MinerStats accumulates the blocks of one miner (block coinbase / fee recipient)
+type MinerStats struct {
+	Miner          addressdetail.AddressDetail
+	NumBlocks      int
+	NumBlocksEmpty int
+	NumTx          int
+	NumFlashbotsTx int
+	GasUsed        uint64
+	GasLimit       uint64
+	TipsEarned     *big.Int // priority fees, or the whole gas fees before London
+}
+
+func NewMinerStats(miner string) *MinerStats {
+	return &MinerStats{
+		Miner:      addressdetail.NewAddressDetail(miner),
+		TipsEarned: new(big.Int),
+	}
+}
+
+func (stats *MinerStats) AddBlock(block BlockStats) {
+	stats.NumBlocks += 1
+	if block.NumTx == 0 {
+		stats.NumBlocksEmpty += 1
+	}
+	stats.NumTx += block.NumTx
+	stats.NumFlashbotsTx += block.NumFlashbotsTx
+	stats.GasUsed += block.GasUsed
+	stats.GasLimit += block.GasLimit
+	stats.TipsEarned = new(big.Int).Add(stats.TipsEarned, block.GasFeeTips)
+}
+
+// GasUtilization returns gas used / gas limit over all blocks of the miner, in percent
+func (stats *MinerStats) GasUtilization() float64 {
+	if stats.GasLimit == 0 {
+		return 0
+	}
+	return float64(stats.GasUsed) / float64(stats.GasLimit) * 100
+}
+
+// FlashbotsShare returns the share of flashbots (zero gas price / zero tip) transactions, in percent
+func (stats *MinerStats) FlashbotsShare() float64 {
+	if stats.NumTx == 0 {
+		return 0
+	}
+	return float64(stats.NumFlashbotsTx) / float64(stats.NumTx) * 100
+}
+
+func (analysis *Analysis) GetOrCreateMinerStats(miner string) *MinerStats {
+	stats, found := analysis.Miners[miner]
+	if !found {
+		stats = NewMinerStats(miner)
+		analysis.Miners[miner] = stats
+	}
+	return stats
+}
+
+// BuildTopMiners sorts the miners by number of blocks (and tips earned for equal numbers) into TopMiners, and ensures
+// that their details (pool names) are loaded
+func (analysis *Analysis) BuildTopMiners() {
+	numEntries := analysis.numTopAddresses
+
+	miners := make([]*MinerStats, 0, len(analysis.Miners))
+	for _, v := range analysis.Miners {
+		miners = append(miners, v)
+	}
+
+	sort.Slice(miners, func(i, j int) bool {
+		if miners[i].NumBlocks != miners[j].NumBlocks {
+			return miners[i].NumBlocks > miners[j].NumBlocks
+		}
+		if c := miners[i].TipsEarned.Cmp(miners[j].TipsEarned); c != 0 {
+			return c == 1
+		}
+		return miners[i].Miner.Address < miners[j].Miner.Address
+	})
+
+	analysis.Data.TopMiners = make([]MinerStats, 0, numEntries)
+	for i := 0; i < len(miners) && i < numEntries; i++ {
+		analysis.EnsureAddressDetailIsLoaded(&miners[i].Miner)
+		analysis.Data.TopMiners = append(analysis.Data.TopMiners, *miners[i])
+	}
+}
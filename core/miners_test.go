@@ -0,0 +1,57 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBuildTopMiners(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.NumTopAddresses = 4
+	analysis := NewAnalysis(cfg, nil, nopAddressDetailService{})
+
+	newBlock := func(miner string, numTx int, numFlashbotsTx int, gasUsed uint64, tips int64) BlockStats {
+		return BlockStats{Miner: miner, NumTx: numTx, NumFlashbotsTx: numFlashbotsTx, GasUsed: gasUsed, GasLimit: 1000, GasFeeTips: big.NewInt(tips)}
+	}
+	for _, block := range []BlockStats{
+		newBlock("0xb", 10, 2, 800, 60),
+		newBlock("0xa", 5, 0, 400, 30),
+		newBlock("0xb", 0, 0, 0, 0), // empty block
+		newBlock("0xc", 20, 5, 1000, 100),
+		newBlock("0xa", 15, 3, 600, 20),
+		newBlock("0xd", 1, 0, 100, 1),
+		newBlock("0xe", 2, 1, 200, 1),
+	} {
+		analysis.GetOrCreateMinerStats(block.Miner).AddBlock(block)
+	}
+	analysis.BuildTopMiners()
+
+	// By number of blocks, then tips earned, then address. 0xe is not in the top 4.
+	expected := []struct {
+		miner          string
+		numBlocks      int
+		numBlocksEmpty int
+		numTx          int
+		numFlashbotsTx int
+		tips           int64
+		gasUtilization float64
+		flashbotsShare float64
+	}{
+		{"0xb", 2, 1, 10, 2, 60, 40, 20},
+		{"0xa", 2, 0, 20, 3, 50, 50, 15},
+		{"0xc", 1, 0, 20, 5, 100, 100, 25},
+		{"0xd", 1, 0, 1, 0, 1, 10, 0},
+	}
+	if len(analysis.Data.TopMiners) != len(expected) {
+		t.Fatalf("got %d top miners, want %d", len(analysis.Data.TopMiners), len(expected))
+	}
+	for i, e := range expected {
+		stats := analysis.Data.TopMiners[i]
+		if stats.Miner.Address != e.miner || stats.NumBlocks != e.numBlocks || stats.NumBlocksEmpty != e.numBlocksEmpty || stats.NumTx != e.numTx || stats.NumFlashbotsTx != e.numFlashbotsTx || stats.TipsEarned.Int64() != e.tips {
+			t.Errorf("top miner %d: got %+v, want %+v", i, stats, e)
+		}
+		if stats.GasUtilization() != e.gasUtilization || stats.FlashbotsShare() != e.flashbotsShare {
+			t.Errorf("top miner %d: got %.1f%% gas used and %.1f%% flashbots tx, want %.1f%% and %.1f%%", i, stats.GasUtilization(), stats.FlashbotsShare(), e.gasUtilization, e.flashbotsShare)
+		}
+	}
+}
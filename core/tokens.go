@@ -0,0 +1,197 @@
+package core
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/consts"
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+// TokenTransfer is a decoded Transfer event of an ERC20 or ERC721 token
+type TokenTransfer struct {
+	Token    common.Address
+	From     common.Address
+	To       common.Address
+	Value    *big.Int // amount (ERC20) or tokenId (ERC721)
+	IsErc721 bool
+}
+
+// ParseTransferLog decodes a Transfer event. ERC20 and ERC721 use the same event signature and are distinguished by the
+// number of indexed topics: ERC20 has the value in data, ERC721 the tokenId as indexed 4th topic. Returns false for other
+// logs and non-standard Transfer events.
+func ParseTransferLog(log *types.Log) (transfer TokenTransfer, ok bool) {
+	if len(log.Topics) < 3 || log.Topics[0] != consts.TopicTransfer {
+		return transfer, false
+	}
+
+	transfer = TokenTransfer{
+		Token: log.Address,
+		From:  common.BytesToAddress(log.Topics[1].Bytes()),
+		To:    common.BytesToAddress(log.Topics[2].Bytes()),
+	}
+
+	if len(log.Topics) == 3 && len(log.Data) == 32 {
+		transfer.Value = new(big.Int).SetBytes(log.Data)
+		return transfer, true
+	} else if len(log.Topics) == 4 && len(log.Data) == 0 {
+		transfer.Value = log.Topics[3].Big()
+		transfer.IsErc721 = true
+		return transfer, true
+	}
+	return transfer, false
+}
+
+// tokenDetailCache loads the details of each token once, for the ERC20 transfers of the top transactions (see
+// TxStats.ResolveErc20Transfer). It is safe for concurrent use, as the top transactions of merged analyses keep the cache
+// of the analysis they were added to.
+type tokenDetailCache struct {
+	ads     IAddressDetailService
+	lock    sync.Mutex
+	details map[string]addressdetail.AddressDetail
+}
+
+func newTokenDetailCache(ads IAddressDetailService) *tokenDetailCache {
+	return &tokenDetailCache{ads: ads, details: make(map[string]addressdetail.AddressDetail)}
+}
+
+func (cache *tokenDetailCache) get(token string) addressdetail.AddressDetail {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	detail, found := cache.details[token]
+	if !found {
+		detail = addressdetail.NewAddressDetail(token)
+		cache.ads.EnsureIsLoaded(&detail)
+		cache.details[token] = detail
+	}
+	return detail
+}
+
+// AddressTokenStats accumulates the ERC20 transfers of one token by one address. Unlike the Erc20Tokens* address stats,
+// the amounts are never summed across tokens with different decimals.
+type AddressTokenStats struct {
+	Address string
+	Token   string
+
+	NumSent        int
+	NumReceived    int
+	AmountSent     *big.Int
+	AmountReceived *big.Int
+}
+
+func NewAddressTokenStats(address string, token string) *AddressTokenStats {
+	return &AddressTokenStats{
+		Address:        address,
+		Token:          token,
+		AmountSent:     new(big.Int),
+		AmountReceived: new(big.Int),
+	}
+}
+
+func (stats *AddressTokenStats) AddSent(amount *big.Int) {
+	stats.NumSent += 1
+	stats.AmountSent = new(big.Int).Add(stats.AmountSent, amount)
+}
+
+func (stats *AddressTokenStats) AddReceived(amount *big.Int) {
+	stats.NumReceived += 1
+	stats.AmountReceived = new(big.Int).Add(stats.AmountReceived, amount)
+}
+
+// TokenStats is the summary of all transfers of one ERC20 token
+type TokenStats struct {
+	Token              addressdetail.AddressDetail
+	NumTransfers       int
+	NumUniqueSenders   int
+	NumUniqueReceivers int
+	Volume             *big.Int // sum of all transferred amounts, in the smallest unit of the token
+}
+
+func NewTokenStats(token string) *TokenStats {
+	return &TokenStats{
+		Token:  addressdetail.NewAddressDetail(token),
+		Volume: new(big.Int),
+	}
+}
+
+// Get returns the value for one of consts.TokenStatsKeys
+func (stats *TokenStats) Get(key string) int {
+	switch key {
+	case consts.TokenNumTransfers:
+		return stats.NumTransfers
+	case consts.TokenNumUniqueSenders:
+		return stats.NumUniqueSenders
+	case consts.TokenNumUniqueReceivers:
+		return stats.NumUniqueReceivers
+	}
+	return 0
+}
+
+func addressTokenKey(address string, token string) string {
+	return address + ":" + token
+}
+
+func (analysis *Analysis) GetOrCreateAddressTokenStats(address *common.Address, token *common.Address) *AddressTokenStats {
+	addr := strings.ToLower(address.String())
+	tokenAddr := strings.ToLower(token.String())
+	key := addressTokenKey(addr, tokenAddr)
+
+	stats, found := analysis.AddressTokens[key]
+	if !found {
+		stats = NewAddressTokenStats(addr, tokenAddr)
+		analysis.AddressTokens[key] = stats
+	}
+	return stats
+}
+
+// BuildTopTokens summarizes the per-address token stats by token, and builds the TopTokens lists for each of
+// consts.TokenStatsKeys. Ensures that details for all top tokens are loaded.
+func (analysis *Analysis) BuildTopTokens() {
+	numEntries := analysis.numTopAddresses
+
+	tokens := make(map[string]*TokenStats)
+	for _, addrTokenStats := range analysis.AddressTokens {
+		tokenStats, found := tokens[addrTokenStats.Token]
+		if !found {
+			tokenStats = NewTokenStats(addrTokenStats.Token)
+			tokens[addrTokenStats.Token] = tokenStats
+		}
+
+		// Every transfer has exactly one sender entry
+		tokenStats.NumTransfers += addrTokenStats.NumSent
+		tokenStats.Volume = new(big.Int).Add(tokenStats.Volume, addrTokenStats.AmountSent)
+		if addrTokenStats.NumSent > 0 {
+			tokenStats.NumUniqueSenders += 1
+		}
+		if addrTokenStats.NumReceived > 0 {
+			tokenStats.NumUniqueReceivers += 1
+		}
+	}
+
+	tokenList := make([]*TokenStats, 0, len(tokens))
+	for _, v := range tokens {
+		tokenList = append(tokenList, v)
+	}
+
+	for _, key := range consts.TokenStatsKeys {
+		// Sort by key, and by token address for equal values to get a deterministic order
+		sort.Slice(tokenList, func(i, j int) bool {
+			a, b := tokenList[i].Get(key), tokenList[j].Get(key)
+			if a != b {
+				return a > b
+			}
+			return tokenList[i].Token.Address < tokenList[j].Token.Address
+		})
+
+		ret := make([]TokenStats, 0, numEntries)
+		for i := 0; i < len(tokenList) && i < numEntries; i++ {
+			analysis.EnsureAddressDetailIsLoaded(&tokenList[i].Token)
+			ret = append(ret, *tokenList[i])
+		}
+		analysis.Data.TopTokens[key] = ret
+	}
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"container/heap"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// AddressTopList keeps the top-n addresses for one stats key in a single pass. It is a min-heap, so the lowest ranked
+// address can be replaced in O(log n). It only selects the top-n of the stats of all addresses, which are kept in
+// Analysis.Addresses; AddressSketch approximates the top addresses in bounded memory.
+type AddressTopList struct {
+	Key   string
+	Size  int
+	items []*AddressStats
+}
+
+func NewAddressTopList(key string, size int) *AddressTopList {
+	return &AddressTopList{
+		Key:   key,
+		Size:  size,
+		items: make([]*AddressStats, 0, size),
+	}
+}
+
+// compare returns > 0 if a ranks higher than b. Equal values are ranked by address, to get a deterministic order.
+func (list *AddressTopList) compare(a, b *AddressStats) int {
+	if c := a.Stats[list.Key].Cmp(b.Stats[list.Key]); c != 0 {
+		return c
+	}
+	return strings.Compare(b.AddressDetail.Address, a.AddressDetail.Address)
+}
+
+// Add adds the address if its value for the key is > 0 and it is within the top-n
+func (list *AddressTopList) Add(stats *AddressStats) {
+	if v := stats.Stats[list.Key]; v == nil || v.Sign() <= 0 || list.Size <= 0 {
+		return
+	}
+
+	if len(list.items) < list.Size {
+		heap.Push(list, stats)
+	} else if list.compare(stats, list.items[0]) > 0 {
+		list.items[0] = stats
+		heap.Fix(list, 0)
+	}
+}
+
+// Sorted returns copies of the address stats, highest value first
+func (list *AddressTopList) Sorted() []AddressStats {
+	items := make([]*AddressStats, len(list.items))
+	copy(items, list.items)
+	sort.Slice(items, func(i, j int) bool {
+		return list.compare(items[i], items[j]) > 0
+	})
+
+	ret := make([]AddressStats, len(items))
+	for i, v := range items {
+		ret[i] = *v
+	}
+	return ret
+}
+
+// heap.Interface, only to be used through Add
+func (list *AddressTopList) Len() int { return len(list.items) }
+func (list *AddressTopList) Less(i, j int) bool {
+	return list.compare(list.items[i], list.items[j]) < 0
+}
+func (list *AddressTopList) Swap(i, j int) {
+	list.items[i], list.items[j] = list.items[j], list.items[i]
+}
+func (list *AddressTopList) Push(x interface{}) { list.items = append(list.items, x.(*AddressStats)) }
+func (list *AddressTopList) Pop() interface{} {
+	item := list.items[len(list.items)-1]
+	list.items = list.items[:len(list.items)-1]
+	return item
+}
+
+// AddressCounter is the approximate value of an address in an AddressSketch. Count is at least the true value, and at
+// most Error larger.
+type AddressCounter struct {
+	Address string
+	Count   *big.Int
+	Error   *big.Int
+
+	index int // in the heap of the sketch
+}
+
+// AddressSketch approximates the top addresses for one stats key with the Space-Saving algorithm: it has at most
+// Capacity counters, and a new address replaces the counter with the smallest count, which it inherits as error. Memory
+// is bounded by the capacity instead of the number of addresses. Every count overestimates the true value by at most
+// Total / Capacity, and every address with a larger value has a counter.
+type AddressSketch struct {
+	Key      string
+	Capacity int
+	Total    *big.Int // sum of all added values
+
+	counters map[string]*AddressCounter
+	items    []*AddressCounter // min-heap by count
+}
+
+func NewAddressSketch(key string, capacity int) *AddressSketch {
+	return &AddressSketch{
+		Key:      key,
+		Capacity: capacity,
+		Total:    new(big.Int),
+		counters: make(map[string]*AddressCounter, capacity),
+		items:    make([]*AddressCounter, 0, capacity),
+	}
+}
+
+// Add adds value (if > 0) to the count of the address
+func (sketch *AddressSketch) Add(address string, value *big.Int) {
+	if value == nil || value.Sign() <= 0 || sketch.Capacity <= 0 {
+		return
+	}
+	sketch.Total = new(big.Int).Add(sketch.Total, value)
+
+	if counter, found := sketch.counters[address]; found {
+		counter.Count = new(big.Int).Add(counter.Count, value)
+		heap.Fix(sketch, counter.index)
+	} else if len(sketch.items) < sketch.Capacity {
+		counter = &AddressCounter{Address: address, Count: new(big.Int).Set(value), Error: new(big.Int)}
+		sketch.counters[address] = counter
+		heap.Push(sketch, counter)
+	} else {
+		counter = sketch.items[0]
+		delete(sketch.counters, counter.Address)
+		counter.Address = address
+		counter.Error = counter.Count
+		counter.Count = new(big.Int).Add(counter.Count, value)
+		sketch.counters[address] = counter
+		heap.Fix(sketch, 0)
+	}
+}
+
+// push adds a counter of an address that is not in the sketch yet, e.g. of a JSON export
+func (sketch *AddressSketch) push(counter *AddressCounter) {
+	sketch.counters[counter.Address] = counter
+	heap.Push(sketch, counter)
+}
+
+// Len returns the number of counters, at most Capacity
+func (sketch *AddressSketch) Len() int { return len(sketch.items) }
+
+// minCount returns the largest count an address without counter can have: the smallest count if the sketch is full,
+// else 0
+func (sketch *AddressSketch) minCount() *big.Int {
+	if len(sketch.items) < sketch.Capacity || len(sketch.items) == 0 {
+		return new(big.Int)
+	}
+	return sketch.items[0].Count
+}
+
+// Merge adds the counters of other, which must have the same key. An address without counter in one of the sketches is
+// counted with the smallest count of that sketch (see minCount), so that the counts stay upper bounds of the true
+// values. Of the merged counters, the Capacity largest are kept.
+func (sketch *AddressSketch) Merge(other *AddressSketch) {
+	minCount, otherMinCount := sketch.minCount(), other.minCount()
+	merged := make([]*AddressCounter, 0, len(sketch.items)+len(other.items))
+	for _, counter := range sketch.items {
+		merged = append(merged, counter)
+		if otherCounter, found := other.counters[counter.Address]; found {
+			counter.Count = new(big.Int).Add(counter.Count, otherCounter.Count)
+			counter.Error = new(big.Int).Add(counter.Error, otherCounter.Error)
+		} else {
+			counter.Count = new(big.Int).Add(counter.Count, otherMinCount)
+			counter.Error = new(big.Int).Add(counter.Error, otherMinCount)
+		}
+	}
+	for _, otherCounter := range other.items {
+		if _, found := sketch.counters[otherCounter.Address]; !found {
+			merged = append(merged, &AddressCounter{
+				Address: otherCounter.Address,
+				Count:   new(big.Int).Add(otherCounter.Count, minCount),
+				Error:   new(big.Int).Add(otherCounter.Error, minCount),
+			})
+		}
+	}
+
+	sortAddressCounters(merged)
+	if len(merged) > sketch.Capacity {
+		merged = merged[:sketch.Capacity]
+	}
+	sketch.Total = new(big.Int).Add(sketch.Total, other.Total)
+	sketch.counters = make(map[string]*AddressCounter, sketch.Capacity)
+	sketch.items = merged
+	for i, counter := range merged {
+		counter.index = i
+		sketch.counters[counter.Address] = counter
+	}
+	heap.Init(sketch)
+}
+
+// Sorted returns copies of the counters, highest count first. Equal counts are ordered by address.
+func (sketch *AddressSketch) Sorted() []AddressCounter {
+	items := make([]*AddressCounter, len(sketch.items))
+	copy(items, sketch.items)
+	sortAddressCounters(items)
+
+	ret := make([]AddressCounter, len(items))
+	for i, v := range items {
+		ret[i] = *v
+	}
+	return ret
+}
+
+func sortAddressCounters(counters []*AddressCounter) {
+	sort.Slice(counters, func(i, j int) bool {
+		if c := counters[i].Count.Cmp(counters[j].Count); c != 0 {
+			return c > 0
+		}
+		return counters[i].Address < counters[j].Address
+	})
+}
+
+// heap.Interface, only to be used through Add and Merge
+func (sketch *AddressSketch) Less(i, j int) bool {
+	return sketch.items[i].Count.Cmp(sketch.items[j].Count) < 0
+}
+func (sketch *AddressSketch) Swap(i, j int) {
+	sketch.items[i], sketch.items[j] = sketch.items[j], sketch.items[i]
+	sketch.items[i].index = i
+	sketch.items[j].index = j
+}
+func (sketch *AddressSketch) Push(x interface{}) {
+	counter := x.(*AddressCounter)
+	counter.index = len(sketch.items)
+	sketch.items = append(sketch.items, counter)
+}
+func (sketch *AddressSketch) Pop() interface{} {
+	item := sketch.items[len(sketch.items)-1]
+	sketch.items = sketch.items[:len(sketch.items)-1]
+	return item
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metachris/ethereum-go-experiments/consts"
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+type nopAddressDetailService struct{}
+
+func (nopAddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {}
+
+// newRandomAnalysis returns an analysis with numAddresses addresses with random stats for all keys
+func newRandomAnalysis(numAddresses int) *Analysis {
+	rnd := rand.New(rand.NewSource(1))
+	analysis := NewAnalysis(DefaultConfig(), nil, nopAddressDetailService{})
+	for i := 0; i < numAddresses; i++ {
+		address := common.BigToAddress(big.NewInt(int64(i + 1)))
+		stats := analysis.GetOrCreateAddressStats(&address)
+		for _, key := range consts.AddressStatsKeys {
+			if rnd.Intn(4) > 0 { // some addresses have no value for a key
+				stats.Add(key, big.NewInt(rnd.Int63n(1_000_000)))
+			}
+		}
+	}
+	return analysis
+}
+
+// buildTopAddressesBySorting is the previous implementation of BuildTopAddresses: copy all addresses into a slice and
+// sort it once per key
+func buildTopAddressesBySorting(analysis *Analysis, numItems int) map[string][]AddressStats {
+	ret := make(map[string][]AddressStats)
+
+	addressArray := make([]AddressStats, 0, len(analysis.Addresses))
+	for _, k := range analysis.Addresses {
+		addressArray = append(addressArray, *k)
+	}
+
+	for _, key := range consts.AddressStatsKeys {
+		sort.SliceStable(addressArray, func(i, j int) bool {
+			return addressArray[i].Get(key).Cmp(addressArray[j].Get(key)) == 1
+		})
+
+		ret[key] = make([]AddressStats, 0, numItems)
+		for i := 0; i < len(addressArray) && i < numItems; i++ {
+			if addressArray[i].Get(key).Sign() == 1 {
+				ret[key] = append(ret[key], addressArray[i])
+			}
+		}
+	}
+	return ret
+}
+
+func TestBuildTopAddressesMatchesSorting(t *testing.T) {
+	analysis := newRandomAnalysis(2_000)
+	analysis.BuildTopAddresses()
+	expected := buildTopAddressesBySorting(analysis, DefaultConfig().NumTopAddresses)
+
+	for _, key := range consts.AddressStatsKeys {
+		actual := analysis.Data.TopAddresses[key]
+		if len(actual) != len(expected[key]) {
+			t.Fatalf("%s: got %d entries, expected %d", key, len(actual), len(expected[key]))
+		}
+
+		// Addresses with equal values can be in a different order, but the values must match
+		for i := range actual {
+			if actual[i].Get(key).Cmp(expected[key][i].Get(key)) != 0 {
+				t.Errorf("%s[%d]: got %v, expected %v", key, i, actual[i].Get(key), expected[key][i].Get(key))
+			}
+		}
+	}
+}
+
+func TestAddressTopList(t *testing.T) {
+	list := NewAddressTopList(consts.NumTxSent, 3)
+	for i, v := range []int64{5, 0, 9, 1, 7, 9, 3} {
+		stats := NewAddressStats(fmt.Sprintf("0x%02d", i))
+		stats.Add(consts.NumTxSent, big.NewInt(v))
+		list.Add(stats)
+	}
+
+	// ties are ordered by address
+	expected := []string{"0x02:9", "0x05:9", "0x04:7"}
+	sorted := list.Sorted()
+	if len(sorted) != len(expected) {
+		t.Fatalf("got %d entries, expected %d", len(sorted), len(expected))
+	}
+	for i, stats := range sorted {
+		if s := fmt.Sprintf("%s:%v", stats.AddressDetail.Address, stats.Get(consts.NumTxSent)); s != expected[i] {
+			t.Errorf("[%d]: got %s, expected %s", i, s, expected[i])
+		}
+	}
+}
+
+// newSkewedStream returns numValues values for numAddresses addresses, with a few heavy hitters, and the true sum per
+// address
+func newSkewedStream(numAddresses int, numValues int) (addresses []string, values []*big.Int, sums map[string]*big.Int) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.2, 1, uint64(numAddresses-1))
+	sums = make(map[string]*big.Int)
+	for i := 0; i < numValues; i++ {
+		address := fmt.Sprintf("0x%06d", zipf.Uint64())
+		value := big.NewInt(rnd.Int63n(100) + 1)
+		addresses = append(addresses, address)
+		values = append(values, value)
+		if sums[address] == nil {
+			sums[address] = new(big.Int)
+		}
+		sums[address].Add(sums[address], value)
+	}
+	return addresses, values, sums
+}
+
+// checkAddressSketch checks the Space-Saving guarantees against the true sums: every count is an upper bound with at most
+// Total / Capacity error, and every address with a larger sum has a counter
+func checkAddressSketch(t *testing.T, sketch *AddressSketch, sums map[string]*big.Int) {
+	t.Helper()
+	maxError := new(big.Int).Div(sketch.Total, big.NewInt(int64(sketch.Capacity)))
+	if sketch.Len() > sketch.Capacity {
+		t.Errorf("%d counters, capacity is %d", sketch.Len(), sketch.Capacity)
+	}
+	counted := make(map[string]bool)
+	for _, counter := range sketch.Sorted() {
+		counted[counter.Address] = true
+		sum := sums[counter.Address]
+		if sum == nil {
+			sum = new(big.Int)
+		}
+		overestimate := new(big.Int).Sub(counter.Count, sum)
+		if overestimate.Sign() < 0 || overestimate.Cmp(counter.Error) > 0 || counter.Error.Cmp(maxError) > 0 {
+			t.Errorf("%s: count %s, error %s for true value %s, max. error %s", counter.Address, counter.Count, counter.Error, sum, maxError)
+		}
+	}
+	for address, sum := range sums {
+		if sum.Cmp(maxError) > 0 && !counted[address] {
+			t.Errorf("%s: value %s > %s has no counter", address, sum, maxError)
+		}
+	}
+}
+
+func TestAddressSketch(t *testing.T) {
+	addresses, values, sums := newSkewedStream(50_000, 200_000)
+	sketch := NewAddressSketch(consts.NumTxSent, 500)
+	for i := range addresses {
+		sketch.Add(addresses[i], values[i])
+	}
+	checkAddressSketch(t, sketch, sums)
+
+	// The heavy hitters are exact enough for the top 10
+	exact := NewAddressTopList(consts.NumTxSent, 10)
+	for address, sum := range sums {
+		stats := NewAddressStats(address)
+		stats.Stats[consts.NumTxSent] = sum
+		exact.Add(stats)
+	}
+	for i, stats := range exact.Sorted() {
+		if counter := sketch.Sorted()[i]; counter.Address != stats.AddressDetail.Address {
+			t.Errorf("[%d]: got %s with %s, want %s with %s", i, counter.Address, counter.Count, stats.AddressDetail.Address, stats.Get(consts.NumTxSent))
+		}
+	}
+
+	// Merged sketches of the parts of a stream have the same guarantees
+	merged := NewAddressSketch(consts.NumTxSent, 500)
+	for part := 0; part < 4; part++ {
+		partSketch := NewAddressSketch(consts.NumTxSent, 500)
+		for i := part; i < len(addresses); i += 4 {
+			partSketch.Add(addresses[i], values[i])
+		}
+		merged.Merge(partSketch)
+	}
+	if merged.Total.Cmp(sketch.Total) != 0 {
+		t.Errorf("merged total %s, want %s", merged.Total, sketch.Total)
+	}
+	checkAddressSketch(t, merged, sums)
+	// An address that was replaced in one of the sketches is counted with the smallest count of that sketch
+	a, b := NewAddressSketch(consts.NumTxSent, 2), NewAddressSketch(consts.NumTxSent, 2)
+	a.Add("0x1", big.NewInt(10))
+	a.Add("0x2", big.NewInt(8))
+	b.Add("0x1", big.NewInt(1))
+	b.Add("0x3", big.NewInt(6))
+	b.Add("0x4", big.NewInt(6)) // replaces 0x1
+	a.Merge(b)
+	checkAddressSketch(t, a, map[string]*big.Int{"0x1": big.NewInt(11), "0x2": big.NewInt(8), "0x3": big.NewInt(6), "0x4": big.NewInt(6)})
+	if top := a.Sorted()[0]; top.Address != "0x1" || top.Count.Int64() != 16 || top.Error.Int64() != 6 {
+		t.Errorf("got %s with %s (error %s), want 0x1 with 16 (error 6)", top.Address, top.Count, top.Error)
+	}
+}
+
+func TestAnalysisWithAddressSketches(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.NumTopAddresses = 5
+	cfg.AddressSketchSize = 100
+	analysis := NewAnalysis(cfg, nil, nopAddressDetailService{})
+
+	addresses, values, sums := newSkewedStream(10_000, 50_000)
+	for i := range addresses {
+		address := common.HexToAddress(addresses[i])
+		analysis.GetOrCreateAddressStats(&address).Add(consts.NumTxSent, values[i])
+	}
+
+	// Memory is bounded: no stats per address, and at most AddressSketchSize counters per key
+	if len(analysis.Addresses) != 0 {
+		t.Errorf("%d addresses kept", len(analysis.Addresses))
+	}
+	for key, sketch := range analysis.addressSketches {
+		if sketch.Len() > cfg.AddressSketchSize {
+			t.Errorf("%s: %d counters, want at most %d", key, sketch.Len(), cfg.AddressSketchSize)
+		}
+	}
+	hexSums := make(map[string]*big.Int, len(sums))
+	for address, sum := range sums {
+		hexSums[strings.ToLower(common.HexToAddress(address).Hex())] = sum
+	}
+	checkAddressSketch(t, analysis.addressSketches[consts.NumTxSent], hexSums)
+
+	// The sketches are kept in full exports, e.g. of checkpoints
+	analysis.BuildTopAddresses()
+	restored, err := NewAnalysisFromJsonExport(DefaultConfig(), nil, NewFullAnalysisJsonExport(analysis), nopAddressDetailService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.numTopAddresses = cfg.NumTopAddresses
+	restored.BuildTopAddresses()
+	top, restoredTop := analysis.Data.TopAddresses[consts.NumTxSent], restored.Data.TopAddresses[consts.NumTxSent]
+	if restored.AddressSketchSize() != cfg.AddressSketchSize || len(top) != cfg.NumTopAddresses || len(restoredTop) != len(top) {
+		t.Fatalf("restored sketch size %d with %d top addresses, want %d with %d", restored.AddressSketchSize(), len(restoredTop), cfg.AddressSketchSize, len(top))
+	}
+	for i := range top {
+		if restoredTop[i].AddressDetail.Address != top[i].AddressDetail.Address || restoredTop[i].Get(consts.NumTxSent).Cmp(top[i].Get(consts.NumTxSent)) != 0 {
+			t.Errorf("[%d]: restored %s with %s, want %s with %s", i, restoredTop[i].AddressDetail.Address, restoredTop[i].Get(consts.NumTxSent), top[i].AddressDetail.Address, top[i].Get(consts.NumTxSent))
+		}
+	}
+}
+
+func benchmarkBuildTopAddresses(b *testing.B, numAddresses int, heap bool) {
+	analysis := newRandomAnalysis(numAddresses)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if heap {
+			analysis.BuildTopAddresses()
+		} else {
+			buildTopAddressesBySorting(analysis, DefaultConfig().NumTopAddresses)
+		}
+	}
+}
+
+func BenchmarkBuildTopAddressesHeap10k(b *testing.B)    { benchmarkBuildTopAddresses(b, 10_000, true) }
+func BenchmarkBuildTopAddressesSorting10k(b *testing.B) { benchmarkBuildTopAddresses(b, 10_000, false) }
+func BenchmarkBuildTopAddressesHeap100k(b *testing.B)   { benchmarkBuildTopAddresses(b, 100_000, true) }
+func BenchmarkBuildTopAddressesSorting100k(b *testing.B) {
+	benchmarkBuildTopAddresses(b, 100_000, false)
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"container/heap"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+// TxRanker ranks transactions by one property. Compare returns > 0 if a ranks higher than b, 0 if equal and < 0 if lower.
+type TxRanker struct {
+	Name    string
+	Compare func(a, b *TxStats) int
+}
+
+// NewBigIntTxRanker returns a ranker for a *big.Int property (nil counts as 0)
+func NewBigIntTxRanker(name string, value func(stats *TxStats) *big.Int) TxRanker {
+	return TxRanker{
+		Name: name,
+		Compare: func(a, b *TxStats) int {
+			return bigIntOrZero(value(a)).Cmp(bigIntOrZero(value(b)))
+		},
+	}
+}
+
+// NewIntTxRanker returns a ranker for an int property
+func NewIntTxRanker(name string, value func(stats *TxStats) int) TxRanker {
+	return TxRanker{
+		Name: name,
+		Compare: func(a, b *TxStats) int {
+			return value(a) - value(b)
+		},
+	}
+}
+
+func bigIntOrZero(i *big.Int) *big.Int {
+	if i == nil {
+		return new(big.Int)
+	}
+	return i
+}
+
+// DefaultTxRankers returns the transaction rankings of analyses without custom rankings (see NewAnalysis)
+func DefaultTxRankers() []TxRanker {
+	return []TxRanker{
+		NewBigIntTxRanker("GasFee", func(stats *TxStats) *big.Int { return stats.GasFee }),
+		NewBigIntTxRanker("Value", func(stats *TxStats) *big.Int { return stats.Value }),
+		NewIntTxRanker("DataSize", func(stats *TxStats) int { return stats.DataSize }),
+		NewBigIntTxRanker("GasPrice", func(stats *TxStats) *big.Int { return stats.EffectiveGasPrice }),
+		NewIntTxRanker("NumLogs", func(stats *TxStats) int { return stats.NumLogs }),
+		NewErc20TransferValueTxRanker(),
+	}
+}
+
+// Erc20TransferValueTxRanker is the name of the ranking by the largest ERC20 transfer in units of its token
+const Erc20TransferValueTxRanker = "Erc20TransferValue"
+
+// NewErc20TransferValueTxRanker returns the ranker by the largest ERC20 transfer of a transaction in units of its token
+// (see CompareErc20Amounts). The token details of a transaction are loaded when it's first compared, i.e. when it
+// competes for the top list (see TxStats.ResolveErc20Transfer).
+func NewErc20TransferValueTxRanker() TxRanker {
+	return TxRanker{
+		Name: Erc20TransferValueTxRanker,
+		Compare: func(a, b *TxStats) int {
+			a.ResolveErc20Transfer()
+			b.ResolveErc20Transfer()
+			return CompareErc20Amounts(a.Erc20TransferValue, a.Erc20TransferToken, b.Erc20TransferValue, b.Erc20TransferToken)
+		},
+	}
+}
+
+// CompareErc20Amounts compares raw token amounts in units of their tokens, i.e. divided by 10^Decimals, so that amounts
+// of tokens with different decimals are comparable. Amounts of tokens that are not known as ERC20 tokens count as 0, as
+// their decimals are unknown (e.g. tokens that were not looked up in LowApiCallMode).
+func CompareErc20Amounts(a *big.Int, tokenA addressdetail.AddressDetail, b *big.Int, tokenB addressdetail.AddressDetail) int {
+	// a / 10^decimalsA <> b / 10^decimalsB  <=>  a * 10^decimalsB <> b * 10^decimalsA
+	scaledA, scaledB := new(big.Int), new(big.Int)
+	if tokenA.IsErc20() && a != nil {
+		scaledA.Mul(a, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokenB.Decimals)), nil))
+	}
+	if tokenB.IsErc20() && b != nil {
+		scaledB.Mul(b, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokenA.Decimals)), nil))
+	}
+	return scaledA.Cmp(scaledB)
+}
+
+// ValidateTxRankers returns an error if a ranking has no name or compare function, or if two rankings have the same
+// name (the name is the key of the top list)
+func ValidateTxRankers(rankers []TxRanker) error {
+	names := make(map[string]bool, len(rankers))
+	for _, ranker := range rankers {
+		if len(ranker.Name) == 0 || ranker.Compare == nil {
+			return fmt.Errorf("tx ranker %q needs a name and a compare function", ranker.Name)
+		}
+		if names[ranker.Name] {
+			return fmt.Errorf("tx ranker %s is used twice", ranker.Name)
+		}
+		names[ranker.Name] = true
+	}
+	return nil
+}
+
+// TxTopList keeps the top-N transactions of a ranking. It is a min-heap, so the lowest ranked transaction can be replaced
+// in O(log n) if a higher ranked one is added.
+type TxTopList struct {
+	Ranker TxRanker
+	Size   int
+	items  txHeap
+}
+
+func NewTxTopList(ranker TxRanker, size int) *TxTopList {
+	return &TxTopList{
+		Ranker: ranker,
+		Size:   size,
+		items:  txHeap{compare: compareByRankAndHash(ranker), items: make([]TxStats, 0, size)},
+	}
+}
+
+// compareByRankAndHash makes the ranking a total order: for equal values, the lower tx hash ranks higher. The top list
+// then doesn't depend on the order in which transactions are added, which allows merging lists of partial analyses.
+func compareByRankAndHash(ranker TxRanker) func(a, b *TxStats) int {
+	return func(a, b *TxStats) int {
+		if c := ranker.Compare(a, b); c != 0 {
+			return c
+		}
+		return strings.Compare(b.Hash, a.Hash)
+	}
+}
+
+// Add adds the transaction if the list is not full yet, or if it ranks higher than the lowest entry
+func (list *TxTopList) Add(stats TxStats) {
+	if list.Size <= 0 {
+		return
+	}
+
+	if list.items.Len() < list.Size {
+		heap.Push(&list.items, stats)
+	} else if list.items.compare(&stats, &list.items.items[0]) > 0 {
+		list.items.items[0] = stats
+		heap.Fix(&list.items, 0)
+	}
+}
+
+// Merge adds all transactions of other, which must have the same ranking
+func (list *TxTopList) Merge(other *TxTopList) {
+	for _, stats := range other.items.items {
+		list.Add(stats)
+	}
+}
+
+// Sorted returns the transactions, highest ranked first, with their ERC20 transfers resolved (see
+// TxStats.ResolveErc20Transfer). Equal values are ordered by tx hash.
+func (list *TxTopList) Sorted() []TxStats {
+	ret := make([]TxStats, len(list.items.items))
+	copy(ret, list.items.items)
+	for i := range ret {
+		ret[i].ResolveErc20Transfer()
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return list.items.compare(&ret[i], &ret[j]) > 0
+	})
+	return ret
+}
+
+// txHeap implements heap.Interface, with the lowest ranked transaction at index 0
+type txHeap struct {
+	compare func(a, b *TxStats) int
+	items   []TxStats
+}
+
+func (h txHeap) Len() int            { return len(h.items) }
+func (h txHeap) Less(i, j int) bool  { return h.compare(&h.items[i], &h.items[j]) < 0 }
+func (h txHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *txHeap) Push(x interface{}) { h.items = append(h.items, x.(TxStats)) }
+func (h *txHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
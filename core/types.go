@@ -3,12 +3,10 @@ package core
 import (
 	"fmt"
 	"math/big"
-	"sort"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/metachris/ethereum-go-experiments/consts"
 	"github.com/metachris/go-ethutils/addressdetail"
 	"github.com/metachris/go-ethutils/utils"
@@ -21,6 +19,8 @@ import (
 type AddressStats struct {
 	AddressDetail addressdetail.AddressDetail
 	Stats         map[string]*big.Int
+
+	sketches map[string]*AddressSketch // if set, Add adds to the sketches instead of Stats
 }
 
 func NewAddressStats(address string) *AddressStats {
@@ -31,6 +31,12 @@ func NewAddressStats(address string) *AddressStats {
 }
 
 func (stats *AddressStats) Add(key string, val *big.Int) {
+	if stats.sketches != nil {
+		if sketch := stats.sketches[key]; sketch != nil {
+			sketch.Add(stats.AddressDetail.Address, val)
+		}
+		return
+	}
 	if stats.Stats[key] == nil {
 		stats.Stats[key] = new(big.Int)
 	}
@@ -54,18 +60,34 @@ func (stats *AddressStats) Get(key string) *big.Int {
 // TxStats
 //
 type TxStats struct {
-	Hash     string
-	FromAddr addressdetail.AddressDetail
-	ToAddr   addressdetail.AddressDetail
-	GasUsed  *big.Int
-	GasFee   *big.Int
-	Value    *big.Int
-	DataSize int
-	Success  bool
-	Tag      string // internally used to mark specific txs
+	Hash              string
+	FromAddr          addressdetail.AddressDetail
+	ToAddr            addressdetail.AddressDetail
+	GasUsed           *big.Int
+	GasFee            *big.Int
+	GasFeeBurned      *big.Int
+	GasFeeTip         *big.Int
+	EffectiveGasPrice *big.Int
+	Value             *big.Int
+	DataSize          int
+	NumLogs           int
+	Success           bool
+	Tag               string // internally used to mark specific txs
+
+	// Largest ERC20 Transfer event of the transaction in units of its token (see CompareErc20Amounts). The value is the
+	// raw amount, divided by 10^Erc20TransferToken.Decimals for the amount in units of the token. Set by
+	// ResolveErc20Transfer.
+	Erc20TransferToken addressdetail.AddressDetail
+	Erc20TransferValue *big.Int
+
+	erc20Transfers []TokenTransfer   // largest raw amount per token, until ResolveErc20Transfer
+	tokenDetails   *tokenDetailCache // nil if resolved
 }
 
-func NewTxStatsFromTransactions(tx *types.Transaction, receipt *types.Receipt) TxStats {
+// NewTxStats builds the stats of a transaction. baseFee is the base fee of the block, or nil before London. The ERC20
+// transfers are kept as raw amounts per token, and the largest in units of its token is resolved only when needed (see
+// ResolveErc20Transfer).
+func (analysis *Analysis) NewTxStats(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int) TxStats {
 	txSuccess := true
 	txGasUsed := common.Big1
 	if receipt != nil {
@@ -73,7 +95,7 @@ func NewTxStatsFromTransactions(tx *types.Transaction, receipt *types.Receipt) T
 		txGasUsed = big.NewInt(int64(receipt.GasUsed))
 	}
 
-	txGasFee := new(big.Int).Mul(txGasUsed, tx.GasPrice())
+	fees := NewTxFees(tx, txGasUsed, baseFee)
 
 	to := addressdetail.NewAddressDetail("")
 	if tx.To() != nil {
@@ -81,22 +103,73 @@ func NewTxStatsFromTransactions(tx *types.Transaction, receipt *types.Receipt) T
 	}
 
 	from := addressdetail.NewAddressDetail("")
-	if fromAddr, err := utils.GetTxSender(tx); err == nil {
+	if fromAddr, err := GetTxSender(tx); err == nil {
 		from = addressdetail.NewAddressDetail(fromAddr.Hex())
 	}
 
+	numLogs := 0
+	var erc20Transfers []TokenTransfer
+	if receipt != nil {
+		numLogs = len(receipt.Logs)
+	nextLog:
+		for _, log := range receipt.Logs {
+			transfer, ok := ParseTransferLog(log)
+			if !ok || transfer.IsErc721 || transfer.Value.Sign() == 0 {
+				continue
+			}
+			// Of the same token, the larger raw amount is larger
+			for i := range erc20Transfers {
+				if erc20Transfers[i].Token == transfer.Token {
+					if transfer.Value.Cmp(erc20Transfers[i].Value) > 0 {
+						erc20Transfers[i] = transfer
+					}
+					continue nextLog
+				}
+			}
+			erc20Transfers = append(erc20Transfers, transfer)
+		}
+	}
+
 	return TxStats{
-		Hash:     tx.Hash().Hex(),
-		GasFee:   txGasFee,
-		GasUsed:  txGasUsed,
-		Value:    tx.Value(),
-		DataSize: len(tx.Data()),
-		Success:  txSuccess,
-		FromAddr: from,
-		ToAddr:   to,
+		Hash:              tx.Hash().Hex(),
+		GasFee:            fees.GasFee,
+		GasFeeBurned:      fees.Burned,
+		GasFeeTip:         fees.Tip,
+		EffectiveGasPrice: fees.EffectiveGasPrice,
+		GasUsed:           txGasUsed,
+		Value:             tx.Value(),
+		DataSize:          len(tx.Data()),
+		NumLogs:           numLogs,
+		Success:           txSuccess,
+		FromAddr:          from,
+		ToAddr:            to,
+
+		Erc20TransferToken: addressdetail.NewAddressDetail(""),
+		Erc20TransferValue: new(big.Int),
+
+		erc20Transfers: erc20Transfers,
+		tokenDetails:   analysis.tokenDetails,
 	}
 }
 
+// ResolveErc20Transfer sets the largest ERC20 transfer in units of its token, with the details of the tokens loaded once
+// per token and analysis. It is called by the ranking by ERC20 transfer value when the transaction competes for the top
+// list, and for the sorted top lists (see TxTopList.Sorted).
+func (stats *TxStats) ResolveErc20Transfer() {
+	if stats.tokenDetails == nil {
+		return
+	}
+	for _, transfer := range stats.erc20Transfers {
+		token := stats.tokenDetails.get(transfer.Token.Hex())
+		if stats.Erc20TransferToken.Address == "" || CompareErc20Amounts(transfer.Value, token, stats.Erc20TransferValue, stats.Erc20TransferToken) > 0 {
+			stats.Erc20TransferToken = token
+			stats.Erc20TransferValue = transfer.Value
+		}
+	}
+	stats.erc20Transfers = nil
+	stats.tokenDetails = nil
+}
+
 func (stats TxStats) String() string {
 	failedMsg := "         "
 	if !stats.Success {
@@ -106,13 +179,7 @@ func (stats TxStats) String() string {
 	if len(stats.Tag) > 0 {
 		tagMsg = fmt.Sprintf("%s\t", stats.Tag)
 	}
-	return fmt.Sprintf("%s%s%s\tgasfee: %8s ETH\tval: %14v \t datasize: %-8d \t\t %s -> %s", tagMsg, stats.Hash, failedMsg, utils.WeiBigIntToEthString(stats.GasFee, 4), utils.WeiBigIntToEthString(stats.Value, 4), stats.DataSize, stats.FromAddr.Address, stats.ToAddr.Address)
-}
-
-type TopTransactionData struct {
-	GasFee   []TxStats
-	Value    []TxStats
-	DataSize []TxStats
+	return fmt.Sprintf("%s%s%s\tgasfee: %8s ETH (%6s gwei/gas)\tval: %14v \t datasize: %-8d \t\t %s -> %s", tagMsg, stats.Hash, failedMsg, utils.WeiBigIntToEthString(stats.GasFee, 4), WeiToGweiString(stats.EffectiveGasPrice), utils.WeiBigIntToEthString(stats.Value, 4), stats.DataSize, stats.FromAddr.Address, stats.ToAddr.Address)
 }
 
 //
@@ -125,7 +192,9 @@ type AnalysisData struct {
 	EndBlockTimestamp   uint64
 
 	TopAddresses       map[string][]AddressStats
-	TopTransactions    TopTransactionData // todo: refactor for generic counters, like topaddresses
+	TopTokens          map[string][]TokenStats
+	TopMiners          []MinerStats
+	TopTransactions    map[string][]TxStats // key: TxRanker.Name
 	TaggedTransactions []TxStats
 
 	TxTypes       map[uint8]int
@@ -136,6 +205,9 @@ type AnalysisData struct {
 	GasUsed            *big.Int
 	GasFeeTotal        *big.Int
 	GasFeeFailedTx     *big.Int
+	GasFeeBurned       *big.Int // base fee (EIP-1559)
+	GasFeeTips         *big.Int // priority fees, received by the miners
+	GasFeeMaxHeadroom  *big.Int // max fee allowed by the senders, but not paid
 
 	NumTransactions              int
 	NumTransactionsFailed        int
@@ -147,6 +219,34 @@ type AnalysisData struct {
 
 	NumFlashbotsTransactionsSuccess int
 	NumFlashbotsTransactionsFailed  int
+
+	Errors []AnalysisError // skipped blocks and transactions, in order of the block number
+}
+
+// AnalysisError is a block or transaction that was skipped, because it couldn't be fetched or processed. The stats
+// don't include it.
+type AnalysisError struct {
+	BlockNumber int64  `json:"blockNumber"`
+	TxHash      string `json:"txHash,omitempty"` // empty if the whole block was skipped
+	Error       string `json:"error"`
+}
+
+// AddSkippedBlock records a block that couldn't be fetched or processed
+func (analysis *AnalysisData) AddSkippedBlock(number int64, err error) {
+	analysis.Errors = append(analysis.Errors, AnalysisError{BlockNumber: number, Error: err.Error()})
+}
+
+// AddSkippedTx records a transaction that couldn't be processed
+func (analysis *AnalysisData) AddSkippedTx(blockNumber int64, hash common.Hash, err error) {
+	analysis.Errors = append(analysis.Errors, AnalysisError{BlockNumber: blockNumber, TxHash: hash.Hex(), Error: err.Error()})
+}
+
+// AvgEffectiveGasPrice returns the average price per gas paid in the analyzed blocks
+func (analysis *AnalysisData) AvgEffectiveGasPrice() *big.Int {
+	if analysis.GasUsed.Sign() == 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Div(analysis.GasFeeTotal, analysis.GasUsed)
 }
 
 // GetAllTopAddressStats returns a list of all top addresses across any of the statistics
@@ -163,44 +263,108 @@ type IAddressDetailService interface {
 }
 
 type Analysis struct {
-	Data      AnalysisData
-	Addresses map[string]*AddressStats `json:"-"`
+	Data          AnalysisData
+	Addresses     map[string]*AddressStats      `json:"-"`
+	AddressTokens map[string]*AddressTokenStats `json:"-"` // key: <address>:<token>
+	Blocks        []BlockStats                  `json:"-"` // in the order they were processed
+	Miners        map[string]*MinerStats        `json:"-"`
+	TxTopLists    map[string]*TxTopList         `json:"-"` // key: TxRanker.Name
 
 	addressDetailService IAddressDetailService
-	client               *ethclient.Client
+	numTopAddresses      int                                    // length of the address, token and miner top lists
+	txRankers            []TxRanker                             // in order of TxTopLists
+	tokenDetails         *tokenDetailCache                      // of the ERC20 transfers of the top transactions
+	addressSketches      map[string]*AddressSketch              // per stats key, instead of Addresses (see Config.AddressSketchSize)
 }
 
-func NewAnalysis(cfg Config, client *ethclient.Client, addressDetailsService IAddressDetailService) *Analysis {
+// NewAnalysis returns an empty analysis with the top list lengths of cfg, and a top list for each of txRankers
+// (DefaultTxRankers if nil)
+func NewAnalysis(cfg Config, txRankers []TxRanker, addressDetailsService IAddressDetailService) *Analysis {
 	data := AnalysisData{
 		ValueTotalWei: new(big.Int),
 		TxTypes:       make(map[uint8]int),
 		TopAddresses:  make(map[string][]AddressStats),
+		TopTokens:     make(map[string][]TokenStats),
+		TopMiners:     make([]MinerStats, 0),
 
-		GasUsed:        new(big.Int),
-		GasFeeTotal:    new(big.Int),
-		GasFeeFailedTx: new(big.Int),
+		GasUsed:           new(big.Int),
+		GasFeeTotal:       new(big.Int),
+		GasFeeFailedTx:    new(big.Int),
+		GasFeeBurned:      new(big.Int),
+		GasFeeTips:        new(big.Int),
+		GasFeeMaxHeadroom: new(big.Int),
 
-		TopTransactions: TopTransactionData{
-			GasFee:   make([]TxStats, 0, cfg.NumTopTransactions),
-			Value:    make([]TxStats, 0, cfg.NumTopTransactions),
-			DataSize: make([]TxStats, 0, cfg.NumTopTransactions),
-		},
+		TopTransactions: make(map[string][]TxStats),
 	}
 
-	return &Analysis{
+	if len(txRankers) == 0 {
+		txRankers = DefaultTxRankers()
+	}
+	txTopLists := make(map[string]*TxTopList, len(txRankers))
+	for _, ranker := range txRankers {
+		txTopLists[ranker.Name] = NewTxTopList(ranker, cfg.NumTopTransactions)
+	}
+
+	analysis := &Analysis{
 		Data:                 data,
 		Addresses:            make(map[string]*AddressStats),
+		AddressTokens:        make(map[string]*AddressTokenStats),
+		Blocks:               make([]BlockStats, 0),
+		Miners:               make(map[string]*MinerStats),
+		TxTopLists:           txTopLists,
 		addressDetailService: addressDetailsService,
-		client:               client,
+		numTopAddresses:      cfg.NumTopAddresses,
+		txRankers:            txRankers,
+		tokenDetails:         newTokenDetailCache(addressDetailsService),
+	}
+	if cfg.AddressSketchSize > 0 {
+		analysis.enableAddressSketches(cfg.AddressSketchSize)
+	}
+	return analysis
+}
+
+// enableAddressSketches approximates the top addresses with sketches of the capacity, into which the stats of the
+// addresses so far are moved
+func (analysis *Analysis) enableAddressSketches(capacity int) {
+	analysis.addressSketches = make(map[string]*AddressSketch, len(consts.AddressStatsKeys))
+	for _, key := range consts.AddressStatsKeys {
+		analysis.addressSketches[key] = NewAddressSketch(key, capacity)
+	}
+	for addr, stats := range analysis.Addresses {
+		analysis.addToAddressSketches(addr, stats.Stats)
+	}
+	analysis.Addresses = make(map[string]*AddressStats)
+}
+
+// addToAddressSketches adds the stats of an address, e.g. of another analysis, to the sketches
+func (analysis *Analysis) addToAddressSketches(addr string, stats map[string]*big.Int) {
+	for key, val := range stats {
+		if sketch := analysis.addressSketches[key]; sketch != nil {
+			sketch.Add(addr, val)
+		}
 	}
 }
 
+// AddressSketchSize returns the number of counters per stats key if the top addresses are approximated (see
+// Config.AddressSketchSize), else 0
+func (analysis *Analysis) AddressSketchSize() int {
+	for _, sketch := range analysis.addressSketches {
+		return sketch.Capacity
+	}
+	return 0
+}
+
 func (result *Analysis) GetOrCreateAddressStats(address *common.Address) *AddressStats {
 	if address == nil {
 		return NewAddressStats("")
 	}
 
 	addr := strings.ToLower(address.String())
+	if result.addressSketches != nil {
+		// Not kept: the values are added to the sketches
+		return &AddressStats{AddressDetail: addressdetail.NewAddressDetail(addr), sketches: result.addressSketches}
+	}
+
 	addrStats, isAddressKnown := result.Addresses[addr]
 	if !isAddressKnown {
 		addrStats = NewAddressStats(addr)
@@ -214,97 +378,89 @@ func (analysis *Analysis) EnsureAddressDetailIsLoaded(a *addressdetail.AddressDe
 	analysis.addressDetailService.EnsureIsLoaded(a)
 }
 
-func (analysis *Analysis) TagTransactionStats(txStats TxStats, tag string, client *ethclient.Client) {
+func (analysis *Analysis) TagTransactionStats(txStats TxStats, tag string) {
 	txStats.Tag = tag
+	txStats.ResolveErc20Transfer()
 	analysis.EnsureAddressDetailIsLoaded(&txStats.FromAddr)
 	analysis.EnsureAddressDetailIsLoaded(&txStats.ToAddr)
 	analysis.Data.TaggedTransactions = append(analysis.Data.TaggedTransactions, txStats)
 }
 
-// AnalysisResult.BuildTopAddresses() sorts the addresses into TopAddresses after all blocks have been added.
-// Ensures that details for all top addresses are queried from blockchain
+// AnalysisResult.BuildTopAddresses() builds TopAddresses for every stats key after all blocks have been added, in a
+// single pass over all addresses. Ensures that details for all top addresses are queried from blockchain
+//
+// With address sketches (see Config.AddressSketchSize), the top addresses are the largest counters, and have only the
+// approximate value of their key.
 func (analysis *Analysis) BuildTopAddresses() {
-	numEntries := Cfg.NumTopAddresses
+	if analysis.addressSketches != nil {
+		analysis.buildTopAddressesFromSketches()
+		return
+	}
 
-	// Convert addresses from map into a sortable array
-	addressArray := make([]AddressStats, 0, len(analysis.Addresses))
-	for _, k := range analysis.Addresses {
-		addressArray = append(addressArray, *k)
+	lists := make([]*AddressTopList, len(consts.AddressStatsKeys))
+	for i, key := range consts.AddressStatsKeys {
+		lists[i] = NewAddressTopList(key, analysis.numTopAddresses)
 	}
 
-	// Get top entries for each key
-	for _, key := range consts.AddressStatsKeys {
-		analysis.BuildTopAddressesForKey(&addressArray, key, numEntries)
+	for _, stats := range analysis.Addresses {
+		for _, list := range lists {
+			list.Add(stats)
+		}
+	}
+
+	for _, list := range lists {
+		analysis.Data.TopAddresses[list.Key] = list.Sorted()
+		for i := range analysis.Data.TopAddresses[list.Key] {
+			analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopAddresses[list.Key][i].AddressDetail)
+		}
 	}
 }
 
-// Takes pointer to all addresses and builds a top-list
-func (analysis *Analysis) BuildTopAddressesForKey(allAddresses *[]AddressStats, key string, numItems int) (ret []AddressStats) {
-	ret = make([]AddressStats, 0, numItems)
-	sort.SliceStable(*allAddresses, func(i, j int) bool {
-		a := (*allAddresses)[i].Get(key)
-		b := (*allAddresses)[j].Get(key)
-		return a.Cmp(b) == 1
-	})
-
-	for i := 0; i < len(*allAddresses) && i < numItems; i++ {
-		item := (*allAddresses)[i]
-		if item.Get(key).Cmp(common.Big0) == 1 {
-			analysis.EnsureAddressDetailIsLoaded(&item.AddressDetail)
-			ret = append(ret, item)
+func (analysis *Analysis) buildTopAddressesFromSketches() {
+	for key, sketch := range analysis.addressSketches {
+		counters := sketch.Sorted()
+		if len(counters) > analysis.numTopAddresses {
+			counters = counters[:analysis.numTopAddresses]
+		}
+		list := make([]AddressStats, len(counters))
+		for i, counter := range counters {
+			list[i] = *NewAddressStats(counter.Address)
+			list[i].Stats[key] = counter.Count
+			analysis.EnsureAddressDetailIsLoaded(&list[i].AddressDetail)
 		}
+		analysis.Data.TopAddresses[key] = list
 	}
+}
 
-	analysis.Data.TopAddresses[key] = ret
-	return ret
+// TxRankers returns the rankings of the top transactions, in order (see NewAnalysis)
+func (analysis *Analysis) TxRankers() []TxRanker {
+	return analysis.txRankers
 }
 
-// AddTxToTopList builds the top transactions list
-func (analysis *Analysis) AddTxToTopList(tx *types.Transaction, receipt *types.Receipt) {
-	stats := NewTxStatsFromTransactions(tx, receipt)
-
-	// Sort by Gas fee
-	if len(analysis.Data.TopTransactions.GasFee) < cap(analysis.Data.TopTransactions.GasFee) { // add new item to array
-		analysis.Data.TopTransactions.GasFee = append(analysis.Data.TopTransactions.GasFee, stats)
-	} else if stats.GasFee.Cmp(analysis.Data.TopTransactions.GasFee[len(analysis.Data.TopTransactions.GasFee)-1].GasFee) == 1 { // replace last item
-		analysis.Data.TopTransactions.GasFee[len(analysis.Data.TopTransactions.GasFee)-1] = stats
-	}
-	sort.SliceStable(analysis.Data.TopTransactions.GasFee, func(i, j int) bool {
-		return analysis.Data.TopTransactions.GasFee[i].GasFee.Cmp(analysis.Data.TopTransactions.GasFee[j].GasFee) == 1
-	})
-
-	// Sort by value
-	if len(analysis.Data.TopTransactions.Value) < cap(analysis.Data.TopTransactions.Value) { // add new item to array
-		analysis.Data.TopTransactions.Value = append(analysis.Data.TopTransactions.Value, stats)
-	} else if stats.Value.Cmp(analysis.Data.TopTransactions.Value[len(analysis.Data.TopTransactions.Value)-1].Value) == 1 {
-		analysis.Data.TopTransactions.Value[len(analysis.Data.TopTransactions.Value)-1] = stats
-	}
-	sort.SliceStable(analysis.Data.TopTransactions.Value, func(i, j int) bool {
-		return analysis.Data.TopTransactions.Value[i].Value.Cmp(analysis.Data.TopTransactions.Value[j].Value) == 1
-	})
-
-	// Sort by len(data)
-	if len(analysis.Data.TopTransactions.DataSize) < cap(analysis.Data.TopTransactions.DataSize) { // add new item to array
-		analysis.Data.TopTransactions.DataSize = append(analysis.Data.TopTransactions.DataSize, stats)
-	} else if stats.DataSize > analysis.Data.TopTransactions.DataSize[len(analysis.Data.TopTransactions.DataSize)-1].DataSize {
-		analysis.Data.TopTransactions.DataSize[len(analysis.Data.TopTransactions.DataSize)-1] = stats
-	}
-	sort.SliceStable(analysis.Data.TopTransactions.DataSize, func(i, j int) bool {
-		return analysis.Data.TopTransactions.DataSize[i].DataSize > analysis.Data.TopTransactions.DataSize[j].DataSize
-	})
+// AddTxToTopList adds the transaction to the top lists of all rankings (see TxRankers)
+func (analysis *Analysis) AddTxToTopList(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int) {
+	analysis.AddTxStatsToTopList(analysis.NewTxStats(tx, receipt, baseFee))
 }
 
-func (analysis *Analysis) EnsureTopTransactionAddressDetails() {
-	for i := range analysis.Data.TopTransactions.Value {
-		analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopTransactions.Value[i].FromAddr)
-		analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopTransactions.Value[i].ToAddr)
+// AddTxStatsToTopList adds the stats of a transaction (see NewTxStats) to the top lists of all rankings
+func (analysis *Analysis) AddTxStatsToTopList(stats TxStats) {
+	for _, list := range analysis.TxTopLists {
+		list.Add(stats)
 	}
-	for i := range analysis.Data.TopTransactions.GasFee {
-		analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopTransactions.GasFee[i].FromAddr)
-		analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopTransactions.GasFee[i].ToAddr)
+}
+
+// BuildTopTransactions sorts the top lists of all rankings into TopTransactions
+func (analysis *Analysis) BuildTopTransactions() {
+	for name, list := range analysis.TxTopLists {
+		analysis.Data.TopTransactions[name] = list.Sorted()
 	}
-	for i := range analysis.Data.TopTransactions.DataSize {
-		analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopTransactions.DataSize[i].FromAddr)
-		analysis.EnsureAddressDetailIsLoaded(&analysis.Data.TopTransactions.DataSize[i].ToAddr)
+}
+
+func (analysis *Analysis) EnsureTopTransactionAddressDetails() {
+	for _, list := range analysis.Data.TopTransactions {
+		for i := range list {
+			analysis.EnsureAddressDetailIsLoaded(&list[i].FromAddr)
+			analysis.EnsureAddressDetailIsLoaded(&list[i].ToAddr)
+		}
 	}
 }
@@ -1,17 +1,25 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/jmoiron/sqlx"
 	"github.com/metachris/ethereum-go-experiments/core"
 	"github.com/metachris/go-ethutils/addressdetail"
 )
 
-func NewDatabaseConnection(cfg core.PostgresConfig) *sqlx.DB {
+var (
+	ErrInvalidSortKey = errors.New("invalid sort key")
+	ErrInvalidBucket  = errors.New("invalid time series bucket")
+)
+
+// NewDatabaseConnection connects to the database, and creates the tables that don't exist yet
+func NewDatabaseConnection(cfg core.PostgresConfig) (*sqlx.DB, error) {
 	sslMode := "require"
 	if cfg.DisableTLS {
 		sslMode = "disable"
@@ -29,28 +37,40 @@ func NewDatabaseConnection(cfg core.PostgresConfig) *sqlx.DB {
 		RawQuery: q.Encode(),
 	}
 
-	db := sqlx.MustConnect("postgres", u.String())
-	db.MustExec(Schema)
-	return db
+	db, err := sqlx.Connect("postgres", u.String())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(Schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	return db, nil
 }
 
 type StatsService struct {
 	DB *sqlx.DB
 }
 
-func NewStatsService(cfg core.PostgresConfig) StatsService {
-	db := NewDatabaseConnection(cfg)
-	return StatsService{
-		DB: db,
+func NewStatsService(cfg core.PostgresConfig) (*StatsService, error) {
+	db, err := NewDatabaseConnection(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &StatsService{
+		DB: db,
+	}, nil
 }
 
-func (s *StatsService) Reset() {
-	s.DB.MustExec(`DROP TABLE "analysis_address_stat";`)
-	s.DB.MustExec(`DROP TABLE "analysis";`)
-	s.DB.MustExec(`DROP TABLE "address";`)
-	s.DB.MustExec(`DROP TABLE "block";`)
-	s.DB.MustExec(Schema)
+// Reset drops all tables and creates them again
+func (s *StatsService) Reset() error {
+	for _, table := range []string{"analysis_address_stat", "analysis", "address", "block"} {
+		if _, err := s.DB.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, table)); err != nil {
+			return err
+		}
+	}
+	_, err := s.DB.Exec(Schema)
+	return err
 }
 
 func (s *StatsService) Close() {
@@ -60,13 +80,30 @@ func (s *StatsService) Close() {
 /*
  * READ OPERATIONS
  */
-func (s *StatsService) Address(address string) (addr addressdetail.AddressDetail, found bool) {
-	err := s.DB.Get(&addr, "SELECT * FROM address WHERE address=$1", strings.ToLower(address))
-	if err != nil {
-		// fmt.Println("err:", err, addr)
-		return addr, false
+func (s *StatsService) Address(address string) (addr addressdetail.AddressDetail, found bool, err error) {
+	err = s.DB.Get(&addr, "SELECT Address, Name, Type, Symbol, Decimals FROM address WHERE address=$1", strings.ToLower(address))
+	if err == sql.ErrNoRows {
+		return addr, false, nil
+	} else if err != nil {
+		return addr, false, err
 	}
-	return addr, true
+	return addr, true, nil
+}
+
+// GetAddressDetail returns an address detail and when it was saved, to use the address table as addressdata.AddressStore.
+// Addresses without type (saved with the stats of an analysis, but never looked up) are not found.
+func (s *StatsService) GetAddressDetail(address string) (detail addressdetail.AddressDetail, updatedAt time.Time, found bool, err error) {
+	var entry struct {
+		addressdetail.AddressDetail
+		UpdatedAt time.Time
+	}
+	err = s.DB.Get(&entry, "SELECT Address, Name, Type, Symbol, Decimals, UpdatedAt FROM address WHERE address=$1", strings.ToLower(address))
+	if err == sql.ErrNoRows {
+		return detail, updatedAt, false, nil
+	} else if err != nil {
+		return detail, updatedAt, false, err
+	}
+	return entry.AddressDetail, entry.UpdatedAt, !entry.AddressDetail.IsInitial(), nil
 }
 
 func (s *StatsService) Analysis(id int) (entry AnalysisEntry, err error) {
@@ -74,15 +111,31 @@ func (s *StatsService) Analysis(id int) (entry AnalysisEntry, err error) {
 	return entry, err
 }
 
+// LoadAnalysis returns the analysis with the stats of its top addresses as core.Analysis (see NewAnalysisFromEntry)
+func (s *StatsService) LoadAnalysis(cfg core.Config, id int, ads core.IAddressDetailService) (*core.Analysis, error) {
+	entry, err := s.Analysis(id)
+	if err != nil {
+		return nil, err
+	}
+
+	addressStats, err := s.AddressStatsForAnalysis(id)
+	if err != nil {
+		return nil, err
+	}
+	return NewAnalysisFromEntry(cfg, entry, addressStats, ads)
+}
+
+// AddressStatsForAnalysis returns the stats of all top addresses of an analysis
 func (s *StatsService) AddressStatsForAnalysis(analysisId int) (entries []AnalysisAddressStatsEntryWithAddress, err error) {
-	rows, err := s.DB.Queryx("SELECT * FROM analysis_address_stat INNER JOIN address ON (address.address = analysis_address_stat.address) WHERE Analysis_id=$1", analysisId)
+	rows, err := s.DB.Queryx(`SELECT analysis_address_stat.*, address.Type, address.Name, address.Symbol, address.Decimals
+		FROM analysis_address_stat INNER JOIN address ON (address.address = analysis_address_stat.address) WHERE Analysis_id=$1`, analysisId)
 	if err != nil {
-		fmt.Println(err)
 		return entries, err
 	}
+	defer rows.Close()
 
 	entries = make([]AnalysisAddressStatsEntryWithAddress, 0)
-	entryAddressMap := make(map[string]bool) // helper to find repeated entries (bug: concurrent DB connections save two stats concurrently)
+	entryAddressMap := make(map[string]bool) // helper to skip repeated entries, which databases created before the unique index can contain
 
 	for rows.Next() {
 		var row AnalysisAddressStatsEntryWithAddress
@@ -98,30 +151,280 @@ func (s *StatsService) AddressStatsForAnalysis(analysisId int) (entries []Analys
 		entryAddressMap[row.Address] = true
 	}
 
-	return entries, nil
+	return entries, rows.Err()
+}
+
+// Analyses returns up to limit analyses with id < beforeId (0 for no limit), newest first.
+// fromDate and toDate (yyyy-mm-dd, inclusive) are optional filters.
+func (s *StatsService) Analyses(fromDate string, toDate string, beforeId int, limit int) (entries []AnalysisEntry, err error) {
+	entries = make([]AnalysisEntry, 0)
+	err = s.DB.Select(&entries, `SELECT * FROM analysis
+		WHERE ($1 = '' OR Date >= $1) AND ($2 = '' OR Date <= $2) AND ($3 = 0 OR Id < $3)
+		ORDER BY Id DESC LIMIT $4`, fromDate, toDate, beforeId, limit)
+	return entries, err
+}
+
+// AllAnalyses returns all analyses, ordered by start time
+func (s *StatsService) AllAnalyses() (entries []AnalysisEntry, err error) {
+	entries = make([]AnalysisEntry, 0)
+	err = s.DB.Select(&entries, "SELECT * FROM analysis ORDER BY StartBlockNumber, EndBlockNumber")
+	return entries, err
+}
+
+// AddressStatsPage is one page of address stats of an analysis, sorted by a stats key
+type AddressStatsPage struct {
+	Entries []AnalysisAddressStatsEntryWithAddress
+
+	// Sort value and id of the last entry, to be used as cursor for the next page
+	LastSortValue string
+	LastId        int
+}
+
+// AddressStatsForAnalysisSorted returns up to limit address stats of an analysis, sorted descending by one of
+// AddressStatsSortColumns. If afterSortValue is not empty, only entries after (afterSortValue, afterId) are returned.
+func (s *StatsService) AddressStatsForAnalysisSorted(analysisId int, sortKey string, afterSortValue string, afterId int, limit int) (page AddressStatsPage, err error) {
+	page.Entries = make([]AnalysisAddressStatsEntryWithAddress, 0)
+	sortColumn, found := AddressStatsSortColumns[sortKey]
+	if !found {
+		return page, fmt.Errorf("%w: %s", ErrInvalidSortKey, sortKey)
+	}
+
+	var rows *sqlx.Rows
+	query := fmt.Sprintf(`SELECT analysis_address_stat.*, address.Type, address.Name, address.Symbol, address.Decimals, (%s)::text AS SortValue
+		FROM analysis_address_stat INNER JOIN address ON (address.address = analysis_address_stat.address)
+		WHERE Analysis_id=$1 %%s
+		ORDER BY %s DESC, analysis_address_stat.Id DESC LIMIT $2`, sortColumn, sortColumn)
+	if afterSortValue == "" {
+		rows, err = s.DB.Queryx(fmt.Sprintf(query, ""), analysisId, limit)
+	} else {
+		cursorCondition := fmt.Sprintf("AND ((%s), analysis_address_stat.Id) < ($3::numeric, $4)", sortColumn)
+		rows, err = s.DB.Queryx(fmt.Sprintf(query, cursorCondition), analysisId, limit, afterSortValue, afterId)
+	}
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row struct {
+			AnalysisAddressStatsEntryWithAddress
+			SortValue string
+		}
+		if err = rows.StructScan(&row); err != nil {
+			return page, err
+		}
+		page.Entries = append(page.Entries, row.AnalysisAddressStatsEntryWithAddress)
+		page.LastSortValue = row.SortValue
+		page.LastId = row.Id
+	}
+
+	return page, rows.Err()
+}
+
+// Blocks returns up to limit blocks with number < beforeNumber (0 for no limit), latest first
+func (s *StatsService) Blocks(beforeNumber int64, limit int) (entries []BlockEntry, err error) {
+	entries = make([]BlockEntry, 0)
+	err = s.DB.Select(&entries, "SELECT * FROM block WHERE ($1 = 0 OR Number < $1) ORDER BY Number DESC LIMIT $2", beforeNumber, limit)
+	return entries, err
+}
+
+// BlockTimeSeries aggregates the blocks with fromTime <= Time < toTime (unix timestamps) into buckets of a minute, hour or
+// day (see TimeSeriesBuckets). Buckets without blocks are not returned.
+func (s *StatsService) BlockTimeSeries(bucket string, fromTime uint64, toTime uint64) (entries []BlockTimeSeriesEntry, err error) {
+	if _, found := TimeSeriesBuckets[bucket]; !found {
+		return nil, ErrInvalidBucket
+	}
+
+	entries = make([]BlockTimeSeriesEntry, 0)
+	err = s.DB.Select(&entries, `SELECT
+			extract(epoch FROM date_trunc($1, to_timestamp(Time)))::bigint AS BucketStart,
+			COUNT(*) AS NumBlocks, SUM(NumTx) AS NumTx, SUM(NumTxFailed) AS NumTxFailed, SUM(NumFlashbotsTx) AS NumFlashbotsTx,
+			SUM(GasUsed)::text AS GasUsed, SUM(GasFeeTotal)::text AS GasFeeTotal, SUM(GasFeeBurned)::text AS GasFeeBurned,
+			SUM(GasFeeTips)::text AS GasFeeTips, ROUND(AVG(BaseFee))::text AS AvgBaseFee, MAX(MaxTxValue)::text AS MaxTxValue
+		FROM block WHERE Time >= $2 AND Time < $3
+		GROUP BY 1 ORDER BY 1`, bucket, fromTime, toTime)
+	return entries, err
 }
 
 /*
  * WRITE OPERATIONS
  */
-func (s *StatsService) AddBlock(block *types.Block) {
-	// Check count
-	var count int
-	err := s.DB.QueryRow("SELECT COUNT(*) FROM block WHERE number = $1", block.Header().Number.Int64()).Scan(&count)
+// AddBlock inserts the stats of a block, or replaces them if the block was already saved
+func AddBlock(tx *sqlx.Tx, stats core.BlockStats) error {
+	_, err := tx.NamedExec(`INSERT INTO block (
+			Number, Hash, ParentHash, Time, Miner, BaseFee, GasUsed, GasLimit,
+			NumTx, NumTxLegacy, NumTxAccessList, NumTxDynamicFee, NumTxFailed, NumFlashbotsTx,
+			GasFeeTotal, GasFeeBurned, GasFeeTips, MaxTxValue
+		) VALUES (
+			:number, :hash, :parenthash, :time, :miner, :basefee, :gasused, :gaslimit,
+			:numtx, :numtxlegacy, :numtxaccesslist, :numtxdynamicfee, :numtxfailed, :numflashbotstx,
+			:gasfeetotal, :gasfeeburned, :gasfeetips, :maxtxvalue
+		) ON CONFLICT (Number) DO UPDATE SET
+			Hash=EXCLUDED.Hash, ParentHash=EXCLUDED.ParentHash, Time=EXCLUDED.Time, Miner=EXCLUDED.Miner, BaseFee=EXCLUDED.BaseFee, GasUsed=EXCLUDED.GasUsed, GasLimit=EXCLUDED.GasLimit,
+			NumTx=EXCLUDED.NumTx, NumTxLegacy=EXCLUDED.NumTxLegacy, NumTxAccessList=EXCLUDED.NumTxAccessList,
+			NumTxDynamicFee=EXCLUDED.NumTxDynamicFee, NumTxFailed=EXCLUDED.NumTxFailed, NumFlashbotsTx=EXCLUDED.NumFlashbotsTx,
+			GasFeeTotal=EXCLUDED.GasFeeTotal, GasFeeBurned=EXCLUDED.GasFeeBurned, GasFeeTips=EXCLUDED.GasFeeTips, MaxTxValue=EXCLUDED.MaxTxValue`,
+		NewBlockEntry(stats))
+	return err
+}
+
+// DeleteBlocksFrom removes the blocks with number >= fromNumber, to roll back the blocks of a fork that was replaced in
+// a chain reorg. Blocks of the new fork replace the old ones when added, but the new fork can be shorter.
+func (s *StatsService) DeleteBlocksFrom(fromNumber int64) error {
+	_, err := s.DB.Exec("DELETE FROM block WHERE Number >= $1", fromNumber)
+	return err
+}
+
+const addAddressQuery = `INSERT INTO address (Address, Name, Type, Symbol, Decimals) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (Address) DO UPDATE SET Name=EXCLUDED.Name, Type=EXCLUDED.Type, Symbol=EXCLUDED.Symbol, Decimals=EXCLUDED.Decimals, UpdatedAt=now()
+	WHERE EXCLUDED.Type <> ''`
+
+// AddAddress inserts the address, or updates name/type/symbol/decimals if it already exists and the new detail is loaded.
+// Addresses without type are inserted as well (the address stats reference them), but GetAddressDetail doesn't find them.
+func AddAddress(tx *sqlx.Tx, detail addressdetail.AddressDetail) error {
+	_, err := tx.Exec(addAddressQuery, strings.ToLower(detail.Address), detail.Name, detail.Type, detail.Symbol, detail.Decimals)
+	return err
+}
+
+// SaveAddressDetail adds or updates an address outside of an analysis, to use the address table as
+// addressdata.AddressStore
+func (s *StatsService) SaveAddressDetail(detail addressdetail.AddressDetail) error {
+	if detail.IsInitial() {
+		return nil // not looked up, see GetAddressDetail
+	}
+	_, err := s.DB.Exec(addAddressQuery, strings.ToLower(detail.Address), detail.Name, detail.Type, detail.Symbol, detail.Decimals)
+	return err
+}
+
+// AddAddressStats inserts the stats of one address for an analysis. An existing entry for the address is kept, so the
+// entries of a previous run must be deleted first (see AddAnalysisResultToDatabase).
+func AddAddressStats(tx *sqlx.Tx, analysisId int, addr core.AddressStats) error {
+	entry := NewAnalysisAddressStatsEntry(analysisId, addr)
+	_, err := tx.NamedExec(`INSERT INTO analysis_address_stat (
+			Analysis_id, Address,
+			NumTxSentSuccess, NumTxSentFailed, NumTxReceivedSuccess, NumTxReceivedFailed,
+			NumTxFlashbotsSent, NumTxFlashbotsReceived, NumTxWithDataSent, NumTxWithDataReceived,
+			NumTxErc20Sent, NumTxErc721Sent, NumTxErc20Received, NumTxErc721Received, NumTxErc20Transfer, NumTxErc721Transfer,
+			ValueSentEth, ValueReceivedEth,
+			Erc20TokensTransferred, TokensTransferredInUnit, TokensTransferredSymbol,
+			GasUsed, GasFeeTotal, GasFeeFailedTx, GasFeeBurned, GasFeeTips, GasFeeMaxHeadroom
+		) VALUES (
+			:analysis_id, :address,
+			:numtxsentsuccess, :numtxsentfailed, :numtxreceivedsuccess, :numtxreceivedfailed,
+			:numtxflashbotssent, :numtxflashbotsreceived, :numtxwithdatasent, :numtxwithdatareceived,
+			:numtxerc20sent, :numtxerc721sent, :numtxerc20received, :numtxerc721received, :numtxerc20transfer, :numtxerc721transfer,
+			:valuesenteth, :valuereceivedeth,
+			:erc20tokenstransferred, :tokenstransferredinunit, :tokenstransferredsymbol,
+			:gasused, :gasfeetotal, :gasfeefailedtx, :gasfeeburned, :gasfeetips, :gasfeemaxheadroom
+		) ON CONFLICT (Analysis_id, Address) DO NOTHING`, entry)
+	return err
+}
+
+// addAnalysisEntry inserts the analysis totals. An existing analysis for the same block range, or with the same window
+// name, is updated, and its id is returned.
+func addAnalysisEntry(tx *sqlx.Tx, entry AnalysisEntry) (analysisId int, err error) {
+	conflictTarget := "(StartBlockNumber, EndBlockNumber) WHERE WindowName = ''"
+	if entry.WindowName != "" {
+		conflictTarget = "(WindowName) WHERE WindowName <> ''"
+	}
+
+	rows, err := tx.NamedQuery(`INSERT INTO analysis (
+			WindowName, Date, Hour, Minute, Sec, DurationSec,
+			StartBlockNumber, StartBlockTimestamp, EndBlockNumber, EndBlockTimestamp,
+			NumBlocks, NumBlocksWithoutTx,
+			GasUsed, GasFeeTotal, GasFeeFailedTx, GasFeeBurned, GasFeeTips, GasFeeMaxHeadroom,
+			NumTransactions, NumTransactionsFailed, NumTransactionsWithZeroValue, NumTransactionsWithData,
+			NumTransactionsErc20Transfer, NumTransactionsErc721Transfer,
+			NumFlashbotsTransactionsSuccess, NumFlashbotsTransactionsFailed,
+			ValueTotalEth, TotalAddresses
+		) VALUES (
+			:windowname, :date, :hour, :minute, :sec, :durationsec,
+			:startblocknumber, :startblocktimestamp, :endblocknumber, :endblocktimestamp,
+			:numblocks, :numblockswithouttx,
+			:gasused, :gasfeetotal, :gasfeefailedtx, :gasfeeburned, :gasfeetips, :gasfeemaxheadroom,
+			:numtransactions, :numtransactionsfailed, :numtransactionswithzerovalue, :numtransactionswithdata,
+			:numtransactionserc20transfer, :numtransactionserc721transfer,
+			:numflashbotstransactionssuccess, :numflashbotstransactionsfailed,
+			:valuetotaleth, :totaladdresses
+		) ON CONFLICT `+conflictTarget+` DO UPDATE SET
+			StartBlockNumber=EXCLUDED.StartBlockNumber, EndBlockNumber=EXCLUDED.EndBlockNumber,
+			Date=EXCLUDED.Date, Hour=EXCLUDED.Hour, Minute=EXCLUDED.Minute, Sec=EXCLUDED.Sec, DurationSec=EXCLUDED.DurationSec,
+			StartBlockTimestamp=EXCLUDED.StartBlockTimestamp, EndBlockTimestamp=EXCLUDED.EndBlockTimestamp,
+			NumBlocks=EXCLUDED.NumBlocks, NumBlocksWithoutTx=EXCLUDED.NumBlocksWithoutTx,
+			GasUsed=EXCLUDED.GasUsed, GasFeeTotal=EXCLUDED.GasFeeTotal, GasFeeFailedTx=EXCLUDED.GasFeeFailedTx,
+			GasFeeBurned=EXCLUDED.GasFeeBurned, GasFeeTips=EXCLUDED.GasFeeTips, GasFeeMaxHeadroom=EXCLUDED.GasFeeMaxHeadroom,
+			NumTransactions=EXCLUDED.NumTransactions, NumTransactionsFailed=EXCLUDED.NumTransactionsFailed,
+			NumTransactionsWithZeroValue=EXCLUDED.NumTransactionsWithZeroValue, NumTransactionsWithData=EXCLUDED.NumTransactionsWithData,
+			NumTransactionsErc20Transfer=EXCLUDED.NumTransactionsErc20Transfer, NumTransactionsErc721Transfer=EXCLUDED.NumTransactionsErc721Transfer,
+			NumFlashbotsTransactionsSuccess=EXCLUDED.NumFlashbotsTransactionsSuccess, NumFlashbotsTransactionsFailed=EXCLUDED.NumFlashbotsTransactionsFailed,
+			ValueTotalEth=EXCLUDED.ValueTotalEth, TotalAddresses=EXCLUDED.TotalAddresses
+		RETURNING Id`, entry)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	if count > 0 { // already exists
-		return
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, sql.ErrNoRows
 	}
+	err = rows.Scan(&analysisId)
+	return analysisId, err
+}
 
-	// Insert
-	s.DB.MustExec("INSERT INTO block (Number, Time, NumTx, GasUsed, GasLimit) VALUES ($1, $2, $3, $4, $5)", block.Number().String(), block.Header().Time, len(block.Transactions()), block.GasUsed(), block.GasLimit())
+// AddAnalysisResultToDatabase saves the analysis totals, the block stats, all top addresses and their stats in a single DB transaction.
+// Running it again for the same block range replaces the previous results instead of adding a second analysis.
+func (s *StatsService) AddAnalysisResultToDatabase(analysis *core.Analysis) (analysisId int, err error) {
+	return s.addAnalysisResult(NewAnalysisEntry(analysis), analysis)
 }
 
-func (s *StatsService) AddAddressStats(block *types.Block, analysisId int, addr core.AddressStats) {
+// AddWindowSnapshotToDatabase saves a snapshot of a followed window like AddAnalysisResultToDatabase. There is one
+// analysis per window name, which every snapshot of the window replaces.
+func (s *StatsService) AddWindowSnapshotToDatabase(windowName string, analysis *core.Analysis) (analysisId int, err error) {
+	entry := NewAnalysisEntry(analysis)
+	entry.WindowName = windowName
+	return s.addAnalysisResult(entry, analysis)
 }
 
-func (s *StatsService) AddAnalysisResultToDatabase(analysis *core.Analysis) {
-	// todo
+func (s *StatsService) addAnalysisResult(entry AnalysisEntry, analysis *core.Analysis) (analysisId int, err error) {
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // no-op after commit
+
+	analysisId, err = addAnalysisEntry(tx, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	// Remove address stats of a previous run for this block range or window
+	_, err = tx.Exec("DELETE FROM analysis_address_stat WHERE Analysis_id=$1", analysisId)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, blockStats := range analysis.Blocks {
+		if err = AddBlock(tx, blockStats); err != nil {
+			return 0, err
+		}
+	}
+
+	// Addresses can be in several top lists, but are only saved once
+	savedAddresses := make(map[string]bool)
+	for _, addrStats := range analysis.Data.GetAllTopAddressStats() {
+		addr := strings.ToLower(addrStats.AddressDetail.Address)
+		if addr == "" || savedAddresses[addr] {
+			continue
+		}
+		savedAddresses[addr] = true
+
+		if err = AddAddress(tx, addrStats.AddressDetail); err != nil {
+			return 0, err
+		}
+		if err = AddAddressStats(tx, analysisId, addrStats); err != nil {
+			return 0, err
+		}
+	}
+
+	return analysisId, tx.Commit()
 }
@@ -1,8 +1,17 @@
 package database
 
 import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
 	_ "github.com/lib/pq"
+	"github.com/metachris/ethereum-go-experiments/consts"
+	"github.com/metachris/ethereum-go-experiments/core"
 	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/utils"
 )
 
 var Schema = `
@@ -95,11 +104,136 @@ CREATE TABLE IF NOT EXISTS block (
 	GasLimit  int,
 
 	PRIMARY KEY (Number)
-)
+);
+
+-- EIP-1559 fee split. Added as separate statements to also migrate existing databases.
+ALTER TABLE analysis ADD COLUMN IF NOT EXISTS GasFeeBurned      NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE analysis ADD COLUMN IF NOT EXISTS GasFeeTips        NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE analysis ADD COLUMN IF NOT EXISTS GasFeeMaxHeadroom NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE analysis_address_stat ADD COLUMN IF NOT EXISTS GasFeeBurned      NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE analysis_address_stat ADD COLUMN IF NOT EXISTS GasFeeTips        NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE analysis_address_stat ADD COLUMN IF NOT EXISTS GasFeeMaxHeadroom NUMERIC(48, 0) NOT NULL DEFAULT 0;
+
+-- Per-block statistics (added later, also migrates existing databases)
+ALTER TABLE block ADD COLUMN IF NOT EXISTS Miner           text NOT NULL DEFAULT '';
+ALTER TABLE block ADD COLUMN IF NOT EXISTS BaseFee         NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS NumTxLegacy     int NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS NumTxAccessList int NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS NumTxDynamicFee int NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS NumTxFailed     int NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS NumFlashbotsTx  int NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS GasFeeTotal     NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS GasFeeBurned    NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS GasFeeTips      NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS MaxTxValue      NUMERIC(48, 0) NOT NULL DEFAULT 0;
+ALTER TABLE block ADD COLUMN IF NOT EXISTS Hash            text NOT NULL DEFAULT '';
+ALTER TABLE block ADD COLUMN IF NOT EXISTS ParentHash      text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS block_time_idx ON block (Time);
+
+-- When an address detail was last detected, to look up wallets again (see addressdata.AddressStore)
+ALTER TABLE address ADD COLUMN IF NOT EXISTS UpdatedAt timestamptz NOT NULL DEFAULT now();
+
+-- The analysis of a followed window (e.g. "1h") has the window name, and is replaced by every snapshot of the window
+ALTER TABLE analysis ADD COLUMN IF NOT EXISTS WindowName text NOT NULL DEFAULT '';
+
+-- An analysis is identified by its block range, or its window name, and every address only has one stat entry per
+-- analysis. Databases created before the unique indexes can contain repeated entries, which are removed first (keeping
+-- the lowest id).
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname IN ('analysis_blockrange_idx', 'analysis_range_idx')) THEN
+		DELETE FROM analysis_address_stat WHERE Analysis_id IN (
+			SELECT a.Id FROM analysis a JOIN analysis b
+			ON a.StartBlockNumber = b.StartBlockNumber AND a.EndBlockNumber = b.EndBlockNumber AND a.Id > b.Id);
+		DELETE FROM analysis a USING analysis b
+			WHERE a.StartBlockNumber = b.StartBlockNumber AND a.EndBlockNumber = b.EndBlockNumber AND a.Id > b.Id;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'analysis_address_stat_analysis_address_idx') THEN
+		DELETE FROM analysis_address_stat a USING analysis_address_stat b
+			WHERE a.Analysis_id = b.Analysis_id AND a.Address = b.Address AND a.Id > b.Id;
+	END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS analysis_range_idx ON analysis (StartBlockNumber, EndBlockNumber) WHERE WindowName = '';
+DROP INDEX IF EXISTS analysis_blockrange_idx; -- a window can have the block range of another analysis
+CREATE UNIQUE INDEX IF NOT EXISTS analysis_window_idx ON analysis (WindowName) WHERE WindowName <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS analysis_address_stat_analysis_address_idx ON analysis_address_stat (Analysis_id, Address);
 `
 
+type BlockEntry struct {
+	Number     int64
+	Hash       string
+	ParentHash string
+	Time       uint64
+	Miner      string
+	BaseFee    string
+	GasUsed    uint64
+	GasLimit   uint64
+
+	NumTx           int
+	NumTxLegacy     int
+	NumTxAccessList int
+	NumTxDynamicFee int
+	NumTxFailed     int
+	NumFlashbotsTx  int
+
+	GasFeeTotal  string
+	GasFeeBurned string
+	GasFeeTips   string
+	MaxTxValue   string
+}
+
+func NewBlockEntry(stats core.BlockStats) BlockEntry {
+	return BlockEntry{
+		Number:     stats.Number,
+		Hash:       stats.Hash,
+		ParentHash: stats.ParentHash,
+		Time:       stats.Time,
+		Miner:      stats.Miner,
+		BaseFee:    stats.BaseFee.String(),
+		GasUsed:    stats.GasUsed,
+		GasLimit:   stats.GasLimit,
+
+		NumTx:           stats.NumTx,
+		NumTxLegacy:     stats.NumTxByType[types.LegacyTxType],
+		NumTxAccessList: stats.NumTxByType[types.AccessListTxType],
+		NumTxDynamicFee: stats.NumTxByType[types.DynamicFeeTxType],
+		NumTxFailed:     stats.NumTxFailed,
+		NumFlashbotsTx:  stats.NumFlashbotsTx,
+
+		GasFeeTotal:  stats.GasFeeTotal.String(),
+		GasFeeBurned: stats.GasFeeBurned.String(),
+		GasFeeTips:   stats.GasFeeTips.String(),
+		MaxTxValue:   stats.MaxTxValue.String(),
+	}
+}
+
+// TimeSeriesBuckets are the supported bucket sizes of the block time series (Postgres date_trunc precision)
+var TimeSeriesBuckets = map[string]uint64{
+	"minute": 60,
+	"hour":   60 * 60,
+	"day":    24 * 60 * 60,
+}
+
+// BlockTimeSeriesEntry aggregates the blocks of one time bucket. BucketStart is a unix timestamp (UTC).
+type BlockTimeSeriesEntry struct {
+	BucketStart int64
+
+	NumBlocks      int
+	NumTx          int
+	NumTxFailed    int
+	NumFlashbotsTx int
+
+	GasUsed      string
+	GasFeeTotal  string
+	GasFeeBurned string
+	GasFeeTips   string
+	AvgBaseFee   string
+	MaxTxValue   string
+}
+
 type AnalysisEntry struct {
 	Id          int
+	WindowName  string // empty for the analysis of a block range
 	Date        string
 	Hour        int
 	Minute      int
@@ -114,9 +248,12 @@ type AnalysisEntry struct {
 	NumBlocks          int
 	NumBlocksWithoutTx int
 
-	GasUsed        string
-	GasFeeTotal    string
-	GasFeeFailedTx string
+	GasUsed           string
+	GasFeeTotal       string
+	GasFeeFailedTx    string
+	GasFeeBurned      string
+	GasFeeTips        string
+	GasFeeMaxHeadroom string
 
 	NumTransactions              int
 	NumTransactionsFailed        int
@@ -133,25 +270,186 @@ type AnalysisEntry struct {
 	TotalAddresses int
 
 	// Calculated after fetching from DB:
-	GasFeeTotalEth    string
-	GasFeeFailedTxEth string
+	GasFeeTotalEth       string
+	GasFeeFailedTxEth    string
+	GasFeeBurnedEth      string
+	GasFeeTipsEth        string
+	GasFeeMaxHeadroomEth string
+	AvgGasPriceGwei      string
+}
+
+// NewAnalysisEntry converts the analysis totals into a database row. Date and time are taken from the first block.
+func NewAnalysisEntry(analysis *core.Analysis) AnalysisEntry {
+	data := analysis.Data
+	startTime := time.Unix(int64(data.StartBlockTimestamp), 0).UTC()
+
+	return AnalysisEntry{
+		Date:        startTime.Format("2006-01-02"),
+		Hour:        startTime.Hour(),
+		Minute:      startTime.Minute(),
+		Sec:         startTime.Second(),
+		DurationSec: int(data.EndBlockTimestamp - data.StartBlockTimestamp),
+
+		StartBlockNumber:    int(data.StartBlockNumber),
+		StartBlockTimestamp: int(data.StartBlockTimestamp),
+		EndBlockNumber:      int(data.EndBlockNumber),
+		EndBlockTimestamp:   int(data.EndBlockTimestamp),
+
+		NumBlocks:          data.NumBlocks,
+		NumBlocksWithoutTx: data.NumBlocksWithoutTx,
+
+		GasUsed:           data.GasUsed.String(),
+		GasFeeTotal:       data.GasFeeTotal.String(),
+		GasFeeFailedTx:    data.GasFeeFailedTx.String(),
+		GasFeeBurned:      data.GasFeeBurned.String(),
+		GasFeeTips:        data.GasFeeTips.String(),
+		GasFeeMaxHeadroom: data.GasFeeMaxHeadroom.String(),
+
+		NumTransactions:              data.NumTransactions,
+		NumTransactionsFailed:        data.NumTransactionsFailed,
+		NumTransactionsWithZeroValue: data.NumTransactionsWithZeroValue,
+		NumTransactionsWithData:      data.NumTransactionsWithData,
+
+		NumTransactionsErc20Transfer:  data.NumTransactionsErc20Transfer,
+		NumTransactionsErc721Transfer: data.NumTransactionsErc721Transfer,
+
+		NumFlashbotsTransactionsSuccess: data.NumFlashbotsTransactionsSuccess,
+		NumFlashbotsTransactionsFailed:  data.NumFlashbotsTransactionsFailed,
+
+		ValueTotalEth:  weiToEthDecimalString(data.ValueTotalWei),
+		TotalAddresses: len(analysis.Addresses),
+	}
+}
+
+// NewAnalysisFromEntry restores an analysis from the database, e.g. to merge it with other analyses. The database only
+// has the totals and the stats of the top addresses, with ETH values rounded to 8 decimals. The merged top addresses
+// therefore only consider addresses that were in a top list of one of the analyses, and there are no token, miner and
+// transaction stats.
+func NewAnalysisFromEntry(cfg core.Config, entry AnalysisEntry, addressStats []AnalysisAddressStatsEntryWithAddress, ads core.IAddressDetailService) (*core.Analysis, error) {
+	analysis := core.NewAnalysis(cfg, nil, ads) // no tx top lists in the database
+	data := &analysis.Data
+
+	data.StartBlockNumber = int64(entry.StartBlockNumber)
+	data.StartBlockTimestamp = uint64(entry.StartBlockTimestamp)
+	data.EndBlockNumber = int64(entry.EndBlockNumber)
+	data.EndBlockTimestamp = uint64(entry.EndBlockTimestamp)
+
+	data.NumBlocks = entry.NumBlocks
+	data.NumBlocksWithoutTx = entry.NumBlocksWithoutTx
+
+	data.NumTransactions = entry.NumTransactions
+	data.NumTransactionsFailed = entry.NumTransactionsFailed
+	data.NumTransactionsWithZeroValue = entry.NumTransactionsWithZeroValue
+	data.NumTransactionsWithData = entry.NumTransactionsWithData
+	data.NumTransactionsErc20Transfer = entry.NumTransactionsErc20Transfer
+	data.NumTransactionsErc721Transfer = entry.NumTransactionsErc721Transfer
+	data.NumFlashbotsTransactionsSuccess = entry.NumFlashbotsTransactionsSuccess
+	data.NumFlashbotsTransactionsFailed = entry.NumFlashbotsTransactionsFailed
+
+	var err error
+	for _, v := range []struct {
+		dst **big.Int
+		src string
+	}{
+		{&data.GasUsed, entry.GasUsed},
+		{&data.GasFeeTotal, entry.GasFeeTotal},
+		{&data.GasFeeFailedTx, entry.GasFeeFailedTx},
+		{&data.GasFeeBurned, entry.GasFeeBurned},
+		{&data.GasFeeTips, entry.GasFeeTips},
+		{&data.GasFeeMaxHeadroom, entry.GasFeeMaxHeadroom},
+	} {
+		if *v.dst, err = decimalStringToBigInt(v.src); err != nil {
+			return nil, err
+		}
+	}
+	if data.ValueTotalWei, err = ethDecimalStringToWei(entry.ValueTotalEth); err != nil {
+		return nil, err
+	}
+
+	for _, addrEntry := range addressStats {
+		stats, err := addrEntry.AddressStats()
+		if err != nil {
+			return nil, err
+		}
+		analysis.Addresses[addrEntry.Address] = stats
+	}
+
+	return analysis, nil
 }
 
-// func (entry *AnalysisEntry) CalcNumbers() {
-// 	gasFeeTotal := new(big.Int)
-// 	gasFeeTotal.SetString(entry.GasFeeTotal, 10)
-// 	entry.GasFeeTotalEth = WeiBigIntToEthString(gasFeeTotal, 2)
+// CalcNumbers fills the human-readable values (*Eth, AvgGasPriceGwei, ValueTotalEth) after fetching from DB
+func (entry *AnalysisEntry) CalcNumbers() {
+	gasFeeTotal := new(big.Int)
+	gasFeeTotal.SetString(entry.GasFeeTotal, 10)
+	entry.GasFeeTotalEth = utils.WeiBigIntToEthString(gasFeeTotal, 2)
+
+	gasFeeFailed := new(big.Int)
+	gasFeeFailed.SetString(entry.GasFeeFailedTx, 10)
+	entry.GasFeeFailedTxEth = utils.WeiBigIntToEthString(gasFeeFailed, 2)
+
+	gasFeeBurned := new(big.Int)
+	gasFeeBurned.SetString(entry.GasFeeBurned, 10)
+	entry.GasFeeBurnedEth = utils.WeiBigIntToEthString(gasFeeBurned, 2)
+
+	gasFeeTips := new(big.Int)
+	gasFeeTips.SetString(entry.GasFeeTips, 10)
+	entry.GasFeeTipsEth = utils.WeiBigIntToEthString(gasFeeTips, 2)
+
+	gasFeeMaxHeadroom := new(big.Int)
+	gasFeeMaxHeadroom.SetString(entry.GasFeeMaxHeadroom, 10)
+	entry.GasFeeMaxHeadroomEth = utils.WeiBigIntToEthString(gasFeeMaxHeadroom, 2)
+
+	gasUsed := new(big.Int)
+	gasUsed.SetString(entry.GasUsed, 10)
+	avgGasPrice := new(big.Int)
+	if gasUsed.Sign() > 0 {
+		avgGasPrice.Div(gasFeeTotal, gasUsed)
+	}
+	entry.AvgGasPriceGwei = core.WeiToGweiString(avgGasPrice)
+
+	val := new(big.Float)
+	val.SetString(entry.ValueTotalEth)
+	entry.ValueTotalEth = utils.BigFloatToHumanNumberString(val, 2)
+}
 
-// 	gasFeeFailed := new(big.Int)
-// 	gasFeeFailed.SetString(entry.GasFeeFailedTx, 10)
-// 	entry.GasFeeFailedTxEth = WeiBigIntToEthString(gasFeeFailed, 2)
+// AddressStatsSortColumns maps the stats keys (consts.AddressStatsKeys) to the analysis_address_stat columns they are stored in
+var AddressStatsSortColumns = map[string]string{
+	consts.NumTxReceived:        "NumTxReceivedSuccess + NumTxReceivedFailed",
+	consts.NumTxReceivedSuccess: "NumTxReceivedSuccess",
+	consts.NumTxReceivedFailed:  "NumTxReceivedFailed",
 
-// 	val := new(big.Float)
-// 	val.SetString(entry.ValueTotalEth)
-// 	entry.ValueTotalEth = BigFloatToHumanNumberString(val, 2)
-// }
+	consts.NumTxSent:        "NumTxSentSuccess + NumTxSentFailed",
+	consts.NumTxSentSuccess: "NumTxSentSuccess",
+	consts.NumTxSentFailed:  "NumTxSentFailed",
 
-type AnalysisAddressStatsEntryWithAddress struct {
+	consts.NumTxFlashbotsSent:     "NumTxFlashbotsSent",
+	consts.NumTxFlashbotsReceived: "NumTxFlashbotsReceived",
+
+	consts.NumTxWithDataSent:     "NumTxWithDataSent",
+	consts.NumTxWithDataReceived: "NumTxWithDataReceived",
+
+	consts.NumTxErc20Sent:      "NumTxErc20Sent",
+	consts.NumTxErc20Received:  "NumTxErc20Received",
+	consts.NumTxErc20Transfer:  "NumTxErc20Transfer",
+	consts.NumTxErc721Sent:     "NumTxErc721Sent",
+	consts.NumTxErc721Received: "NumTxErc721Received",
+	consts.NumTxErc721Transfer: "NumTxErc721Transfer",
+
+	consts.ValueSentWei:     "ValueSentEth",
+	consts.ValueReceivedWei: "ValueReceivedEth",
+
+	consts.Erc20TokensTransferred: "Erc20TokensTransferred",
+
+	consts.GasUsed:        "GasUsed",
+	consts.GasFeeTotal:    "GasFeeTotal",
+	consts.GasFeeFailedTx: "GasFeeFailedTx",
+
+	consts.GasFeeBurned:      "GasFeeBurned",
+	consts.GasFeeTips:        "GasFeeTips",
+	consts.GasFeeMaxHeadroom: "GasFeeMaxHeadroom",
+}
+
+type AnalysisAddressStatsEntry struct {
 	Id int
 
 	Analysis_id int
@@ -181,9 +479,16 @@ type AnalysisAddressStatsEntryWithAddress struct {
 	TokensTransferredInUnit string
 	TokensTransferredSymbol string
 
-	GasUsed        string
-	GasFeeTotal    string
-	GasFeeFailedTx string
+	GasUsed           string
+	GasFeeTotal       string
+	GasFeeFailedTx    string
+	GasFeeBurned      string
+	GasFeeTips        string
+	GasFeeMaxHeadroom string
+}
+
+type AnalysisAddressStatsEntryWithAddress struct {
+	AnalysisAddressStatsEntry
 
 	// Fields from joined address
 	Type     addressdetail.AddressType
@@ -191,3 +496,121 @@ type AnalysisAddressStatsEntryWithAddress struct {
 	Symbol   string
 	Decimals uint8
 }
+
+func NewAnalysisAddressStatsEntry(analysisId int, stats core.AddressStats) AnalysisAddressStatsEntry {
+	tokensTransferredInUnit, tokenSymbol := utils.GetErc20TokensInUnit(stats.Get(consts.Erc20TokensTransferred), stats.AddressDetail)
+
+	return AnalysisAddressStatsEntry{
+		Analysis_id: analysisId,
+		Address:     strings.ToLower(stats.AddressDetail.Address),
+
+		NumTxSentSuccess:     int(stats.Get(consts.NumTxSentSuccess).Int64()),
+		NumTxSentFailed:      int(stats.Get(consts.NumTxSentFailed).Int64()),
+		NumTxReceivedSuccess: int(stats.Get(consts.NumTxReceivedSuccess).Int64()),
+		NumTxReceivedFailed:  int(stats.Get(consts.NumTxReceivedFailed).Int64()),
+
+		NumTxFlashbotsSent:     int(stats.Get(consts.NumTxFlashbotsSent).Int64()),
+		NumTxFlashbotsReceived: int(stats.Get(consts.NumTxFlashbotsReceived).Int64()),
+		NumTxWithDataSent:      int(stats.Get(consts.NumTxWithDataSent).Int64()),
+		NumTxWithDataReceived:  int(stats.Get(consts.NumTxWithDataReceived).Int64()),
+
+		NumTxErc20Sent:      int(stats.Get(consts.NumTxErc20Sent).Int64()),
+		NumTxErc721Sent:     int(stats.Get(consts.NumTxErc721Sent).Int64()),
+		NumTxErc20Received:  int(stats.Get(consts.NumTxErc20Received).Int64()),
+		NumTxErc721Received: int(stats.Get(consts.NumTxErc721Received).Int64()),
+		NumTxErc20Transfer:  int(stats.Get(consts.NumTxErc20Transfer).Int64()),
+		NumTxErc721Transfer: int(stats.Get(consts.NumTxErc721Transfer).Int64()),
+
+		ValueSentEth:     weiToEthDecimalString(stats.Get(consts.ValueSentWei)),
+		ValueReceivedEth: weiToEthDecimalString(stats.Get(consts.ValueReceivedWei)),
+
+		Erc20TokensTransferred:  stats.Get(consts.Erc20TokensTransferred).String(),
+		TokensTransferredInUnit: tokensTransferredInUnit.Text('f', 8),
+		TokensTransferredSymbol: tokenSymbol,
+
+		GasUsed:           stats.Get(consts.GasUsed).String(),
+		GasFeeTotal:       stats.Get(consts.GasFeeTotal).String(),
+		GasFeeFailedTx:    stats.Get(consts.GasFeeFailedTx).String(),
+		GasFeeBurned:      stats.Get(consts.GasFeeBurned).String(),
+		GasFeeTips:        stats.Get(consts.GasFeeTips).String(),
+		GasFeeMaxHeadroom: stats.Get(consts.GasFeeMaxHeadroom).String(),
+	}
+}
+
+// AddressStats converts the entry back to the stats of the address (see NewAnalysisFromEntry)
+func (entry AnalysisAddressStatsEntryWithAddress) AddressStats() (*core.AddressStats, error) {
+	stats := core.NewAddressStats(entry.Address)
+	stats.AddressDetail = addressdetail.AddressDetail{Address: entry.Address, Type: entry.Type, Name: entry.Name, Symbol: entry.Symbol, Decimals: entry.Decimals}
+
+	// Like in core.AddressStats, zero values are left out
+	setInt := func(key string, val int) {
+		if val != 0 {
+			stats.Stats[key] = big.NewInt(int64(val))
+		}
+	}
+	setInt(consts.NumTxSent, entry.NumTxSentSuccess+entry.NumTxSentFailed)
+	setInt(consts.NumTxSentSuccess, entry.NumTxSentSuccess)
+	setInt(consts.NumTxSentFailed, entry.NumTxSentFailed)
+	setInt(consts.NumTxReceived, entry.NumTxReceivedSuccess+entry.NumTxReceivedFailed)
+	setInt(consts.NumTxReceivedSuccess, entry.NumTxReceivedSuccess)
+	setInt(consts.NumTxReceivedFailed, entry.NumTxReceivedFailed)
+	setInt(consts.NumTxFlashbotsSent, entry.NumTxFlashbotsSent)
+	setInt(consts.NumTxFlashbotsReceived, entry.NumTxFlashbotsReceived)
+	setInt(consts.NumTxWithDataSent, entry.NumTxWithDataSent)
+	setInt(consts.NumTxWithDataReceived, entry.NumTxWithDataReceived)
+	setInt(consts.NumTxErc20Sent, entry.NumTxErc20Sent)
+	setInt(consts.NumTxErc721Sent, entry.NumTxErc721Sent)
+	setInt(consts.NumTxErc20Received, entry.NumTxErc20Received)
+	setInt(consts.NumTxErc721Received, entry.NumTxErc721Received)
+	setInt(consts.NumTxErc20Transfer, entry.NumTxErc20Transfer)
+	setInt(consts.NumTxErc721Transfer, entry.NumTxErc721Transfer)
+
+	setBigInt := func(key string, val string, parse func(string) (*big.Int, error)) error {
+		i, err := parse(val)
+		if err == nil && i.Sign() != 0 {
+			stats.Stats[key] = i
+		}
+		return err
+	}
+	for _, err := range []error{
+		setBigInt(consts.ValueSentWei, entry.ValueSentEth, ethDecimalStringToWei),
+		setBigInt(consts.ValueReceivedWei, entry.ValueReceivedEth, ethDecimalStringToWei),
+		setBigInt(consts.Erc20TokensTransferred, entry.Erc20TokensTransferred, decimalStringToBigInt),
+		setBigInt(consts.GasUsed, entry.GasUsed, decimalStringToBigInt),
+		setBigInt(consts.GasFeeTotal, entry.GasFeeTotal, decimalStringToBigInt),
+		setBigInt(consts.GasFeeFailedTx, entry.GasFeeFailedTx, decimalStringToBigInt),
+		setBigInt(consts.GasFeeBurned, entry.GasFeeBurned, decimalStringToBigInt),
+		setBigInt(consts.GasFeeTips, entry.GasFeeTips, decimalStringToBigInt),
+		setBigInt(consts.GasFeeMaxHeadroom, entry.GasFeeMaxHeadroom, decimalStringToBigInt),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// decimalStringToBigInt parses a NUMERIC(x, 0) column
+func decimalStringToBigInt(s string) (*big.Int, error) {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return i, nil
+}
+
+// ethDecimalStringToWei parses a NUMERIC(x, 8) column with an ETH value (see weiToEthDecimalString)
+func ethDecimalStringToWei(s string) (*big.Int, error) {
+	eth, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	wei := new(big.Rat).Mul(eth, new(big.Rat).SetInt(big.NewInt(1e18)))
+	return new(big.Int).Quo(wei.Num(), wei.Denom()), nil
+}
+
+// weiToEthDecimalString returns the ETH value as plain decimal string for NUMERIC(x, 8) columns (no thousands separator)
+func weiToEthDecimalString(wei *big.Int) string {
+	return utils.WeiToEth(wei).Text('f', 8)
+}
@@ -1,21 +1,34 @@
 package ethstats
 
 import (
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/metachris/ethereum-go-experiments/consts"
 	"github.com/metachris/ethereum-go-experiments/core"
 	"github.com/metachris/go-ethutils/blockswithtx"
 	"github.com/metachris/go-ethutils/utils"
 )
 
-func ProcessBlockWithReceipts(block *blockswithtx.BlockWithTxReceipts, client *ethclient.Client, analysis *core.Analysis) {
+// ErrReceiptOfOtherBlock is returned by ProcessBlockWithReceipts if a receipt is from another block with the same
+// transaction, e.g. if a reorg happened between fetching the block and its receipts
+var ErrReceiptOfOtherBlock = errors.New("receipt is from another block")
+
+// ProcessBlockWithReceipts adds a block and its transactions to the analysis. An invalid block is not added, and the
+// error returned. Transactions that can't be processed are skipped and recorded in analysis.Data.Errors.
+func ProcessBlockWithReceipts(block *blockswithtx.BlockWithTxReceipts, analysis *core.Analysis) error {
+	if block == nil || block.Block == nil {
+		return errors.New("no block")
+	}
+	for _, receipt := range block.TxReceipts {
+		if receipt.BlockHash != (common.Hash{}) && receipt.BlockHash != block.Block.Hash() {
+			return fmt.Errorf("%w: tx %s is in block %s, not %s", ErrReceiptOfOtherBlock, receipt.TxHash, receipt.BlockHash, block.Block.Hash())
+		}
+	}
+
 	if analysis.Data.StartBlockTimestamp == 0 {
 		analysis.Data.StartBlockTimestamp = block.Block.Time()
 	}
@@ -24,32 +37,65 @@ func ProcessBlockWithReceipts(block *blockswithtx.BlockWithTxReceipts, client *e
 	analysis.Data.EndBlockTimestamp = block.Block.Time()
 
 	analysis.Data.NumBlocks += 1
-	analysis.Data.NumTransactions += len(block.Block.Transactions())
 	analysis.Data.GasUsed = new(big.Int).Add(analysis.Data.GasUsed, big.NewInt(int64(block.Block.GasUsed())))
 
+	// The per-block fees and counts are the difference of the analysis totals before and after the transactions
+	blockStats := core.NewBlockStats(block.Block)
+	numTxFailedBefore := analysis.Data.NumTransactionsFailed
+	numFlashbotsTxBefore := analysis.Data.NumFlashbotsTransactionsSuccess + analysis.Data.NumFlashbotsTransactionsFailed
+	gasFeeTotalBefore := analysis.Data.GasFeeTotal
+	gasFeeBurnedBefore := analysis.Data.GasFeeBurned
+	gasFeeTipsBefore := analysis.Data.GasFeeTips
+
 	// Iterate over all transactions
+	baseFee := block.Block.BaseFee() // nil before London
 	for _, tx := range block.Block.Transactions() {
 		receipt := block.TxReceipts[tx.Hash()]
-		ProcessTransaction(client, tx, receipt, analysis)
+		if err := processTransactionRecover(tx, receipt, baseFee, analysis); err != nil {
+			analysis.Data.AddSkippedTx(block.Block.Number().Int64(), tx.Hash(), err)
+			continue
+		}
+		analysis.Data.NumTransactions += 1
 	}
 
+	blockStats.NumTxFailed = analysis.Data.NumTransactionsFailed - numTxFailedBefore
+	blockStats.NumFlashbotsTx = analysis.Data.NumFlashbotsTransactionsSuccess + analysis.Data.NumFlashbotsTransactionsFailed - numFlashbotsTxBefore
+	blockStats.GasFeeTotal = new(big.Int).Sub(analysis.Data.GasFeeTotal, gasFeeTotalBefore)
+	blockStats.GasFeeBurned = new(big.Int).Sub(analysis.Data.GasFeeBurned, gasFeeBurnedBefore)
+	blockStats.GasFeeTips = new(big.Int).Sub(analysis.Data.GasFeeTips, gasFeeTipsBefore)
+	analysis.Blocks = append(analysis.Blocks, blockStats)
+	analysis.GetOrCreateMinerStats(blockStats.Miner).AddBlock(blockStats)
+
 	// If no transactions in this block then record that
 	if len(block.Block.Transactions()) == 0 {
 		analysis.Data.NumBlocksWithoutTx += 1
 	}
+	return nil
 }
 
-func ProcessTransaction(client *ethclient.Client, tx *types.Transaction, receipt *types.Receipt, analysis *core.Analysis) {
-	analysis.AddTxToTopList(tx, receipt)
+// processTransactionRecover is ProcessTransaction, which returns a panic as error, so that a single malformed
+// transaction doesn't end a long analysis. This is a last resort: ProcessTransaction fails before it changes the
+// analysis, except for a panicking tx ranker or token lookup of the ERC20 ranking (see
+// core.NewErc20TransferValueTxRanker), which can leave the transaction in some of the top lists.
+func processTransactionRecover(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, analysis *core.Analysis) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return ProcessTransaction(tx, receipt, baseFee, analysis)
+}
 
-	txToAddrStats := analysis.GetOrCreateAddressStats(tx.To())
-	txFromAddrStats := analysis.GetOrCreateAddressStats(nil)
-	if from, err := utils.GetTxSender(tx); err == nil {
-		txFromAddrStats = analysis.GetOrCreateAddressStats(&from)
+// ProcessTransaction adds a transaction to the analysis. baseFee is the base fee of the block, or nil before London.
+// If the sender can't be recovered, the transaction is not added and the error returned.
+func ProcessTransaction(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, analysis *core.Analysis) error {
+	// Everything that can fail (the sender, and the address details of a tagged tx, which are loaded with the address
+	// detail service) is done before the analysis is changed, so that a skipped transaction leaves no partial stats
+	from, err := core.GetTxSender(tx)
+	if err != nil {
+		return fmt.Errorf("sender: %w", err)
 	}
-
-	txToAddrStats.Add1(consts.NumTxReceived)
-	txFromAddrStats.Add1(consts.NumTxSent)
+	txStats := analysis.NewTxStats(tx, receipt, baseFee)
 
 	// Gas used is paid and counted no matter if transaction failed or succeeded
 	txSuccess := true        // default, used if no receipt
@@ -58,11 +104,34 @@ func ProcessTransaction(client *ethclient.Client, tx *types.Transaction, receipt
 		txSuccess = receipt.Status == 1
 		txGasUsed = big.NewInt(int64(receipt.GasUsed))
 	}
+	fees := core.NewTxFees(tx, txGasUsed, baseFee)
+	txGasFee := fees.GasFee
+
+	// Flashbots bundles pay the miner directly, not with the gas price (before London) or priority fee (after London)
+	isZeroTipTx := fees.EffectiveGasTip.Sign() == 0
+	isFlashbotsFailedTx := !txSuccess && len(tx.Data()) > 0 && isZeroTipTx
+	if isFlashbotsFailedTx {
+		// The address details are loaded before the tagged tx is added
+		analysis.TagTransactionStats(txStats, consts.TxFlashBotsFailed)
+	}
+
+	analysis.AddTxStatsToTopList(txStats)
+
+	txToAddrStats := analysis.GetOrCreateAddressStats(tx.To())
+	txFromAddrStats := analysis.GetOrCreateAddressStats(&from)
+
+	txToAddrStats.Add1(consts.NumTxReceived)
+	txFromAddrStats.Add1(consts.NumTxSent)
 
-	txGasFee := new(big.Int).Mul(txGasUsed, tx.GasPrice())
 	analysis.Data.GasFeeTotal = new(big.Int).Add(analysis.Data.GasFeeTotal, txGasFee)
+	analysis.Data.GasFeeBurned = new(big.Int).Add(analysis.Data.GasFeeBurned, fees.Burned)
+	analysis.Data.GasFeeTips = new(big.Int).Add(analysis.Data.GasFeeTips, fees.Tip)
+	analysis.Data.GasFeeMaxHeadroom = new(big.Int).Add(analysis.Data.GasFeeMaxHeadroom, fees.MaxFeeHeadroom)
 	txFromAddrStats.Add(consts.GasUsed, txGasUsed)
 	txFromAddrStats.Add(consts.GasFeeTotal, txGasFee)
+	txFromAddrStats.Add(consts.GasFeeBurned, fees.Burned)
+	txFromAddrStats.Add(consts.GasFeeTips, fees.Tip)
+	txFromAddrStats.Add(consts.GasFeeMaxHeadroom, fees.MaxFeeHeadroom)
 
 	if !txSuccess {
 		analysis.Data.NumTransactionsFailed += 1
@@ -74,14 +143,12 @@ func ProcessTransaction(client *ethclient.Client, tx *types.Transaction, receipt
 		txToAddrStats.Add1(consts.NumTxReceivedFailed)
 
 		// Count failed flashbots tx
-		if len(tx.Data()) > 0 && tx.GasPrice().Uint64() == 0 {
+		if isFlashbotsFailedTx {
 			analysis.Data.NumFlashbotsTransactionsFailed += 1
-			// fmt.Printf("0-gas/Flashbots fail tx: https://etherscan.io/tx/%s\n", tx.Hash())
-			analysis.TagTransactionStats(core.NewTxStatsFromTransactions(tx, receipt), consts.TxFlashBotsFailed, client)
 			txFromAddrStats.Add1(consts.FlashBotsFailedTxSent)
 		}
 
-		return
+		return nil
 	}
 
 	// TX was successful...
@@ -98,106 +165,70 @@ func ProcessTransaction(client *ethclient.Client, tx *types.Transaction, receipt
 		analysis.Data.NumTransactionsWithZeroValue += 1
 	}
 
-	// Check for smart contract calls: token transfer
-	data := tx.Data()
-	if len(data) > 0 {
+	// Check for smart contract calls
+	if len(tx.Data()) > 0 {
 		analysis.Data.NumTransactionsWithData += 1
 
 		txFromAddrStats.Add1(consts.NumTxWithDataSent)
 		txToAddrStats.Add1(consts.NumTxWithDataReceived)
 
-		// If no gas price, then count as MEV/Flashbots tx
-		if tx.GasPrice().Uint64() == 0 {
+		// If no gas price / priority fee, then count as MEV/Flashbots tx
+		if isZeroTipTx {
 			analysis.Data.NumFlashbotsTransactionsSuccess += 1
 			txFromAddrStats.Add1(consts.NumTxFlashbotsSent)
 			txToAddrStats.Add1(consts.NumTxFlashbotsReceived)
+		}
+	}
 
-			if core.Cfg.DebugPrintFlashbotsTx {
-				fmt.Printf("Flashbots ok tx: https://etherscan.io/tx/%s\n", tx.Hash())
-			}
+	// Token transfers are counted from the Transfer events, which also includes transfers through routers, multisigs etc.
+	if receipt != nil {
+		ProcessTransferLogs(receipt.Logs, analysis)
+	}
+	return nil
+}
+
+// ProcessTransferLogs counts the ERC20 and ERC721 Transfer events (see core.ParseTransferLog). Every event is counted
+// for the token contract (the log address), the token sender and the token receiver.
+func ProcessTransferLogs(logs []*types.Log, analysis *core.Analysis) {
+	hasErc20Transfer := false
+	hasErc721Transfer := false
+
+	for _, log := range logs {
+		transfer, ok := core.ParseTransferLog(log)
+		if !ok {
+			continue
 		}
 
-		if len(data) > 4 {
-			methodId := hex.EncodeToString(data[:4])
-			// fmt.Println(methodId)
-			if methodId == "a9059cbb" || methodId == "23b872dd" {
-				analysis.EnsureAddressDetailIsLoaded(&txFromAddrStats.AddressDetail)
-				analysis.EnsureAddressDetailIsLoaded(&txToAddrStats.AddressDetail)
-
-				// Calculate and store the number of tokens transferred
-				valueSenderStats := txFromAddrStats
-				valueReceiverStats := txToAddrStats
-				var value string
-				switch methodId {
-				case "a9059cbb": // transfer
-					_receiverAddrString := hex.EncodeToString(data[4:36])
-					_receiverAddr := common.HexToAddress(_receiverAddrString)
-					// fmt.Println("transfer. to", _receiverAddr, "\t .. tx from:", txFromAddrStats.Address)
-					valueReceiverStats = analysis.GetOrCreateAddressStats(&_receiverAddr)
-					analysis.EnsureAddressDetailIsLoaded(&valueReceiverStats.AddressDetail)
-
-					value = hex.EncodeToString(data[36:68])
-
-				case "23b872dd": // transferFrom
-					_senderAddrString := hex.EncodeToString(data[4:36])
-					_senderAddr := common.HexToAddress(_senderAddrString)
-					valueSenderStats = analysis.GetOrCreateAddressStats(&_senderAddr)
-					analysis.EnsureAddressDetailIsLoaded(&valueSenderStats.AddressDetail)
-
-					_receiverAddrString := hex.EncodeToString(data[36:68])
-					_receiverAddr := common.HexToAddress(_receiverAddrString)
-					valueReceiverStats = analysis.GetOrCreateAddressStats(&_receiverAddr)
-					analysis.EnsureAddressDetailIsLoaded(&valueReceiverStats.AddressDetail)
-
-					if len(data) < 100 {
-						log.Printf("warn: transferFrom with data < 100. tx: %s, len: %d", tx.Hash().String(), len(data))
-					} else {
-						value = hex.EncodeToString(data[68:100])
-					}
-					// fmt.Println("transferFrom. from", _senderAddr, ", tx", txFromAddrStats.Address, "... to", _receiverAddr)
-				}
-
-				valBigInt := new(big.Int)
-				valBigInt.SetString(value, 16)
-
-				// If ERC2 SC call
-				if txToAddrStats.AddressDetail.IsErc20() {
-					analysis.Data.NumTransactionsErc20Transfer += 1
-
-					// Count sender
-					txFromAddrStats.Add1(consts.NumTxErc20Sent)
-					txFromAddrStats.Add(consts.Erc20TokensSent, valBigInt)
-					if txFromAddrStats.AddressDetail.Address != valueSenderStats.AddressDetail.Address {
-						valueSenderStats.Add1(consts.NumTxErc20Sent)
-						valueSenderStats.Add(consts.Erc20TokensSent, valBigInt)
-					}
-
-					// Count SC transfer calls
-					txToAddrStats.Add1(consts.NumTxErc20Transfer)
-					txToAddrStats.Add(consts.Erc20TokensTransferred, valBigInt)
-
-					// Count token transfer receiver
-					valueReceiverStats.Add1(consts.NumTxErc20Received)
-					valueReceiverStats.Add(consts.Erc20TokensReceived, valBigInt)
-
-				} else if txToAddrStats.AddressDetail.IsErc721() {
-					// fmt.Println("ERC721 transfer", tx.Hash(), "from", txFromAddrStats.AddressDetail.Address)
-					analysis.Data.NumTransactionsErc721Transfer += 1
-
-					// Count sender
-					txFromAddrStats.Add1(consts.NumTxErc721Sent)
-					// fmt.Println(1, txFromAddrStats.AddressDetail.Address, txFromAddrStats.Stats[consts.NumTxErc721Sent])
-					if txFromAddrStats.AddressDetail.Address != valueSenderStats.AddressDetail.Address {
-						valueSenderStats.Add1(consts.NumTxErc721Sent)
-					}
-
-					// Count SC
-					txToAddrStats.Add1(consts.NumTxErc721Transfer)
-
-					// Count token receiver
-					valueReceiverStats.Add1(consts.NumTxErc721Received)
-				}
-			}
+		tokenStats := analysis.GetOrCreateAddressStats(&transfer.Token)
+		senderStats := analysis.GetOrCreateAddressStats(&transfer.From)
+		receiverStats := analysis.GetOrCreateAddressStats(&transfer.To)
+
+		if transfer.IsErc721 {
+			hasErc721Transfer = true
+			tokenStats.Add1(consts.NumTxErc721Transfer)
+			senderStats.Add1(consts.NumTxErc721Sent)
+			receiverStats.Add1(consts.NumTxErc721Received)
+		} else {
+			hasErc20Transfer = true
+			tokenStats.Add1(consts.NumTxErc20Transfer)
+			tokenStats.Add(consts.Erc20TokensTransferred, transfer.Value)
+
+			senderStats.Add1(consts.NumTxErc20Sent)
+			senderStats.Add(consts.Erc20TokensSent, transfer.Value)
+
+			receiverStats.Add1(consts.NumTxErc20Received)
+			receiverStats.Add(consts.Erc20TokensReceived, transfer.Value)
+
+			// Per-token amounts, as tokens with different decimals can't be summed up
+			analysis.GetOrCreateAddressTokenStats(&transfer.From, &transfer.Token).AddSent(transfer.Value)
+			analysis.GetOrCreateAddressTokenStats(&transfer.To, &transfer.Token).AddReceived(transfer.Value)
 		}
 	}
+
+	if hasErc20Transfer {
+		analysis.Data.NumTransactionsErc20Transfer += 1
+	}
+	if hasErc721Transfer {
+		analysis.Data.NumTransactionsErc721Transfer += 1
+	}
 }
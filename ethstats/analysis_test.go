@@ -0,0 +1,399 @@
+package ethstats
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/testutils"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/")
+
+var (
+	alice    = testutils.NewAccount("alice")
+	bob      = testutils.NewAccount("bob")
+	carol    = testutils.NewAccount("carol")
+	token    = testutils.NewAccount("erc20-token")
+	nft      = testutils.NewAccount("erc721-token")
+	contract = testutils.NewAccount("other-contract")
+	token2   = testutils.NewAccount("erc20-token-2")
+)
+
+func newTestAddressDetailService() *testutils.FakeAddressDetailService {
+	return testutils.NewFakeAddressDetailService(
+		testutils.Erc20Detail(token, "Test Token", "TT", 18),
+		testutils.Erc721Detail(nft, "Test NFT", "TNFT"),
+		testutils.Erc20Detail(token2, "Other Token", "OT", 6),
+		addressdetail.AddressDetail{Address: contract.Hex(), Type: addressdetail.AddressTypeOtherContract, Name: "Some Contract"},
+	)
+}
+
+// goldenResult is what is compared against testdata/<name>.golden.json
+type goldenResult struct {
+	Addresses     map[string]map[string]string      `json:"addresses"`
+	AddressTokens map[string]core.AddressTokenStats `json:"addressTokens"`
+	Blocks        []core.BlockStats                 `json:"blocks"`
+	Analysis      core.AnalysisJsonExport           `json:"analysis"`
+}
+
+func newGoldenResult(analysis *core.Analysis) goldenResult {
+	res := goldenResult{
+		Addresses:     make(map[string]map[string]string),
+		AddressTokens: make(map[string]core.AddressTokenStats),
+		Blocks:        analysis.Blocks,
+		Analysis:      core.NewAnalysisJsonExport(analysis),
+	}
+	for addr, stats := range analysis.Addresses {
+		res.Addresses[addr] = core.NewAddressStatsJson(*stats).Stats
+	}
+	for key, stats := range analysis.AddressTokens {
+		res.AddressTokens[key] = *stats
+	}
+	return res
+}
+
+func assertGolden(t *testing.T, name string, analysis *core.Analysis) {
+	t.Helper()
+
+	actual, err := json.MarshalIndent(newGoldenResult(analysis), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+
+	goldenFile := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err = ioutil.WriteFile(goldenFile, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("%v (run 'go test ./ethstats -update' to create it)", err)
+	}
+
+	if string(actual) != string(expected) {
+		t.Errorf("result differs from %s (run 'go test ./ethstats -update' and check the diff)\ngot:\n%s", goldenFile, actual)
+	}
+}
+
+type txTestCase struct {
+	name    string
+	tx      *types.Transaction
+	receipt *types.Receipt
+}
+
+func getTxTestCases() []txTestCase {
+	gasPrice := new(big.Int).Mul(big.NewInt(10), testutils.Gwei)
+	oneEth := testutils.Ether
+	tokenAmount := new(big.Int).Mul(big.NewInt(250), testutils.Ether)
+
+	txValueTransfer := testutils.NewTx(alice, 0, &bob.Address, oneEth, gasPrice, nil)
+	txFailed := testutils.NewTx(alice, 1, &contract.Address, bigZero(), gasPrice, []byte{1, 2, 3, 4, 5})
+	txFlashbotsFailed := testutils.NewTx(alice, 2, &contract.Address, bigZero(), bigZero(), []byte{1, 2, 3, 4, 5})
+	txFlashbots := testutils.NewTx(alice, 3, &contract.Address, oneEth, bigZero(), []byte{1, 2, 3, 4, 5})
+	txErc20Transfer := testutils.NewTx(alice, 4, &token.Address, bigZero(), gasPrice, testutils.TransferData(bob.Address, tokenAmount))
+	txErc20TransferFrom := testutils.NewTx(carol, 0, &token.Address, bigZero(), gasPrice, testutils.TransferFromData(alice.Address, bob.Address, tokenAmount))
+	txErc721TransferFrom := testutils.NewTx(alice, 5, &nft.Address, bigZero(), gasPrice, testutils.TransferFromData(alice.Address, bob.Address, big.NewInt(1234)))
+	txContractCreation := testutils.NewTx(bob, 0, nil, bigZero(), gasPrice, []byte{0x60, 0x80, 0x60, 0x40, 0x52})
+	txWithoutReceipt := testutils.NewTx(bob, 1, &alice.Address, oneEth, gasPrice, nil)
+	txRouterSwap := testutils.NewTx(carol, 1, &contract.Address, bigZero(), gasPrice, []byte{1, 2, 3, 4, 5})
+	txErc721Mint := testutils.NewTx(carol, 2, &nft.Address, oneEth, gasPrice, []byte{1, 2, 3, 4, 5})
+	txFailedTransfer := testutils.NewTx(alice, 6, &token.Address, bigZero(), gasPrice, testutils.TransferData(bob.Address, tokenAmount))
+
+	return []txTestCase{
+		{"value-transfer", txValueTransfer, testutils.NewReceipt(txValueTransfer, true, 21000)},
+		{"failed", txFailed, testutils.NewReceipt(txFailed, false, 50000)},
+		{"flashbots-failed", txFlashbotsFailed, testutils.NewReceipt(txFlashbotsFailed, false, 80000)},
+		{"flashbots", txFlashbots, testutils.NewReceipt(txFlashbots, true, 120000)},
+		{"erc20-transfer", txErc20Transfer, testutils.NewReceipt(txErc20Transfer, true, 51000,
+			testutils.Erc20TransferLog(token.Address, alice.Address, bob.Address, tokenAmount))},
+		{"erc20-transferfrom", txErc20TransferFrom, testutils.NewReceipt(txErc20TransferFrom, true, 62000,
+			testutils.Erc20TransferLog(token.Address, alice.Address, bob.Address, tokenAmount))},
+		{"erc721-transferfrom", txErc721TransferFrom, testutils.NewReceipt(txErc721TransferFrom, true, 85000,
+			testutils.Erc721TransferLog(nft.Address, alice.Address, bob.Address, big.NewInt(1234)))},
+		{"erc20-router-swap", txRouterSwap, testutils.NewReceipt(txRouterSwap, true, 150000,
+			testutils.Erc20TransferLog(token.Address, carol.Address, contract.Address, tokenAmount),
+			testutils.Erc20TransferLog(token2.Address, contract.Address, carol.Address, big.NewInt(1_500_000)))},
+		{"erc721-mint", txErc721Mint, testutils.NewReceipt(txErc721Mint, true, 110000,
+			testutils.Erc721TransferLog(nft.Address, common.Address{}, carol.Address, big.NewInt(1)),
+			testutils.Erc721TransferLog(nft.Address, common.Address{}, carol.Address, big.NewInt(2)))},
+		{"erc20-transfer-failed", txFailedTransfer, testutils.NewReceipt(txFailedTransfer, false, 30000)},
+		{"contract-creation", txContractCreation, testutils.NewReceipt(txContractCreation, true, 300000)},
+		{"without-receipt", txWithoutReceipt, nil},
+	}
+}
+
+// getLondonTxTestCases returns transactions for a block with base fee londonBaseFee
+func getLondonTxTestCases() []txTestCase {
+	gwei := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), testutils.Gwei) }
+	oneEth := testutils.Ether
+
+	// base fee 30 gwei: legacy tx pays 40 gwei (10 gwei tip)
+	txLegacy := testutils.NewTx(alice, 10, &bob.Address, oneEth, gwei(40), nil)
+	// tip 2 gwei, fee cap 50 gwei: pays 32 gwei, 18 gwei headroom
+	txDynamicFee := testutils.NewDynamicFeeTx(alice, 11, &bob.Address, oneEth, gwei(2), gwei(50), nil)
+	// tip 5 gwei, fee cap 33 gwei: tip is capped to 3 gwei, no headroom
+	txDynamicFeeCapped := testutils.NewDynamicFeeTx(carol, 10, &contract.Address, bigZero(), gwei(5), gwei(33), []byte{1, 2, 3, 4, 5})
+	// no tip: only the base fee is paid
+	txDynamicFeeFlashbots := testutils.NewDynamicFeeTx(carol, 11, &contract.Address, oneEth, bigZero(), gwei(100), []byte{1, 2, 3, 4, 5})
+
+	return []txTestCase{
+		{"london-legacy", txLegacy, testutils.NewReceipt(txLegacy, true, 21000)},
+		{"london-dynamic-fee", txDynamicFee, testutils.NewReceipt(txDynamicFee, true, 21000)},
+		{"london-dynamic-fee-capped", txDynamicFeeCapped, testutils.NewReceipt(txDynamicFeeCapped, false, 50000)},
+		{"london-flashbots", txDynamicFeeFlashbots, testutils.NewReceipt(txDynamicFeeFlashbots, true, 120000)},
+	}
+}
+
+var londonBaseFee = new(big.Int).Mul(big.NewInt(30), testutils.Gwei)
+
+func bigZero() *big.Int {
+	return new(big.Int)
+}
+
+func TestProcessTransaction(t *testing.T) {
+	for _, tc := range getTxTestCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			analysis := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+			if err := ProcessTransaction(tc.tx, tc.receipt, nil, analysis); err != nil {
+				t.Fatal(err)
+			}
+			analysis.BuildTopTransactions()
+			assertGolden(t, "tx-"+tc.name, analysis)
+		})
+	}
+
+	for _, tc := range getLondonTxTestCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			analysis := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+			if err := ProcessTransaction(tc.tx, tc.receipt, londonBaseFee, analysis); err != nil {
+				t.Fatal(err)
+			}
+			analysis.BuildTopTransactions()
+			assertGolden(t, "tx-"+tc.name, analysis)
+		})
+	}
+}
+
+func TestErc20TransferValueRanking(t *testing.T) {
+	gasPrice := new(big.Int).Mul(big.NewInt(10), testutils.Gwei)
+	twoTT := new(big.Int).Mul(big.NewInt(2), testutils.Ether) // 18 decimals
+	fiveOT := big.NewInt(5_000_000)                           // 6 decimals
+	threeOT := big.NewInt(3_000_000)
+
+	// The swap transfers 2 TT and 5 OT: the largest transfer is 5 OT, although the raw amount of 2 TT is larger
+	txSwap := testutils.NewTx(carol, 0, &contract.Address, bigZero(), gasPrice, []byte{1, 2, 3, 4, 5})
+	receiptSwap := testutils.NewReceipt(txSwap, true, 150000,
+		testutils.Erc20TransferLog(token.Address, carol.Address, contract.Address, twoTT),
+		testutils.Erc20TransferLog(token2.Address, contract.Address, carol.Address, fiveOT))
+	txTransferTT := testutils.NewTx(alice, 0, &token.Address, bigZero(), gasPrice, testutils.TransferData(bob.Address, twoTT))
+	receiptTransferTT := testutils.NewReceipt(txTransferTT, true, 51000, testutils.Erc20TransferLog(token.Address, alice.Address, bob.Address, twoTT))
+	txTransferOT := testutils.NewTx(bob, 0, &token2.Address, bigZero(), gasPrice, testutils.TransferData(alice.Address, threeOT))
+	receiptTransferOT := testutils.NewReceipt(txTransferOT, true, 51000, testutils.Erc20TransferLog(token2.Address, bob.Address, alice.Address, threeOT))
+
+	txs := []struct {
+		tx      *types.Transaction
+		receipt *types.Receipt
+	}{{txTransferTT, receiptTransferTT}, {txTransferOT, receiptTransferOT}, {txSwap, receiptSwap}}
+	ads := &countingAddressDetailService{newTestAddressDetailService(), make(map[string]int)}
+	analysis := core.NewAnalysis(core.DefaultConfig(), nil, ads)
+	for _, tx := range txs {
+		if err := ProcessTransaction(tx.tx, tx.receipt, nil, analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	analysis.BuildTopTransactions()
+
+	// The token details are loaded once per token
+	if ads.lookups[strings.ToLower(token.Hex())] != 1 || ads.lookups[strings.ToLower(token2.Hex())] != 1 {
+		t.Errorf("got token lookups %v, want one per token", ads.lookups)
+	}
+
+	// 5 OT > 3 OT > 2 TT
+	expected := []struct {
+		hash   string
+		token  string
+		amount *big.Int
+	}{{txSwap.Hash().Hex(), "OT", fiveOT}, {txTransferOT.Hash().Hex(), "OT", threeOT}, {txTransferTT.Hash().Hex(), "TT", twoTT}}
+	topTx := analysis.Data.TopTransactions[core.Erc20TransferValueTxRanker]
+	if len(topTx) != len(expected) {
+		t.Fatalf("got %d top transactions, want %d", len(topTx), len(expected))
+	}
+	for i, e := range expected {
+		if topTx[i].Hash != e.hash || topTx[i].Erc20TransferToken.Symbol != e.token || topTx[i].Erc20TransferValue.Cmp(e.amount) != 0 {
+			t.Errorf("top transaction %d: got %s with %s %s, want %s with %s %s", i, topTx[i].Hash, topTx[i].Erc20TransferValue, topTx[i].Erc20TransferToken.Symbol, e.hash, e.amount, e.token)
+		}
+	}
+
+	// Without the ranking by ERC20 transfer value, the tokens are looked up only for the sorted top lists
+	ads = &countingAddressDetailService{newTestAddressDetailService(), make(map[string]int)}
+	analysis = core.NewAnalysis(core.DefaultConfig(), core.DefaultTxRankers()[:1], ads)
+	for _, tx := range txs {
+		if err := ProcessTransaction(tx.tx, tx.receipt, nil, analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ads.lookups) != 0 {
+		t.Errorf("got token lookups %v before BuildTopTransactions, want none", ads.lookups)
+	}
+	analysis.BuildTopTransactions()
+	if swap := analysis.Data.TopTransactions["GasFee"][0]; swap.Hash != txSwap.Hash().Hex() || swap.Erc20TransferToken.Symbol != "OT" || swap.Erc20TransferValue.Cmp(fiveOT) != 0 {
+		t.Errorf("top transaction by gas fee: got %s with %s %s, want the swap with 5 OT", swap.Hash, swap.Erc20TransferValue, swap.Erc20TransferToken.Symbol)
+	}
+}
+
+// countingAddressDetailService counts the lookups per address
+type countingAddressDetailService struct {
+	core.IAddressDetailService
+	lookups map[string]int
+}
+
+func (ads *countingAddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {
+	ads.lookups[strings.ToLower(a.Address)]++
+	ads.IAddressDetailService.EnsureIsLoaded(a)
+}
+
+func TestProcessBlockWithReceipts(t *testing.T) {
+	testCases := getTxTestCases()
+	txs := make([]*types.Transaction, len(testCases))
+	receipts := make([]*types.Receipt, len(testCases))
+	for i, tc := range testCases {
+		txs[i] = tc.tx
+		receipts[i] = tc.receipt
+	}
+
+	analysis := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+	analysis.Data.StartBlockNumber = 100
+	for _, block := range []*blockswithtx.BlockWithTxReceipts{
+		testutils.NewBlock(100, 1620000000, types.EmptyRootHash, txs, receipts),
+		testutils.NewBlock(101, 1620000013, types.EmptyRootHash, nil, nil),
+	} {
+		if err := ProcessBlockWithReceipts(block, analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	analysis.BuildTopAddresses()
+	analysis.BuildTopTokens()
+	analysis.BuildTopMiners()
+	analysis.BuildTopTransactions()
+	assertGolden(t, "blocks", analysis)
+}
+
+func TestProcessBlockWithReceiptsLondon(t *testing.T) {
+	testCases := getLondonTxTestCases()
+	txs := make([]*types.Transaction, len(testCases))
+	receipts := make([]*types.Receipt, len(testCases))
+	for i, tc := range testCases {
+		txs[i] = tc.tx
+		receipts[i] = tc.receipt
+	}
+
+	analysis := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+	analysis.Data.StartBlockNumber = 200
+	if err := ProcessBlockWithReceipts(testutils.NewLondonBlock(200, 1630000000, types.EmptyRootHash, londonBaseFee, txs, receipts), analysis); err != nil {
+		t.Fatal(err)
+	}
+	analysis.BuildTopAddresses()
+	analysis.BuildTopMiners()
+	analysis.BuildTopTransactions()
+	assertGolden(t, "blocks-london", analysis)
+
+	// (21000*40 + 21000*32 + 50000*33 + 120000*30) gwei / 212000 gas
+	if avg := analysis.Data.AvgEffectiveGasPrice(); avg.Cmp(big.NewInt(31_896_226_415)) != 0 {
+		t.Errorf("AvgEffectiveGasPrice: got %v", avg)
+	}
+}
+
+// panicAddressDetailService panics on every lookup
+type panicAddressDetailService struct{}
+
+func (panicAddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {
+	panic("lookup of " + a.Address)
+}
+
+func TestProcessBlockWithReceiptsErrors(t *testing.T) {
+	validTx := testutils.NewTx(alice, 0, &bob.Address, big.NewInt(1), big.NewInt(1), nil)
+	unsignedTx := types.NewTx(&types.LegacyTx{Nonce: 1, To: &bob.Address, Value: big.NewInt(2), Gas: 21000, GasPrice: big.NewInt(1)})
+	txs := []*types.Transaction{validTx, unsignedTx}
+	receipts := []*types.Receipt{testutils.NewReceipt(validTx, true, 21000), testutils.NewReceipt(unsignedTx, true, 21000)}
+
+	// The transaction without valid signature is skipped
+	analysis := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+	if err := ProcessBlockWithReceipts(testutils.NewBlock(100, 1620000000, types.EmptyRootHash, txs, receipts), analysis); err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Data.NumBlocks != 1 || analysis.Data.NumTransactions != 1 || analysis.Data.ValueTotalWei.Int64() != 1 {
+		t.Errorf("got %d blocks, %d tx, value %s, want only the valid tx", analysis.Data.NumBlocks, analysis.Data.NumTransactions, analysis.Data.ValueTotalWei)
+	}
+	if errs := analysis.Data.Errors; len(errs) != 1 || errs[0].BlockNumber != 100 || errs[0].TxHash != unsignedTx.Hash().Hex() {
+		t.Errorf("got errors %+v, want the unsigned tx", errs)
+	}
+
+	// A panic while processing a transaction leaves no partial stats: the failed flashbots tx panics when the address
+	// details of its tag are loaded
+	txFlashbotsFailed := testutils.NewTx(alice, 2, &contract.Address, bigZero(), bigZero(), []byte{1, 2, 3, 4, 5})
+	block := testutils.NewBlock(101, 1620000013, types.EmptyRootHash, []*types.Transaction{validTx, txFlashbotsFailed},
+		[]*types.Receipt{testutils.NewReceipt(validTx, true, 21000), testutils.NewReceipt(txFlashbotsFailed, false, 80000)})
+	analysis = core.NewAnalysis(core.DefaultConfig(), nil, panicAddressDetailService{})
+	if err := ProcessBlockWithReceipts(block, analysis); err != nil {
+		t.Fatal(err)
+	}
+	expected := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+	if err := ProcessBlockWithReceipts(testutils.NewBlock(101, 1620000013, types.EmptyRootHash, txs[:1], receipts[:1]), expected); err != nil {
+		t.Fatal(err)
+	}
+	if errs := analysis.Data.Errors; len(errs) != 1 || errs[0].TxHash != txFlashbotsFailed.Hash().Hex() {
+		t.Errorf("got errors %+v, want the panicking tx", errs)
+	}
+	if analysis.Data.NumTransactionsFailed != 0 || analysis.Data.GasFeeTotal.Cmp(expected.Data.GasFeeTotal) != 0 || len(analysis.Addresses) != len(expected.Addresses) {
+		t.Errorf("panicking tx was added partially: %d failed tx, gas fees %s, %d addresses", analysis.Data.NumTransactionsFailed, analysis.Data.GasFeeTotal, len(analysis.Addresses))
+	}
+
+	// A block with a receipt from another block is not added
+	block = testutils.NewBlock(101, 1620000013, types.EmptyRootHash, txs[:1], receipts[:1])
+	block.TxReceipts[validTx.Hash()].BlockHash = common.HexToHash("0x01")
+	analysis = core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+	if err := ProcessBlockWithReceipts(block, analysis); !errors.Is(err, ErrReceiptOfOtherBlock) {
+		t.Errorf("got error %v, want ErrReceiptOfOtherBlock", err)
+	}
+	if analysis.Data.NumBlocks != 0 || analysis.Data.NumTransactions != 0 {
+		t.Errorf("invalid block was added: %d blocks, %d tx", analysis.Data.NumBlocks, analysis.Data.NumTransactions)
+	}
+}
+
+// BenchmarkProcessTransaction compares processing with the recover guard of ProcessBlockWithReceipts against the
+// unguarded baseline
+func BenchmarkProcessTransaction(b *testing.B) {
+	blocks := newRandomChain(1, 100)
+	benchmark := func(process func(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, analysis *core.Analysis) error) func(b *testing.B) {
+		return func(b *testing.B) {
+			analysis := core.NewAnalysis(core.DefaultConfig(), nil, newTestAddressDetailService())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, block := range blocks {
+					for _, tx := range block.Block.Transactions() {
+						if err := process(tx, block.TxReceipts[tx.Hash()], block.Block.BaseFee(), analysis); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			}
+		}
+	}
+	b.Run("Baseline", benchmark(ProcessTransaction))
+	b.Run("Recover", benchmark(processTransactionRecover))
+}
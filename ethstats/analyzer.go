@@ -0,0 +1,270 @@
+package ethstats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// ErrNoBlockSource is returned by NewAnalyzer without WithBlockSource
+var ErrNoBlockSource = errors.New("analyzer needs a block source")
+
+// BlockProcessor is called for every block after it was added to the analysis, e.g. to collect custom stats
+type BlockProcessor func(block *blockswithtx.BlockWithTxReceipts, analysis *core.Analysis)
+
+// TxProcessor is called for every transaction after its block was added to the analysis. The receipt is nil if it
+// couldn't be fetched, and baseFee is nil before London.
+type TxProcessor func(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, analysis *core.Analysis)
+
+// Analyzer analyzes block ranges and follows new blocks with the settings of its options. It has no global state and
+// isn't changed by the analyses, so an Analyzer can be used concurrently, and analyzers with different settings can run
+// in the same process.
+//
+// With more than one shard, the processors are called concurrently for the analyses of the shards, and have to be safe
+// for concurrent use.
+type Analyzer struct {
+	source blocksource.BlockSource
+	ads    core.IAddressDetailService
+	out    io.Writer   // progress output
+	logger *log.Logger // warnings and errors, to out
+
+	numTopAddresses    int
+	numTopTransactions int
+	addressSketchSize  int
+	numShards          int
+	confirmationDepth  int64
+	txRankers          []core.TxRanker
+
+	blockProcessors []BlockProcessor
+	txProcessors    []TxProcessor
+}
+
+// Option is a setting of NewAnalyzer. An option with an invalid value returns an error, which NewAnalyzer returns.
+type Option func(a *Analyzer) error
+
+// WithBlockSource sets the source of the blocks with receipts (required)
+func WithBlockSource(source blocksource.BlockSource) Option {
+	return func(a *Analyzer) error {
+		a.source = source
+		return nil
+	}
+}
+
+// WithAddressDetailService sets the service that loads the details of the top addresses. Without it, the addresses
+// have no details.
+func WithAddressDetailService(ads core.IAddressDetailService) Option {
+	return func(a *Analyzer) error {
+		a.ads = ads
+		return nil
+	}
+}
+
+// WithTopN sets the length of the top lists: numAddresses for the address, token and miner lists, numTransactions for
+// the transaction lists. Both must be at least 1.
+func WithTopN(numAddresses int, numTransactions int) Option {
+	return func(a *Analyzer) error {
+		if numAddresses < 1 || numTransactions < 1 {
+			return fmt.Errorf("top list lengths must be at least 1, are %d/%d", numAddresses, numTransactions)
+		}
+		a.numTopAddresses = numAddresses
+		a.numTopTransactions = numTransactions
+		return nil
+	}
+}
+
+// WithAddressSketch approximates the top addresses with size counters per stats key, for memory bounded by the size
+// instead of the number of addresses (see core.Config.AddressSketchSize). 0 keeps the stats of all addresses.
+func WithAddressSketch(size int) Option {
+	return func(a *Analyzer) error {
+		if size < 0 {
+			return fmt.Errorf("invalid address sketch size %d", size)
+		}
+		a.addressSketchSize = size
+		return nil
+	}
+}
+
+// WithTxRankers sets the rankings of the top transactions (default: core.DefaultTxRankers). The names must be unique.
+func WithTxRankers(rankers ...core.TxRanker) Option {
+	return func(a *Analyzer) error {
+		if len(rankers) == 0 {
+			return errors.New("no tx rankers")
+		}
+		if err := core.ValidateTxRankers(rankers); err != nil {
+			return err
+		}
+		a.txRankers = rankers
+		return nil
+	}
+}
+
+// WithShards sets the number of workers processing blocks in parallel (see Analyzer.AnalyzeBlocks)
+func WithShards(numShards int) Option {
+	return func(a *Analyzer) error {
+		a.numShards = numShards
+		return nil
+	}
+}
+
+// WithConfirmationDepth sets how many blocks behind the head followers add new blocks (see Follower.Follow)
+func WithConfirmationDepth(depth int64) Option {
+	return func(a *Analyzer) error {
+		if depth < 0 {
+			return fmt.Errorf("invalid confirmation depth %d", depth)
+		}
+		a.confirmationDepth = depth
+		return nil
+	}
+}
+
+// WithConfig sets the top list lengths, the address sketch size, the number of shards and the confirmation depth of cfg
+func WithConfig(cfg core.Config) Option {
+	return func(a *Analyzer) error {
+		if err := WithTopN(cfg.NumTopAddresses, cfg.NumTopTransactions)(a); err != nil {
+			return err
+		}
+		if err := WithAddressSketch(cfg.AddressSketchSize)(a); err != nil {
+			return err
+		}
+		a.numShards = cfg.NumShards
+		return WithConfirmationDepth(cfg.ConfirmationDepth)(a)
+	}
+}
+
+// WithBlockProcessor adds a processor that is called for every block
+func WithBlockProcessor(processor BlockProcessor) Option {
+	return func(a *Analyzer) error {
+		a.blockProcessors = append(a.blockProcessors, processor)
+		return nil
+	}
+}
+
+// WithTxProcessor adds a processor that is called for every transaction
+func WithTxProcessor(processor TxProcessor) Option {
+	return func(a *Analyzer) error {
+		a.txProcessors = append(a.txProcessors, processor)
+		return nil
+	}
+}
+
+// WithPrintFlashbotsTx prints the successful transactions with data and without tip, which are counted as flashbots
+// transactions (see ProcessTransaction), to the output of the analyzer
+func WithPrintFlashbotsTx() Option {
+	return func(a *Analyzer) error {
+		// a.out is read when the processor is called, after all options are applied
+		a.txProcessors = append(a.txProcessors, func(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, analysis *core.Analysis) {
+			printFlashbotsTx(a.out, tx, receipt, baseFee)
+		})
+		return nil
+	}
+}
+
+// WithOutput sets where the progress, warnings and errors are printed to (default: stdout). Use ioutil.Discard for no
+// output.
+func WithOutput(w io.Writer) Option {
+	return func(a *Analyzer) error {
+		a.out = w
+		return nil
+	}
+}
+
+// NewAnalyzer returns an analyzer with the options applied to the defaults of core.DefaultConfig
+func NewAnalyzer(opts ...Option) (*Analyzer, error) {
+	a := &Analyzer{
+		ads:       nopAddressDetailService{},
+		out:       os.Stdout,
+		txRankers: core.DefaultTxRankers(),
+	}
+	if err := WithConfig(core.DefaultConfig())(a); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.source == nil {
+		return nil, ErrNoBlockSource
+	}
+	if a.numShards < 1 {
+		a.numShards = 1
+	}
+	a.out = &syncWriter{w: a.out} // the shard workers print concurrently
+	a.logger = log.New(a.out, "", log.LstdFlags)
+	return a, nil
+}
+
+// NewAnalysis returns an empty analysis with the top list lengths of the analyzer
+func (a *Analyzer) NewAnalysis() *core.Analysis {
+	return core.NewAnalysis(a.config(), a.txRankers, a.ads)
+}
+
+// config returns the settings of the analyzer that core.Analysis uses
+func (a *Analyzer) config() core.Config {
+	return core.Config{
+		NumTopAddresses:    a.numTopAddresses,
+		NumTopTransactions: a.numTopTransactions,
+		AddressSketchSize:  a.addressSketchSize,
+	}
+}
+
+// ProcessBlock adds the block to the analysis (see ProcessBlockWithReceipts), and calls the processors. The processors
+// are not called for an invalid block, which is not added.
+func (a *Analyzer) ProcessBlock(block *blockswithtx.BlockWithTxReceipts, analysis *core.Analysis) error {
+	if err := ProcessBlockWithReceipts(block, analysis); err != nil {
+		return err
+	}
+
+	for _, processor := range a.blockProcessors {
+		processor(block, analysis)
+	}
+
+	if len(a.txProcessors) > 0 {
+		baseFee := block.Block.BaseFee()
+		for _, tx := range block.Block.Transactions() {
+			for _, processor := range a.txProcessors {
+				processor(tx, block.TxReceipts[tx.Hash()], baseFee, analysis)
+			}
+		}
+	}
+	return nil
+}
+
+// printFlashbotsTx prints the tx to w if it's successful, has data and no tip (see WithPrintFlashbotsTx)
+func printFlashbotsTx(w io.Writer, tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int) {
+	if len(tx.Data()) == 0 || (receipt != nil && receipt.Status != 1) {
+		return
+	}
+	if core.NewTxFees(tx, common.Big1, baseFee).EffectiveGasTip.Sign() == 0 { // the tip per gas doesn't depend on the gas used
+		fmt.Fprintf(w, "Flashbots ok tx: https://etherscan.io/tx/%s\n", tx.Hash())
+	}
+}
+
+// syncWriter serializes the writes to the output of an analyzer
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Write(p)
+}
+
+// nopAddressDetailService is the address detail service of analyzers without WithAddressDetailService
+type nopAddressDetailService struct{}
+
+func (nopAddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {}
@@ -0,0 +1,154 @@
+package ethstats
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
+	"github.com/metachris/ethereum-go-experiments/consts"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// newTestAnalyzer returns an analyzer with the fake address details and without output
+func newTestAnalyzer(t *testing.T, source blocksource.BlockSource, numShards int, opts ...Option) *Analyzer {
+	t.Helper()
+	opts = append([]Option{
+		WithBlockSource(source),
+		WithAddressDetailService(newTestAddressDetailService()),
+		WithShards(numShards),
+		WithOutput(ioutil.Discard),
+	}, opts...)
+	analyzer, err := NewAnalyzer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return analyzer
+}
+
+// analyzeBlocks returns the analysis of the blocks, and fails the test if they can't be analyzed
+func analyzeBlocks(t *testing.T, analyzer *Analyzer, startHeight int64, endHeight int64) *core.Analysis {
+	t.Helper()
+	analysis, err := analyzer.AnalyzeBlocks(startHeight, endHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return analysis
+}
+
+func TestNewAnalyzerErrors(t *testing.T) {
+	if _, err := NewAnalyzer(); !errors.Is(err, ErrNoBlockSource) {
+		t.Errorf("without block source: got error %v, want ErrNoBlockSource", err)
+	}
+	if _, err := NewAnalyzer(WithBlockSource(blocksource.NewMemoryBlockSource()), WithTopN(-1, 10)); err == nil {
+		t.Error("negative top list length: no error")
+	}
+	if _, err := NewAnalyzer(WithBlockSource(blocksource.NewMemoryBlockSource()), WithTopN(10, 0)); err == nil {
+		t.Error("top list length 0: no error")
+	}
+	ranker := core.DefaultTxRankers()[0]
+	if _, err := NewAnalyzer(WithBlockSource(blocksource.NewMemoryBlockSource()), WithTxRankers(ranker, ranker)); err == nil {
+		t.Error("tx ranker used twice: no error")
+	}
+}
+
+func TestWithTxRankers(t *testing.T) {
+	source := blocksource.NewMemoryBlockSource(newRandomChain(1000, 10)...)
+	byGasUsed := core.NewBigIntTxRanker("GasUsed", func(stats *core.TxStats) *big.Int { return stats.GasUsed })
+	analysis := analyzeBlocks(t, newTestAnalyzer(t, source, 2, WithTopN(5, 3), WithTxRankers(byGasUsed)), 1000, 1009)
+
+	topTx := analysis.Data.TopTransactions["GasUsed"]
+	if len(analysis.Data.TopTransactions) != 1 || len(topTx) != 3 {
+		t.Fatalf("top transactions: got %v, want 3 transactions by gas used", analysis.Data.TopTransactions)
+	}
+	for i := 1; i < len(topTx); i++ {
+		if topTx[i].GasUsed.Cmp(topTx[i-1].GasUsed) > 0 {
+			t.Errorf("top transactions by gas used not sorted: %s after %s", topTx[i].GasUsed, topTx[i-1].GasUsed)
+		}
+	}
+}
+
+func TestAnalyzerOutput(t *testing.T) {
+	// Block 1005 is missing
+	chain := newRandomChain(1000, 10)
+	source := blocksource.NewMemoryBlockSource(append(chain[:5], chain[6:]...)...)
+	var out bytes.Buffer
+	analysis := analyzeBlocks(t, newTestAnalyzer(t, source, 1, WithOutput(&out)), 1000, 1009)
+
+	if len(analysis.Data.Errors) != 1 || analysis.Data.Errors[0].BlockNumber != 1005 {
+		t.Fatalf("errors: got %v, want block 1005", analysis.Data.Errors)
+	}
+	if !strings.Contains(out.String(), "Error getting block with tx receipts") {
+		t.Errorf("fetch error not written to the output:\n%s", out.String())
+	}
+}
+
+func TestAnalyzersConcurrent(t *testing.T) {
+	source := blocksource.NewMemoryBlockSource(newRandomChain(1000, 40)...)
+
+	var numBlocks, numTx int64
+	countBlocks := func(block *blockswithtx.BlockWithTxReceipts, analysis *core.Analysis) {
+		atomic.AddInt64(&numBlocks, 1)
+	}
+	countTx := func(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, analysis *core.Analysis) {
+		atomic.AddInt64(&numTx, 1)
+	}
+
+	analyzers := []*Analyzer{
+		newTestAnalyzer(t, source, 1, WithTopN(2, 1)),
+		newTestAnalyzer(t, source, 4, WithTopN(3, 4), WithBlockProcessor(countBlocks), WithTxProcessor(countTx)),
+	}
+	expected := make([]string, len(analyzers))
+	for i, analyzer := range analyzers {
+		expected[i] = analysisToJson(t, analyzeBlocks(t, analyzer, 1000, 1039))
+	}
+	if expected[0] == expected[1] {
+		t.Fatal("analyzers with different top list lengths have the same result")
+	}
+
+	// Each analyzer runs twice at the same time as the other one
+	results := make([]*core.Analysis, 2*len(analyzers))
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if results[i], err = analyzers[i%len(analyzers)].AnalyzeBlocks(1000, 1039); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	for i, result := range results {
+		analyzer := analyzers[i%len(analyzers)]
+		if actual := analysisToJson(t, result); actual != expected[i%len(analyzers)] {
+			t.Errorf("concurrent run %d differs from the run alone:\n%s", i, actual)
+		}
+		if n := len(result.Data.TopAddresses[consts.NumTxSent]); n != analyzer.numTopAddresses {
+			t.Errorf("run %d: %d top addresses, want %d", i, n, analyzer.numTopAddresses)
+		}
+		if n := len(result.Data.TopTransactions[core.DefaultTxRankers()[0].Name]); n != analyzer.numTopTransactions {
+			t.Errorf("run %d: %d top transactions, want %d", i, n, analyzer.numTopTransactions)
+		}
+	}
+
+	// The processors of the second analyzer ran for its three analyses
+	if numBlocks != 3*40 {
+		t.Errorf("block processor: called for %d blocks, want %d", numBlocks, 3*40)
+	}
+	if expectedTx := 3 * int64(results[1].Data.NumTransactions); numTx != expectedTx {
+		t.Errorf("tx processor: called for %d transactions, want %d", numTx, expectedTx)
+	}
+}
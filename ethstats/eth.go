@@ -2,59 +2,223 @@ package ethstats
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/metachris/ethereum-go-experiments/addressdata"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
 	"github.com/metachris/ethereum-go-experiments/core"
-	"github.com/metachris/go-ethutils/blockswithtx"
-	"github.com/metachris/go-ethutils/utils"
 )
 
-// Analyze blocks starting at specific block number, until a certain target timestamp
-func AnalyzeBlocks(client *ethclient.Client, startHeight int64, endHeight int64) *core.Analysis {
-	ads := addressdata.NewAddressDetailService(client)
-	analysis := core.NewAnalysis(core.Cfg, client, ads)
+// analysisShard processes a contiguous range of blocks into a partial analysis
+type analysisShard struct {
+	analysis    *core.Analysis
+	startHeight int64
+	resultChan  chan blocksource.BlockResult
+}
+
+// AnalyzeBlocks analyzes all blocks from startHeight to endHeight (including). The blocks are processed by the
+// WithShards workers: every worker processes a contiguous range of blocks into a partial analysis, and the partial
+// analyses are merged in order of the block ranges afterwards. The result is the same as with a single worker.
+//
+// If a reorg replaced blocks while they were fetched, the analysis contains blocks of different forks and can't be
+// used, and an error wrapping core.ErrBlockHashMismatch is returned (see checkForks).
+func (a *Analyzer) AnalyzeBlocks(startHeight int64, endHeight int64) (*core.Analysis, error) {
+	// Start timer
+	timeStartBlockProcessing := time.Now()
+
+	analysis := a.processBlocksSharded(startHeight, endHeight)
+
+	// End timer
+	timeNeededBlockProcessing := time.Since(timeStartBlockProcessing)
+	fmt.Fprintf(a.out, "Reading blocks done (%.3fs). Sorting %d addresses and checking address information...\n", timeNeededBlockProcessing.Seconds(), len(analysis.Addresses))
+
+	if err := checkForks(analysis); err != nil {
+		return nil, err
+	}
+	a.buildTopLists(analysis)
+	return analysis, nil
+}
+
+// AnalyzeBlocksWithCheckpoints is AnalyzeBlocks, which saves a checkpoint to checkpointFile after every
+// checkpointInterval blocks. With resume, the analysis continues after the checkpoint in checkpointFile, which must be
+// for the same block range, and the blocks before are not fetched again. A checkpoint is only saved if its blocks are
+// of the same fork, so the analysis can be resumed from the last checkpoint after a core.ErrBlockHashMismatch error.
+func (a *Analyzer) AnalyzeBlocksWithCheckpoints(startHeight int64, endHeight int64, checkpointFile string, checkpointInterval int64, resume bool) (*core.Analysis, error) {
+	timeStartBlockProcessing := time.Now()
+
+	analysis := a.NewAnalysis()
+	nextHeight := startHeight
+	if resume {
+		checkpoint, err := core.LoadCheckpoint(checkpointFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkpoint.CheckRange(startHeight, endHeight); err != nil {
+			return nil, err
+		}
+		if analysis, err = checkpoint.RestoreAnalysis(a.config(), a.txRankers, a.ads); err != nil {
+			return nil, err
+		}
+		nextHeight = checkpoint.NextHeight
+		fmt.Fprintf(a.out, "Resuming from checkpoint at block %d (%d of %d blocks done)\n", nextHeight, nextHeight-startHeight, endHeight-startHeight+1)
+	}
+
+	if checkpointInterval < 1 {
+		checkpointInterval = endHeight - startHeight + 1
+	}
+
+	for nextHeight <= endHeight {
+		chunkEndHeight := nextHeight + checkpointInterval - 1
+		if chunkEndHeight > endHeight {
+			chunkEndHeight = endHeight
+		}
+
+		analysis.MergeUnchecked(a.processBlocksSharded(nextHeight, chunkEndHeight))
+		if err := checkForks(analysis); err != nil {
+			return nil, err
+		}
+		nextHeight = chunkEndHeight + 1
+
+		if err := core.NewCheckpoint(analysis, startHeight, endHeight, nextHeight).Save(checkpointFile); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(a.out, "Saved checkpoint at block %d to %s\n", nextHeight, checkpointFile)
+	}
 	analysis.Data.StartBlockNumber = startHeight
 
-	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100) // channel for resulting BlockWithTxReceipt
+	timeNeededBlockProcessing := time.Since(timeStartBlockProcessing)
+	fmt.Fprintf(a.out, "Reading blocks done (%.3fs). Sorting %d addresses and checking address information...\n", timeNeededBlockProcessing.Seconds(), len(analysis.Addresses))
 
-	// Start block processor
-	var analyzeLock sync.Mutex
-	go func() {
-		analyzeLock.Lock()
-		defer analyzeLock.Unlock() // we unlock when done
+	a.buildTopLists(analysis)
+	return analysis, nil
+}
+
+// processBlocksSharded processes the blocks into an analysis with the shard workers (see AnalyzeBlocks), without
+// building the top lists
+func (a *Analyzer) processBlocksSharded(startHeight int64, endHeight int64) *core.Analysis {
+	numBlocks := endHeight - startHeight + 1
+	numShards := a.numShards
+	if int64(numShards) > numBlocks {
+		numShards = int(numBlocks)
+	}
+	if numShards < 1 {
+		numShards = 1
+	}
 
-		for block := range blockChan {
-			utils.PrintBlock(block.Block)
-			ProcessBlockWithReceipts(block, client, analysis)
+	shards := make([]*analysisShard, numShards)
+	for i := range shards {
+		shards[i] = &analysisShard{
+			analysis:    a.NewAnalysis(),
+			startHeight: startHeight + numBlocks*int64(i)/int64(numShards),
+			resultChan:  make(chan blocksource.BlockResult, 100),
 		}
-	}()
+		shards[i].analysis.Data.StartBlockNumber = shards[i].startHeight
+	}
 
-	// Start timer
-	timeStartBlockProcessing := time.Now()
+	// Start block processors
+	var processingWg sync.WaitGroup
+	for _, shard := range shards {
+		processingWg.Add(1)
+		go func(shard *analysisShard) {
+			defer processingWg.Done()
+			a.processBlocksInOrder(shard.resultChan, shard.startHeight, shard.analysis)
+		}(shard)
+	}
+
+	// Pass the fetched blocks and fetch errors on to the shard of their block range
+	resultChan := make(chan blocksource.BlockResult, 100)
+	dispatchDone := make(chan bool)
+	go func() {
+		for result := range resultChan {
+			shardIndex := len(shards) - 1
+			for shardIndex > 0 && shards[shardIndex].startHeight > result.Height {
+				shardIndex--
+			}
+			shards[shardIndex].resultChan <- result
+		}
+		for _, shard := range shards {
+			close(shard.resultChan)
+		}
+		dispatchDone <- true
+	}()
 
 	// Start fetching and processing blocks
-	blockswithtx.GetBlocksWithTxReceipts(client, blockChan, startHeight, endHeight, 5)
+	blocksource.GetBlocksWithTxReceipts(a.source, resultChan, startHeight, endHeight, 5)
 
 	// Wait for processing to finish
-	fmt.Println("Waiting for Analysis workers...")
-	close(blockChan)
-	analyzeLock.Lock() // wait until all blocks have been processed
+	fmt.Fprintln(a.out, "Waiting for Analysis workers...")
+	close(resultChan)
+	<-dispatchDone
+	processingWg.Wait() // wait until all blocks have been processed
 
-	// End timer
-	timeNeededBlockProcessing := time.Since(timeStartBlockProcessing)
-	fmt.Printf("Reading blocks done (%.3fs). Sorting %d addresses and checking address information...\n", timeNeededBlockProcessing.Seconds(), len(analysis.Addresses))
+	analysis := shards[0].analysis
+	for _, shard := range shards[1:] {
+		analysis.MergeUnchecked(shard.analysis)
+	}
+	analysis.Data.StartBlockNumber = startHeight
+	sort.SliceStable(analysis.Data.Errors, func(i, j int) bool {
+		return analysis.Data.Errors[i].BlockNumber < analysis.Data.Errors[j].BlockNumber
+	})
+	return analysis
+}
 
-	// Sort now
+// checkForks returns an error if the analysis contains blocks of different forks. This happens if a reorg replaces
+// blocks while they are fetched, i.e. when the analyzed blocks are not deep enough (see Config.ConfirmationDepth).
+func checkForks(analysis *core.Analysis) error {
+	if err := analysis.CheckBlockContinuity(); err != nil {
+		return fmt.Errorf("blocks of different forks were analyzed, use a larger confirmation depth: %w", err)
+	}
+	return nil
+}
+
+// buildTopLists sorts the stats after all blocks have been processed, and loads the details of the top addresses
+func (a *Analyzer) buildTopLists(analysis *core.Analysis) {
 	timeStartSort := time.Now()
 	analysis.BuildTopAddresses()
+	analysis.BuildTopTokens()
+	analysis.BuildTopMiners()
+	analysis.BuildTopTransactions()
 	timeNeededSort := time.Since(timeStartSort)
-	fmt.Printf("Sorting & checking addresses done (%.3fs)\n", timeNeededSort.Seconds())
+	fmt.Fprintf(a.out, "Sorting & checking addresses done (%.3fs)\n", timeNeededSort.Seconds())
 
 	// Update address details for top transactions
 	// result.EnsureTopTransactionAddressDetails(client)
+}
 
-	return analysis
+// processBlocksInOrder processes the fetched blocks from resultChan, starting at startHeight. Blocks arrive out of order
+// from the fetch workers, but are processed in order of the block number, so that results don't depend on fetch timing
+// (and replays give the same result as live runs). Blocks that couldn't be fetched are recorded as skipped.
+func (a *Analyzer) processBlocksInOrder(resultChan <-chan blocksource.BlockResult, startHeight int64, analysis *core.Analysis) {
+	pendingResults := make(map[int64]blocksource.BlockResult)
+	nextHeight := startHeight
+
+	processResult := func(result blocksource.BlockResult) {
+		if result.Err != nil {
+			a.logger.Println("Error getting block with tx receipts:", result.Err)
+			analysis.Data.AddSkippedBlock(result.Height, result.Err.Err)
+			return
+		}
+		block := result.Block
+		t := time.Unix(int64(block.Block.Time()), 0).UTC()
+		fmt.Fprintf(a.out, "%d \t %s \t tx=%-4d \t gas=%d\n", block.Block.Number(), t, len(block.Block.Transactions()), block.Block.GasUsed())
+		if err := a.ProcessBlock(block, analysis); err != nil {
+			a.logger.Printf("Error processing block %d, skipping it: %v\n", block.Block.Number(), err)
+			analysis.Data.AddSkippedBlock(block.Block.Number().Int64(), err)
+		}
+	}
+
+	// A failed fetch is a result too, so a missing block doesn't hold back the following blocks
+	for result := range resultChan {
+		pendingResults[result.Height] = result
+		for {
+			result, found := pendingResults[nextHeight]
+			if !found {
+				break
+			}
+			processResult(result)
+			delete(pendingResults, nextHeight)
+			nextHeight++
+		}
+	}
 }
@@ -0,0 +1,303 @@
+package ethstats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/ethereum-go-experiments/testutils"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// newRandomChain returns numBlocks blocks starting at startHeight with a deterministic mix of value transfers, token
+// transfers, failed and flashbots transactions. Many values are equal, to check the tie-breaking of the top lists.
+func newRandomChain(startHeight int64, numBlocks int) []*blockswithtx.BlockWithTxReceipts {
+	return newRandomFork(1, startHeight, numBlocks, types.EmptyRootHash, 1630000000)
+}
+
+// newRandomFork returns random blocks like newRandomChain, with the first block extending parentHash. Forks from the
+// same parent differ by the seed and startTime.
+func newRandomFork(seed int64, startHeight int64, numBlocks int, parentHash common.Hash, startTime uint64) []*blockswithtx.BlockWithTxReceipts {
+	rnd := rand.New(rand.NewSource(seed))
+	accounts := []testutils.Account{alice, bob, carol}
+	nonces := make(map[common.Address]uint64)
+	gwei := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), testutils.Gwei) }
+
+	blocks := make([]*blockswithtx.BlockWithTxReceipts, numBlocks)
+	for i := range blocks {
+		height := startHeight + int64(i)
+		numTx := rnd.Intn(6) // some blocks are empty
+		txs := make([]*types.Transaction, numTx)
+		receipts := make([]*types.Receipt, numTx)
+
+		for j := range txs {
+			from := accounts[rnd.Intn(len(accounts))]
+			to := accounts[rnd.Intn(len(accounts))]
+			nonce := nonces[from.Address]
+			nonces[from.Address]++
+			value := new(big.Int).Mul(big.NewInt(rnd.Int63n(3)), testutils.Ether)
+			gasPrice := gwei(rnd.Int63n(4) * 10)
+
+			switch rnd.Intn(4) {
+			case 0: // value transfer
+				txs[j] = testutils.NewTx(from, nonce, &to.Address, value, gasPrice, nil)
+				receipts[j] = testutils.NewReceipt(txs[j], true, 21000)
+			case 1: // token transfer
+				amount := new(big.Int).Mul(big.NewInt(rnd.Int63n(5)+1), testutils.Ether)
+				txs[j] = testutils.NewTx(from, nonce, &token.Address, new(big.Int), gasPrice, testutils.TransferData(to.Address, amount))
+				receipts[j] = testutils.NewReceipt(txs[j], true, 51000, testutils.Erc20TransferLog(token.Address, from.Address, to.Address, amount))
+			case 2: // contract call, with zero gas price a flashbots tx
+				txs[j] = testutils.NewTx(from, nonce, &contract.Address, value, gasPrice, []byte{1, 2, 3})
+				receipts[j] = testutils.NewReceipt(txs[j], rnd.Intn(3) > 0, 80000)
+			case 3: // EIP-1559 transaction
+				txs[j] = testutils.NewDynamicFeeTx(from, nonce, &to.Address, value, gwei(rnd.Int63n(3)), gwei(100), nil)
+				receipts[j] = testutils.NewReceipt(txs[j], true, 21000)
+			}
+		}
+
+		blocks[i] = testutils.NewLondonBlock(height, startTime+uint64(i)*13, parentHash, londonBaseFee, txs, receipts)
+		parentHash = blocks[i].Block.Hash()
+	}
+	return blocks
+}
+
+func analysisToJson(t *testing.T, analysis *core.Analysis) string {
+	t.Helper()
+	b, err := json.MarshalIndent(newGoldenResult(analysis), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAnalyzeBlocksShardedMatchesSerial(t *testing.T) {
+	blocks := newRandomChain(1000, 60)
+	source := blocksource.NewMemoryBlockSource(blocks...)
+
+	serial := analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1059)
+	if serial.Data.NumBlocks != 60 || serial.Data.EndBlockNumber != 1059 {
+		t.Fatalf("serial run: got %d blocks, end block %d", serial.Data.NumBlocks, serial.Data.EndBlockNumber)
+	}
+	expected := analysisToJson(t, serial)
+
+	for _, numShards := range []int{2, 3, 7, 60, 100} {
+		t.Run(fmt.Sprintf("shards-%d", numShards), func(t *testing.T) {
+			sharded := analyzeBlocks(t, newTestAnalyzer(t, source, numShards), 1000, 1059)
+			if actual := analysisToJson(t, sharded); actual != expected {
+				t.Errorf("result with %d shards differs from the serial run:\n%s", numShards, actual)
+			}
+		})
+	}
+}
+
+func TestAnalyzeBlocksShardedMissingBlock(t *testing.T) {
+	blocks := newRandomChain(1000, 20)
+	source := blocksource.NewMemoryBlockSource(append(blocks[:5:5], blocks[6:]...)...)
+
+	serial := analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1019)
+	sharded := analyzeBlocks(t, newTestAnalyzer(t, source, 4), 1000, 1019)
+	if sharded.Data.NumBlocks != 19 {
+		t.Errorf("NumBlocks: got %d, want 19", sharded.Data.NumBlocks)
+	}
+	if errs := sharded.Data.Errors; len(errs) != 1 || errs[0].BlockNumber != 1005 || errs[0].TxHash != "" {
+		t.Errorf("got errors %+v, want the missing block 1005", errs)
+	}
+	if analysisToJson(t, serial) != analysisToJson(t, sharded) {
+		t.Error("result with 4 shards differs from the serial run")
+	}
+}
+
+// gatedBlockSource holds back the fetch of a block until gate is closed, or fails it after a timeout
+type gatedBlockSource struct {
+	blocksource.BlockSource
+	gatedHeight int64
+	gate        chan struct{}
+}
+
+func (s *gatedBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	if height == s.gatedHeight {
+		select {
+		case <-s.gate:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("gate not opened")
+		}
+	}
+	return s.BlockSource.GetBlockWithTxReceipts(height)
+}
+
+func TestAnalyzeBlocksMissingBlockDoesNotStall(t *testing.T) {
+	// Block 1005 is missing, and block 1019 is only fetched after block 1006 was processed
+	blocks := newRandomChain(1000, 20)
+	source := &gatedBlockSource{
+		BlockSource: blocksource.NewMemoryBlockSource(append(blocks[:5:5], blocks[6:]...)...),
+		gatedHeight: 1019,
+		gate:        make(chan struct{}),
+	}
+	var openGate sync.Once
+	analyzer := newTestAnalyzer(t, source, 1, WithBlockProcessor(func(block *blockswithtx.BlockWithTxReceipts, analysis *core.Analysis) {
+		if block.Block.Number().Int64() == 1006 {
+			openGate.Do(func() { close(source.gate) })
+		}
+	}))
+
+	analysis := analyzeBlocks(t, analyzer, 1000, 1019)
+	if errs := analysis.Data.Errors; len(errs) != 1 || errs[0].BlockNumber != 1005 {
+		t.Errorf("got errors %+v, want only the missing block 1005", errs)
+	}
+	if analysis.Data.NumBlocks != 19 {
+		t.Errorf("NumBlocks: got %d, want 19", analysis.Data.NumBlocks)
+	}
+}
+
+func TestAnalyzeBlocksMixedForks(t *testing.T) {
+	chain := newRandomChain(1000, 10)
+	fork := newRandomFork(2, 1006, 4, chain[5].Block.Hash(), chain[6].Block.Time()+1)
+
+	source := blocksource.NewMemoryBlockSource(chain...)
+	if err := analyzeBlocks(t, newTestAnalyzer(t, source, 3), 1000, 1009).CheckBlockContinuity(); err != nil {
+		t.Errorf("blocks of one chain: got error %v", err)
+	}
+
+	// A reorg while the blocks are fetched: blocks from 1008 are from the new fork
+	source.AddBlock(fork[2])
+	source.AddBlock(fork[3])
+	analysis, err := newTestAnalyzer(t, source, 3).AnalyzeBlocks(1000, 1009)
+	if !errors.Is(err, core.ErrBlockHashMismatch) || analysis != nil {
+		t.Errorf("blocks of two forks: got error %v, want ErrBlockHashMismatch", err)
+	}
+
+	// With checkpoints, the checkpoint before the reorg is kept
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json.gz")
+	if _, err = newTestAnalyzer(t, source, 1).AnalyzeBlocksWithCheckpoints(1000, 1009, checkpointFile, 5, false); !errors.Is(err, core.ErrBlockHashMismatch) {
+		t.Errorf("blocks of two forks with checkpoints: got error %v, want ErrBlockHashMismatch", err)
+	}
+	checkpoint, err := core.LoadCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.NextHeight != 1005 {
+		t.Errorf("checkpoint: next height %d, want 1005", checkpoint.NextHeight)
+	}
+}
+
+func TestMergeJsonExports(t *testing.T) {
+	blocks := newRandomChain(1000, 30)
+	source := blocksource.NewMemoryBlockSource(blocks...)
+	whole := analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1029)
+
+	// Hourly analyses of the same blocks, saved as full JSON exports and loaded again
+	dir := t.TempDir()
+	parts := make([]*core.Analysis, 0)
+	for _, r := range [][2]int64{{1000, 1009}, {1010, 1010}, {1011, 1029}} {
+		filename := filepath.Join(dir, fmt.Sprintf("%d.json", r[0]))
+		if err := analyzeBlocks(t, newTestAnalyzer(t, source, 1), r[0], r[1]).SaveJson(filename, true); err != nil {
+			t.Fatal(err)
+		}
+		part, err := core.LoadAnalysisJson(core.DefaultConfig(), nil, filename, newTestAddressDetailService())
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part)
+	}
+
+	if err := parts[0].Merge(parts[2]); !errors.Is(err, core.ErrNonContiguousRange) {
+		t.Errorf("merging non-contiguous analyses: got error %v", err)
+	}
+
+	merged := parts[0]
+	for _, part := range parts[1:] {
+		if err := merged.Merge(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merged.BuildTopAddresses()
+	merged.BuildTopTokens()
+	merged.BuildTopMiners()
+	merged.BuildTopTransactions()
+
+	expected, _ := json.MarshalIndent(core.NewFullAnalysisJsonExport(whole), "", "  ")
+	actual, _ := json.MarshalIndent(core.NewFullAnalysisJsonExport(merged), "", "  ")
+	if string(actual) != string(expected) {
+		t.Errorf("merged analysis differs from the analysis of all blocks:\n%s", actual)
+	}
+}
+
+func TestLoadAnalysisJsonIncomplete(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "analysis.json")
+	analysis := analyzeBlocks(t, newTestAnalyzer(t, blocksource.NewMemoryBlockSource(newRandomChain(1000, 2)...), 1), 1000, 1001)
+	if err := analysis.SaveJson(filename, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.LoadAnalysisJson(core.DefaultConfig(), nil, filename, newTestAddressDetailService()); !errors.Is(err, core.ErrIncompleteExport) {
+		t.Errorf("got error %v, want ErrIncompleteExport", err)
+	}
+}
+
+// countingBlockSource records which blocks were fetched
+type countingBlockSource struct {
+	blocksource.BlockSource
+	lock    sync.Mutex
+	fetched []int64
+}
+
+func (s *countingBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	s.lock.Lock()
+	s.fetched = append(s.fetched, height)
+	s.lock.Unlock()
+	return s.BlockSource.GetBlockWithTxReceipts(height)
+}
+
+func TestAnalyzeBlocksWithCheckpoints(t *testing.T) {
+	source := blocksource.NewMemoryBlockSource(newRandomChain(1000, 40)...)
+	expected := analysisToJson(t, analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1039))
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json.gz")
+
+	analysis, err := newTestAnalyzer(t, source, 3).AnalyzeBlocksWithCheckpoints(1000, 1039, checkpointFile, 7, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := analysisToJson(t, analysis); actual != expected {
+		t.Errorf("result with checkpoints differs from the run without:\n%s", actual)
+	}
+
+	// A run that stopped after the checkpoint at block 1014
+	partial := newTestAnalyzer(t, source, 2).processBlocksSharded(1000, 1013)
+	if err = core.NewCheckpoint(partial, 1000, 1039, 1014).Save(checkpointFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = newTestAnalyzer(t, source, 3).AnalyzeBlocksWithCheckpoints(1000, 1040, checkpointFile, 7, true); !errors.Is(err, core.ErrCheckpointMismatch) {
+		t.Errorf("resume with another block range: got error %v", err)
+	}
+
+	countingSource := &countingBlockSource{BlockSource: source}
+	resumed, err := newTestAnalyzer(t, countingSource, 3).AnalyzeBlocksWithCheckpoints(1000, 1039, checkpointFile, 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := analysisToJson(t, resumed); actual != expected {
+		t.Errorf("resumed result differs from the run without checkpoints:\n%s", actual)
+	}
+	if len(countingSource.fetched) != 26 {
+		t.Errorf("resume fetched %d blocks, want 26", len(countingSource.fetched))
+	}
+	for _, height := range countingSource.fetched {
+		if height < 1014 {
+			t.Errorf("resume fetched block %d from before the checkpoint", height)
+		}
+	}
+
+	if cp, err := core.LoadCheckpoint(checkpointFile); err != nil || cp.NextHeight != 1040 {
+		t.Errorf("last checkpoint: got next height %d, error %v", cp.NextHeight, err)
+	}
+}
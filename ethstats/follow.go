@@ -0,0 +1,321 @@
+package ethstats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// HeadSubscriber notifies about new chain heads (implemented by ethclient.Client, needs a websocket or IPC connection)
+type HeadSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// Follower analyzes new blocks as they arrive, and keeps rolling windows over the most recent blocks (e.g. the last 5
+// minutes, hour and day). The newest MaxReorgDepth blocks are kept as their own analyses, so that the blocks of an old
+// fork can be rolled back in a chain reorg. Older blocks are merged into the panes of each window: running aggregates
+// of the blocks in a time slot of window/PanesPerWindow, which are dropped once they are older than the window. A
+// snapshot merges the panes and recent blocks within the window, so memory and snapshots grow with the number of panes
+// instead of blocks. Windows start at a pane boundary, i.e. a window can be up to one pane shorter than its duration.
+//
+// PanesPerWindow and MaxReorgDepth must be set before blocks are added. A Follower is not safe for concurrent use.
+type Follower struct {
+	Windows           []time.Duration
+	PanesPerWindow    int                    // the panes of a window are window/PanesPerWindow long (at least 1s)
+	MaxReorgDepth     int                    // the newest blocks that can be rolled back without dropping panes
+	ConfirmationDepth int64                  // new heads are followed this many blocks behind
+	OnRollback        func(fromNumber int64) // called after a reorg rolled back the blocks from fromNumber on
+
+	analyzer      *Analyzer
+	blocks        []core.BlockStats                  // the blocks within the longest window, ordered by block number
+	recent        []*core.Analysis                   // one analysis per block of the newest MaxReorgDepth blocks
+	panes         map[time.Duration][]*core.Analysis // the panes of each window, ordered by time
+	lastPaneBlock int64                              // number of the newest block that was merged into the panes
+}
+
+// NewFollower returns a follower that gets the blocks from the source of the analyzer, and processes them with its
+// settings and processors
+func (a *Analyzer) NewFollower(windows ...time.Duration) *Follower {
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	return &Follower{
+		Windows:           windows,
+		PanesPerWindow:    60,
+		MaxReorgDepth:     64,
+		ConfirmationDepth: a.confirmationDepth,
+		analyzer:          a,
+		blocks:            make([]core.BlockStats, 0),
+		recent:            make([]*core.Analysis, 0),
+		panes:             make(map[time.Duration][]*core.Analysis),
+	}
+}
+
+// LastBlockNumber returns the number of the newest block, or 0 if no block was added yet
+func (f *Follower) LastBlockNumber() int64 {
+	if len(f.blocks) == 0 {
+		return 0
+	}
+	return f.blocks[len(f.blocks)-1].Number
+}
+
+// NumBlocks returns the number of blocks kept for the windows
+func (f *Follower) NumBlocks() int {
+	return len(f.blocks)
+}
+
+// AddBlock processes a new block, and evicts the blocks and panes that are older than the windows (relative to the
+// timestamp of the new block). Blocks that were already added, and blocks older than all kept blocks are skipped.
+//
+// If the block doesn't extend the last block, the blocks before it are fetched from the source until they extend a
+// kept block. In a chain reorg, the kept blocks after this fork point are rolled back.
+func (f *Follower) AddBlock(block *blockswithtx.BlockWithTxReceipts) error {
+	number := block.Block.Number().Int64()
+	if len(f.blocks) > 0 && number < f.blocks[0].Number {
+		f.analyzer.logger.Printf("Skipping block %d, which is older than the kept blocks\n", number)
+		return nil
+	}
+	for i := len(f.blocks) - 1; i >= 0 && f.blocks[i].Number >= number; i-- {
+		if f.blocks[i].Hash == block.Block.Hash().Hex() {
+			return nil // already added
+		}
+	}
+
+	// Walk back from the new block until its ancestor extends a kept block
+	newBlocks := []*blockswithtx.BlockWithTxReceipts{block}
+	forkIndex := len(f.blocks) // index of the first kept block that is replaced by the new fork
+	for forkIndex > 0 {
+		first := newBlocks[0]
+		firstNumber := first.Block.Number().Int64()
+		parent := f.blocks[forkIndex-1]
+		if parent.Number >= firstNumber {
+			forkIndex-- // the new fork is shorter
+			continue
+		}
+		if parent.Number == firstNumber-1 && parent.Hash == first.Block.ParentHash().Hex() {
+			break
+		}
+
+		// Missing blocks are fetched as well
+		ancestor, err := f.analyzer.source.GetBlockWithTxReceipts(firstNumber - 1)
+		if err != nil {
+			return err
+		}
+		newBlocks = append([]*blockswithtx.BlockWithTxReceipts{ancestor}, newBlocks...)
+		if parent.Number == firstNumber-1 {
+			forkIndex--
+		}
+	}
+
+	if forkIndex < len(f.blocks) {
+		fromNumber := f.blocks[forkIndex].Number
+		f.analyzer.logger.Printf("Reorg at block %d: rolling back %d blocks, adding %d blocks of the new fork\n", fromNumber, len(f.blocks)-forkIndex, len(newBlocks))
+		if forkIndex == 0 {
+			f.analyzer.logger.Println("Warning: the reorg is older than the kept blocks, the windows only contain the blocks of the new fork")
+		}
+		f.rollback(forkIndex)
+		if f.OnRollback != nil {
+			f.OnRollback(fromNumber)
+		}
+	}
+
+	for _, newBlock := range newBlocks {
+		analysis := f.analyzer.NewAnalysis()
+		analysis.Data.StartBlockNumber = newBlock.Block.Number().Int64()
+		if err := f.analyzer.ProcessBlock(newBlock, analysis); err != nil {
+			return fmt.Errorf("block %d: %w", analysis.Data.StartBlockNumber, err) // fetched again with the next head
+		}
+		f.blocks = append(f.blocks, analysis.Blocks[0])
+		f.recent = append(f.recent, analysis)
+	}
+
+	f.mergeIntoPanes()
+	f.evict()
+	return nil
+}
+
+// rollback removes the kept blocks from forkIndex on. Blocks that were already merged into panes can't be removed, so
+// their panes are dropped with the older blocks in them.
+func (f *Follower) rollback(forkIndex int) {
+	fromNumber := f.blocks[forkIndex].Number
+	f.blocks = f.blocks[:forkIndex]
+	numKept := sort.Search(len(f.recent), func(i int) bool { return f.recent[i].Data.StartBlockNumber >= fromNumber })
+	f.recent = f.recent[:numKept]
+
+	if fromNumber <= f.lastPaneBlock {
+		f.analyzer.logger.Printf("Warning: the reorg is deeper than the %d recent blocks, the windows lose the other blocks of the dropped panes\n", f.MaxReorgDepth)
+		for window, panes := range f.panes {
+			numKept := sort.Search(len(panes), func(i int) bool { return panes[i].Data.EndBlockNumber >= fromNumber })
+			f.panes[window] = panes[:numKept]
+		}
+		f.lastPaneBlock = fromNumber - 1
+	}
+}
+
+// mergeIntoPanes merges the recent blocks beyond MaxReorgDepth into the panes of the windows
+func (f *Follower) mergeIntoPanes() {
+	numMerged := len(f.recent) - f.MaxReorgDepth
+	if numMerged <= 0 {
+		return
+	}
+
+	for _, blockAnalysis := range f.recent[:numMerged] {
+		timestamp := blockAnalysis.Data.StartBlockTimestamp
+		for _, window := range f.Windows {
+			if timestamp < f.windowStart(window) {
+				continue // already older than the window
+			}
+			paneSec := f.paneSeconds(window)
+			panes := f.panes[window]
+			if len(panes) == 0 || panes[len(panes)-1].Data.StartBlockTimestamp/paneSec != timestamp/paneSec {
+				panes = append(panes, f.analyzer.NewAnalysis())
+				f.panes[window] = panes
+			}
+			panes[len(panes)-1].MergeUnchecked(blockAnalysis)
+		}
+		f.lastPaneBlock = blockAnalysis.Data.EndBlockNumber
+	}
+	f.recent = f.recent[numMerged:]
+}
+
+// evict drops the blocks that are older than the longest window, and the panes that are older than their window
+func (f *Follower) evict() {
+	if len(f.Windows) == 0 {
+		return
+	}
+
+	start := f.windowStart(f.Windows[len(f.Windows)-1])
+	numEvicted := sort.Search(len(f.blocks), func(i int) bool { return f.blocks[i].Time >= start })
+	f.blocks = f.blocks[numEvicted:]
+	numEvicted = sort.Search(len(f.recent), func(i int) bool { return f.recent[i].Data.StartBlockTimestamp >= start })
+	f.recent = f.recent[numEvicted:]
+
+	for _, window := range f.Windows {
+		start := f.windowStart(window)
+		panes := f.panes[window]
+		numEvicted := sort.Search(len(panes), func(i int) bool { return panes[i].Data.StartBlockTimestamp >= start })
+		f.panes[window] = panes[numEvicted:]
+	}
+}
+
+// paneSeconds returns the length of the panes of the window
+func (f *Follower) paneSeconds(window time.Duration) uint64 {
+	paneSec := uint64(window.Seconds())
+	if f.PanesPerWindow > 1 {
+		paneSec /= uint64(f.PanesPerWindow)
+	}
+	if paneSec < 1 {
+		return 1
+	}
+	return paneSec
+}
+
+// windowStart returns the earliest block timestamp that is part of the window: the start of the first pane that lies
+// within the window, relative to the newest block
+func (f *Follower) windowStart(window time.Duration) uint64 {
+	newest := f.blocks[len(f.blocks)-1].Time
+	windowSec := uint64(window.Seconds())
+	if windowSec >= newest {
+		return 0
+	}
+	paneSec := f.paneSeconds(window)
+	return (newest - windowSec + paneSec) / paneSec * paneSec // newest-windowSec+1, rounded up to a pane boundary
+}
+
+// Snapshot returns the analysis of all blocks within the window, which must be one of the Windows, with the top lists
+// built
+func (f *Follower) Snapshot(window time.Duration) *core.Analysis {
+	snapshot := f.analyzer.NewAnalysis()
+	if len(f.blocks) == 0 {
+		return snapshot
+	}
+
+	start := f.windowStart(window)
+	for _, pane := range f.panes[window] {
+		if pane.Data.StartBlockTimestamp >= start {
+			snapshot.MergeUnchecked(pane)
+		}
+	}
+	for _, blockAnalysis := range f.recent {
+		if blockAnalysis.Data.StartBlockTimestamp >= start {
+			snapshot.MergeUnchecked(blockAnalysis)
+		}
+	}
+
+	snapshot.BuildTopAddresses()
+	snapshot.BuildTopTokens()
+	snapshot.BuildTopMiners()
+	snapshot.BuildTopTransactions()
+	return snapshot
+}
+
+// Follow subscribes to new heads, and adds the block ConfirmationDepth blocks behind each new head (and blocks that were
+// missed since the last one) from the source. A block that can't be fetched or added is retried with the next head, and
+// the blocks after it are added after it. Every snapshotInterval, onSnapshot is called with a snapshot of each
+// window. Runs until ctx is done or the subscription fails.
+func (f *Follower) Follow(ctx context.Context, subscriber HeadSubscriber, snapshotInterval time.Duration, onSnapshot func(window time.Duration, analysis *core.Analysis)) error {
+	headers := make(chan *types.Header, 100)
+	sub, err := subscriber.SubscribeNewHead(ctx, headers)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+
+	// Blocks are added in order. If a block can't be added, the next head continues at this block.
+	nextHeight := int64(-1)
+	if f.NumBlocks() > 0 {
+		nextHeight = f.LastBlockNumber() + 1
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-sub.Err():
+			return err
+
+		case header := <-headers:
+			// The confirmed block is also fetched if it was added already, to detect reorgs that don't extend the chain
+			confirmedHeight := header.Number.Int64() - f.ConfirmationDepth
+			if nextHeight < 0 {
+				nextHeight = confirmedHeight
+			}
+			fromHeight := confirmedHeight
+			if nextHeight < fromHeight {
+				fromHeight = nextHeight
+			}
+			for height := fromHeight; height <= confirmedHeight; height++ {
+				if err := f.fetchAndAddBlock(height); err != nil {
+					f.analyzer.logger.Printf("Error adding block %d, retrying with the next head: %v\n", height, err)
+					break
+				}
+				nextHeight = height + 1
+			}
+			if nextHeight > confirmedHeight {
+				fmt.Fprintf(f.analyzer.out, "Block %d added (%d blocks in the windows)\n", confirmedHeight, f.NumBlocks())
+			}
+
+		case <-ticker.C:
+			for _, window := range f.Windows {
+				onSnapshot(window, f.Snapshot(window))
+			}
+		}
+	}
+}
+
+// fetchAndAddBlock gets the block from the source, and adds it (see AddBlock)
+func (f *Follower) fetchAndAddBlock(height int64) error {
+	block, err := f.analyzer.source.GetBlockWithTxReceipts(height)
+	if err != nil {
+		return err
+	}
+	return f.AddBlock(block)
+}
@@ -0,0 +1,251 @@
+package ethstats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/metachris/ethereum-go-experiments/blocksource"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+func TestFollowerWindows(t *testing.T) {
+	blocks := newRandomChain(1000, 40) // 13s block time
+	source := blocksource.NewMemoryBlockSource(blocks...)
+
+	// With panes of 1s, the windows start at their exact timestamp. Blocks older than the 4 newest ones are in panes.
+	follower := newTestAnalyzer(t, source, 1).NewFollower(5*time.Minute, time.Minute)
+	follower.PanesPerWindow = 300
+	follower.MaxReorgDepth = 4
+	for _, block := range blocks {
+		if err := follower.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	follower.AddBlock(blocks[10]) // older blocks are skipped
+	follower.AddBlock(blocks[39]) // and blocks that were already added
+
+	// Blocks older than the longest window are evicted
+	if follower.NumBlocks() != 24 {
+		t.Errorf("NumBlocks: got %d, want 24", follower.NumBlocks())
+	}
+
+	for _, tc := range []struct {
+		window      time.Duration
+		startHeight int64
+	}{
+		{5 * time.Minute, 1016}, // 23 * 13s < 5m
+		{time.Minute, 1035},     // 4 * 13s < 1m
+	} {
+		expected := analysisToJson(t, analyzeBlocks(t, newTestAnalyzer(t, source, 1), tc.startHeight, 1039))
+		if actual := analysisToJson(t, follower.Snapshot(tc.window)); actual != expected {
+			t.Errorf("snapshot of window %s differs from the analysis of blocks %d-1039:\n%s", tc.window, tc.startHeight, actual)
+		}
+	}
+}
+
+func TestFollowerPanes(t *testing.T) {
+	blocks := newRandomChain(1000, 200)
+	source := blocksource.NewMemoryBlockSource(blocks...)
+
+	// Panes of 1m and 3m
+	windows := []time.Duration{10 * time.Minute, 30 * time.Minute}
+	follower := newTestAnalyzer(t, source, 1).NewFollower(windows...)
+	follower.PanesPerWindow = 10
+	follower.MaxReorgDepth = 8
+	for _, block := range blocks[:197] {
+		if err := follower.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A reorg of the 3 newest blocks only rolls back recent blocks
+	fork := newRandomFork(2, 1197, 3, blocks[196].Block.Hash(), blocks[196].Block.Time()+1)
+	for _, block := range fork {
+		source.AddBlock(block)
+	}
+	if err := follower.AddBlock(fork[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(follower.recent) != 8 {
+		t.Errorf("got %d recent blocks, want 8", len(follower.recent))
+	}
+	newest := fork[2].Block.Time()
+	for _, window := range windows {
+		// Only the panes within the window are kept
+		if n := len(follower.panes[window]); n > follower.PanesPerWindow {
+			t.Errorf("window %s: got %d panes, want at most %d", window, n, follower.PanesPerWindow)
+		}
+
+		// The window starts with the first pane after newest-window
+		paneSec := uint64(window.Seconds()) / 10
+		start := (newest - uint64(window.Seconds()) + paneSec) / paneSec * paneSec
+		startHeight := int64(1199)
+		for startHeight > 1000 && blocks[startHeight-1-1000].Block.Time() >= start {
+			startHeight--
+		}
+
+		expected := analysisToJson(t, analyzeBlocks(t, newTestAnalyzer(t, source, 1), startHeight, 1199))
+		if actual := analysisToJson(t, follower.Snapshot(window)); actual != expected {
+			t.Errorf("snapshot of window %s differs from the analysis of blocks %d-1199:\n%s", window, startHeight, actual)
+		}
+	}
+}
+
+// stubHeadSubscriber sends the headers of the blocks to the subscriber
+type stubHeadSubscriber struct {
+	blocks []*blockswithtx.BlockWithTxReceipts
+}
+
+func (s stubHeadSubscriber) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		for _, block := range s.blocks {
+			select {
+			case ch <- block.Block.Header():
+			case <-quit:
+				return nil
+			}
+		}
+		<-quit
+		return nil
+	}), nil
+}
+
+func TestFollowerReorg(t *testing.T) {
+	chain := newRandomChain(1000, 10)
+	source := blocksource.NewMemoryBlockSource(chain...)
+
+	follower := newTestAnalyzer(t, source, 1).NewFollower(time.Hour)
+	rolledBack := make([]int64, 0)
+	follower.OnRollback = func(fromNumber int64) { rolledBack = append(rolledBack, fromNumber) }
+	for _, block := range chain {
+		if err := follower.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The new fork replaces the blocks from 1006. Only its head is added, the blocks before are fetched from the source.
+	fork := newRandomFork(2, 1006, 6, chain[5].Block.Hash(), chain[6].Block.Time()+1)
+	for _, block := range fork {
+		source.AddBlock(block)
+	}
+	if err := follower.AddBlock(fork[5]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reorg to a shorter chain, replacing the blocks from 1009
+	shorterFork := newRandomFork(3, 1009, 1, fork[2].Block.Hash(), fork[3].Block.Time()+1)
+	source.AddBlock(shorterFork[0])
+	if err := follower.AddBlock(shorterFork[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rolledBack) != 2 || rolledBack[0] != 1006 || rolledBack[1] != 1009 {
+		t.Errorf("rolled back blocks from %v, want [1006 1009]", rolledBack)
+	}
+	if follower.NumBlocks() != 10 || follower.LastBlockNumber() != 1009 {
+		t.Errorf("got %d blocks up to %d, want 10 blocks up to 1009", follower.NumBlocks(), follower.LastBlockNumber())
+	}
+
+	snapshot := follower.Snapshot(time.Hour)
+	if err := snapshot.CheckBlockContinuity(); err != nil {
+		t.Error(err)
+	}
+	expected := analysisToJson(t, analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1009))
+	if actual := analysisToJson(t, snapshot); actual != expected {
+		t.Errorf("snapshot after the reorgs differs from the analysis of the new chain:\n%s", actual)
+	}
+}
+
+func TestFollowerFollow(t *testing.T) {
+	blocks := newRandomChain(1000, 8)
+	source := blocksource.NewMemoryBlockSource(blocks...)
+
+	// Blocks are added 2 blocks behind the head. Heads of blocks 1004 to 1007 are missing, and blocks 1002 to 1005 are
+	// fetched with the head of block 1007.
+	subscriber := stubHeadSubscriber{blocks: []*blockswithtx.BlockWithTxReceipts{blocks[2], blocks[3], blocks[7]}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	follower := newTestAnalyzer(t, source, 1).NewFollower(time.Hour)
+	follower.ConfirmationDepth = 2
+	var snapshot *core.Analysis
+	err := follower.Follow(ctx, subscriber, 10*time.Millisecond, func(window time.Duration, analysis *core.Analysis) {
+		if follower.LastBlockNumber() == 1005 {
+			snapshot = analysis
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("Follow: got error %v", err)
+	}
+
+	expected := analysisToJson(t, analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1005))
+	if actual := analysisToJson(t, snapshot); actual != expected {
+		t.Errorf("snapshot differs from the analysis of blocks 1000-1005:\n%s", actual)
+	}
+}
+
+// failingBlockSource fails the first fetches of blocks
+type failingBlockSource struct {
+	blocksource.BlockSource
+	lock     sync.Mutex
+	failures map[int64]int // remaining failures per block
+}
+
+func (s *failingBlockSource) GetBlockWithTxReceipts(height int64) (*blockswithtx.BlockWithTxReceipts, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures[height] > 0 {
+		s.failures[height]--
+		return nil, errors.New("block not available")
+	}
+	return s.BlockSource.GetBlockWithTxReceipts(height)
+}
+
+func TestFollowerFollowRetry(t *testing.T) {
+	blocks := newRandomChain(1000, 8)
+	source := &failingBlockSource{
+		BlockSource: blocksource.NewMemoryBlockSource(blocks...),
+		failures:    map[int64]int{1000: 1, 1002: 1},
+	}
+
+	// Block 1000 is added with the second head, block 1002 with the last one. The blocks after a failed block are added
+	// after it.
+	subscriber := stubHeadSubscriber{blocks: []*blockswithtx.BlockWithTxReceipts{blocks[2], blocks[3], blocks[7], blocks[7]}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	follower := newTestAnalyzer(t, source, 1, WithOutput(&out)).NewFollower(time.Hour)
+	follower.ConfirmationDepth = 2
+	var snapshot *core.Analysis
+	err := follower.Follow(ctx, subscriber, 10*time.Millisecond, func(window time.Duration, analysis *core.Analysis) {
+		if follower.LastBlockNumber() == 1005 {
+			snapshot = analysis
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("Follow: got error %v", err)
+	}
+
+	expected := analysisToJson(t, analyzeBlocks(t, newTestAnalyzer(t, source, 1), 1000, 1005))
+	if actual := analysisToJson(t, snapshot); actual != expected {
+		t.Errorf("snapshot differs from the analysis of blocks 1000-1005:\n%s", actual)
+	}
+	if n := strings.Count(out.String(), "retrying with the next head"); n != 2 {
+		t.Errorf("got %d retries, want 2:\n%s", n, out.String())
+	}
+}
@@ -3,11 +3,13 @@ module github.com/metachris/ethereum-go-experiments
 go 1.16
 
 require (
-	github.com/ethereum/go-ethereum v1.10.3
+	github.com/ethereum/go-ethereum v1.10.8
 	github.com/jmoiron/sqlx v1.3.3
 	github.com/labstack/echo/v4 v4.3.0
 	github.com/lib/pq v1.10.1
 	github.com/metachris/eth-go-bindings v0.5.0
-	github.com/metachris/go-ethutils v0.3.3 // indirect
-	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
+	github.com/metachris/go-ethutils v0.3.3
+	github.com/syndtr/goleveldb v1.0.1-0.20210305035536-64b5b1c73954
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	gopkg.in/yaml.v2 v2.4.0
 )
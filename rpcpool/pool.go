@@ -0,0 +1,343 @@
+// Pool of JSON-RPC endpoints with failover, per-endpoint rate limits and retries
+package rpcpool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/ethereum-go-experiments/core"
+	"golang.org/x/time/rate"
+)
+
+// ErrNoEndpoints is returned by NewPool without endpoints
+var ErrNoEndpoints = errors.New("no RPC endpoints")
+
+// Pool sends the requests of a JSON-RPC client to several HTTP endpoints. It's used as the http.RoundTripper of the
+// client (see Dial), so all requests of ethclient and rpc (including batches) go through the pool.
+//
+// The endpoints are used in order: a request goes to the first healthy endpoint with budget left. A failed request
+// (connection error, timeout, HTTP 429 or 5xx) is retried on the next endpoint, and with exponential backoff once every
+// endpoint failed. An endpoint with MaxFailures failures in a row is unhealthy, and only used again after a health check
+// (eth_blockNumber) succeeded. JSON-RPC errors (e.g. a reverted call) are responses of a healthy node and not retried.
+type Pool struct {
+	MaxRetries          int           // retries of a failed request
+	MinBackoff          time.Duration // wait before the first retry on an endpoint that failed the request already
+	MaxBackoff          time.Duration
+	MaxFailures         int           // failures in a row after which an endpoint is unhealthy
+	HealthCheckInterval time.Duration // between health checks of an unhealthy endpoint
+
+	endpoints  []*endpoint
+	httpClient *http.Client
+}
+
+type endpoint struct {
+	url     string
+	name    string        // host of the url, for logs and stats (the url can contain an API key)
+	limiter *rate.Limiter // nil for no limit
+
+	lock            sync.Mutex
+	healthy         bool
+	numFailures     int // in a row
+	nextHealthCheck time.Time
+	checking        bool // a health check is running
+	stats           EndpointStats
+}
+
+// EndpointStats are the requests sent to an endpoint
+type EndpointStats struct {
+	Name        string
+	Healthy     bool
+	NumRequests int
+	NumFailures int
+}
+
+// NewPool returns a pool of the HTTP endpoints in urls, in order of preference. requestsPerSecond is the budget of each
+// endpoint (0 for no limit), and timeout the timeout of a single request.
+func NewPool(urls []string, requestsPerSecond float64, timeout time.Duration) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	p := &Pool{
+		MaxRetries:          5,
+		MinBackoff:          500 * time.Millisecond,
+		MaxBackoff:          10 * time.Second,
+		MaxFailures:         3,
+		HealthCheckInterval: 30 * time.Second,
+		endpoints:           make([]*endpoint, len(urls)),
+		httpClient:          &http.Client{Timeout: timeout},
+	}
+
+	for i, rawurl := range urls {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("%s: not an HTTP endpoint", u.Host)
+		}
+
+		e := &endpoint{url: rawurl, name: u.Host, healthy: true}
+		if requestsPerSecond > 0 {
+			burst := int(requestsPerSecond)
+			if burst < 1 {
+				burst = 1
+			}
+			e.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
+		}
+		p.endpoints[i] = e
+	}
+	return p, nil
+}
+
+// Dial returns a client for cfg.EthNode (use ethclient.NewClient for the eth API). An HTTP endpoint is used with the
+// fallbacks, rate limit and retries of cfg.Rpc (see Pool). Websocket and IPC endpoints are connected directly.
+func Dial(cfg core.Config) (*rpc.Client, error) {
+	if !strings.HasPrefix(cfg.EthNode, "http://") && !strings.HasPrefix(cfg.EthNode, "https://") {
+		return rpc.Dial(cfg.EthNode)
+	}
+
+	pool, err := NewPool(append([]string{cfg.EthNode}, cfg.Rpc.FallbackNodes...), cfg.Rpc.RequestsPerSecond, cfg.Rpc.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	pool.MaxRetries = cfg.Rpc.MaxRetries
+	pool.HealthCheckInterval = cfg.Rpc.HealthCheckInterval
+	return pool.Client()
+}
+
+// Client returns a client that sends its requests through the pool
+func (p *Pool) Client() (*rpc.Client, error) {
+	return rpc.DialHTTPWithClient(p.endpoints[0].url, &http.Client{Transport: p})
+}
+
+// Stats returns the stats of all endpoints, in order of preference
+func (p *Pool) Stats() []EndpointStats {
+	stats := make([]EndpointStats, len(p.endpoints))
+	for i, e := range p.endpoints {
+		e.lock.Lock()
+		stats[i] = e.stats
+		stats[i].Name = e.name
+		stats[i].Healthy = e.healthy
+		e.lock.Unlock()
+	}
+	return stats
+}
+
+// RoundTrip sends the request to the endpoints until one succeeds, or MaxRetries retries failed. The URL of the request
+// is replaced with the URL of the endpoint.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	var resp *http.Response
+	var err error
+	tried := make(map[*endpoint]bool)
+	round := 0
+	for attempt := 0; attempt <= p.MaxRetries; attempt++ {
+		if resp != nil {
+			drainAndClose(resp) // response of a failed attempt
+		}
+
+		// Once every endpoint failed the request, the next round starts after the backoff
+		if len(tried) == len(p.endpoints) {
+			tried = make(map[*endpoint]bool)
+			round++
+			select {
+			case <-time.After(p.backoff(round)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		e, reserved := p.pick(tried)
+		tried[e] = true
+		if e.limiter != nil && !reserved {
+			if err := e.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = p.send(ctx, e, req, body)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			e.succeeded()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if err == nil {
+			err = fmt.Errorf("HTTP %s", resp.Status)
+		}
+		if attempt < p.MaxRetries {
+			log.Printf("RPC request to %s failed, retrying: %v\n", e.name, err)
+		}
+		p.failed(e, err)
+	}
+
+	if resp != nil {
+		return resp, nil // the client returns the error of the status
+	}
+	return nil, err
+}
+
+func (p *Pool) send(ctx context.Context, e *endpoint, req *http.Request, body []byte) (*http.Response, error) {
+	endpointReq, err := http.NewRequestWithContext(ctx, req.Method, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	endpointReq.Header = req.Header.Clone()
+
+	e.lock.Lock()
+	e.stats.NumRequests++
+	e.lock.Unlock()
+	return p.httpClient.Do(endpointReq)
+}
+
+// pick returns the first healthy endpoint that didn't fail the request yet, preferring endpoints with budget left. If
+// no endpoint is healthy, they are tried in order anyway. reserved is whether the request was taken from the budget.
+func (p *Pool) pick(tried map[*endpoint]bool) (e *endpoint, reserved bool) {
+	var firstHealthy, firstUntried *endpoint
+	for _, e = range p.endpoints {
+		if tried[e] {
+			continue
+		}
+		if firstUntried == nil {
+			firstUntried = e
+		}
+		if !p.isHealthy(e) {
+			continue
+		}
+		if e.limiter == nil {
+			return e, true
+		}
+		r := e.limiter.Reserve()
+		if r.OK() && r.Delay() == 0 {
+			return e, true
+		}
+		r.Cancel()
+		if firstHealthy == nil {
+			firstHealthy = e
+		}
+	}
+
+	if firstHealthy != nil {
+		return firstHealthy, false
+	}
+	return firstUntried, false
+}
+
+// isHealthy returns whether the endpoint is healthy, and starts a health check of an unhealthy endpoint if it's due
+func (p *Pool) isHealthy(e *endpoint) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.healthy && !e.checking && time.Now().After(e.nextHealthCheck) {
+		e.checking = true
+		go p.checkHealth(e)
+	}
+	return e.healthy
+}
+
+// checkHealth marks the endpoint as healthy again if it returns the block number
+func (p *Pool) checkHealth(e *endpoint) {
+	err := p.requestBlockNumber(e)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.checking = false
+	if err != nil {
+		e.nextHealthCheck = time.Now().Add(p.HealthCheckInterval)
+		return
+	}
+	e.healthy = true
+	e.numFailures = 0
+	log.Printf("RPC endpoint %s is healthy again\n", e.name)
+}
+
+func (p *Pool) requestBlockNumber(e *endpoint) error {
+	reqBody := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	resp, err := p.httpClient.Post(e.url, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+
+	var msg struct {
+		Result string          `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return err
+	}
+	if len(msg.Error) > 0 || len(msg.Result) == 0 {
+		return fmt.Errorf("no block number: %s", msg.Error)
+	}
+	return nil
+}
+
+func (e *endpoint) succeeded() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.numFailures = 0
+	if !e.healthy {
+		e.healthy = true
+		log.Printf("RPC endpoint %s is healthy again\n", e.name)
+	}
+}
+
+func (p *Pool) failed(e *endpoint, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.stats.NumFailures++
+	e.numFailures++
+	if e.healthy && e.numFailures >= p.MaxFailures {
+		e.healthy = false
+		e.nextHealthCheck = time.Now().Add(p.HealthCheckInterval)
+		log.Printf("RPC endpoint %s is unhealthy after %d failures in a row: %v\n", e.name, e.numFailures, err)
+	}
+}
+
+// backoff returns the wait before the round of retries: MinBackoff, doubled for every round up to MaxBackoff
+func (p *Pool) backoff(round int) time.Duration {
+	backoff := p.MinBackoff
+	for i := 1; i < round && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// isRetryableStatus returns whether the status is a failure of the endpoint (rate limited or server error)
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func drainAndClose(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,227 @@
+package rpcpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// stubNode is a JSON-RPC server that answers eth_blockNumber, and injects failures and latency
+type stubNode struct {
+	server *httptest.Server
+
+	lock        sync.Mutex
+	numRequests int
+	failStatus  int // HTTP status of the failures, 0 for no failures
+	numFailures int // the next requests that fail, -1 for all
+	delay       time.Duration
+}
+
+func newStubNode(t *testing.T) *stubNode {
+	node := &stubNode{}
+	node.server = httptest.NewServer(http.HandlerFunc(node.handle))
+	t.Cleanup(node.server.Close)
+	return node
+}
+
+// fail makes the next numFailures requests fail with status (-1 for all requests)
+func (n *stubNode) fail(status int, numFailures int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failStatus = status
+	n.numFailures = numFailures
+}
+
+func (n *stubNode) setDelay(delay time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.delay = delay
+}
+
+func (n *stubNode) requests() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.numRequests
+}
+
+func (n *stubNode) handle(w http.ResponseWriter, r *http.Request) {
+	n.lock.Lock()
+	n.numRequests++
+	delay := n.delay
+	status := 0
+	if n.numFailures != 0 {
+		status = n.failStatus
+		if n.numFailures > 0 {
+			n.numFailures--
+		}
+	}
+	n.lock.Unlock()
+
+	time.Sleep(delay)
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	var req struct {
+		Id     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+	if req.Method == "eth_blockNumber" {
+		resp["result"] = "0x10"
+	} else {
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestPool(t *testing.T, requestsPerSecond float64, timeout time.Duration, nodes ...*stubNode) *Pool {
+	t.Helper()
+	urls := make([]string, len(nodes))
+	for i, node := range nodes {
+		urls[i] = node.server.URL
+	}
+	pool, err := NewPool(urls, requestsPerSecond, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MinBackoff = time.Millisecond
+	pool.MaxBackoff = 10 * time.Millisecond
+	pool.HealthCheckInterval = 10 * time.Millisecond
+	return pool
+}
+
+func blockNumber(t *testing.T, pool *Pool) error {
+	t.Helper()
+	client, err := pool.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	number, err := ethclient.NewClient(client).BlockNumber(context.Background())
+	if err == nil && number != 16 {
+		t.Errorf("block number: got %d, want 16", number)
+	}
+	return err
+}
+
+func TestPoolFailover(t *testing.T) {
+	primary, fallback := newStubNode(t), newStubNode(t)
+	pool := newTestPool(t, 0, time.Second, primary, fallback)
+
+	primary.fail(http.StatusInternalServerError, -1)
+	for i := 0; i < 5; i++ {
+		if err := blockNumber(t, pool); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if stats := pool.Stats(); stats[0].Healthy || !stats[1].Healthy {
+		t.Errorf("after %d failures: got stats %+v, want the primary unhealthy", pool.MaxFailures, stats)
+	}
+	if primary.requests() > pool.MaxFailures+1 { // the failures and a health check
+		t.Errorf("unhealthy primary got %d requests", primary.requests())
+	}
+
+	// The health check brings the primary back
+	primary.fail(0, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for !pool.Stats()[0].Healthy && time.Now().Before(deadline) {
+		blockNumber(t, pool)
+		time.Sleep(5 * time.Millisecond)
+	}
+	numFallbackRequests := fallback.requests()
+	if err := blockNumber(t, pool); err != nil {
+		t.Fatal(err)
+	}
+	if fallback.requests() != numFallbackRequests {
+		t.Error("request went to the fallback after the primary is healthy again")
+	}
+}
+
+func TestPoolRetries(t *testing.T) {
+	node := newStubNode(t)
+	pool := newTestPool(t, 0, time.Second, node)
+	pool.HealthCheckInterval = time.Hour // no health check requests
+
+	// Rate limited twice, then successful
+	node.fail(http.StatusTooManyRequests, 2)
+	if err := blockNumber(t, pool); err != nil {
+		t.Fatal(err)
+	}
+	if node.requests() != 3 {
+		t.Errorf("got %d requests, want 3", node.requests())
+	}
+
+	// All retries fail: the client gets the HTTP error
+	node.fail(http.StatusBadGateway, -1)
+	var httpErr rpc.HTTPError
+	if err := blockNumber(t, pool); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("got error %v, want HTTP 502", err)
+	}
+	if node.requests() != 3+pool.MaxRetries+1 {
+		t.Errorf("got %d requests, want %d", node.requests(), 3+pool.MaxRetries+1)
+	}
+
+	// JSON-RPC errors are not retried
+	node.fail(0, 0)
+	client, _ := pool.Client()
+	numRequests := node.requests()
+	if err := client.Call(nil, "eth_unknown"); err == nil {
+		t.Error("unknown method: got no error")
+	}
+	if node.requests() != numRequests+1 {
+		t.Errorf("JSON-RPC error: got %d requests, want 1", node.requests()-numRequests)
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	slow, fallback := newStubNode(t), newStubNode(t)
+	pool := newTestPool(t, 0, 50*time.Millisecond, slow, fallback)
+
+	slow.setDelay(200 * time.Millisecond)
+	if err := blockNumber(t, pool); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats[0].NumFailures != 1 || stats[1].NumRequests != 1 {
+		t.Errorf("got stats %+v, want a timeout of the slow node and a request to the fallback", stats)
+	}
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	node1, node2 := newStubNode(t), newStubNode(t)
+	pool := newTestPool(t, 5, time.Second, node1, node2)
+
+	// The requests over the budget of the first node go to the second one, without waiting
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := blockNumber(t, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if node1.requests() != 5 || node2.requests() != 5 {
+		t.Errorf("got %d and %d requests, want 5 each", node1.requests(), node2.requests())
+	}
+
+	// Over the budget of both nodes, the requests wait
+	for i := 0; i < 4; i++ {
+		if err := blockNumber(t, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("14 requests with a budget of 2*5/s took %s", elapsed)
+	}
+}
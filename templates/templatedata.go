@@ -1,6 +1,7 @@
 package templates
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/metachris/ethereum-go-experiments/database"
@@ -11,6 +12,20 @@ type TemplateData struct {
 	AddressStats *[]database.AnalysisAddressStatsEntryWithAddress
 }
 
+// IndexTemplateData is used to render index.html, which links the pages of all analyses
+type IndexTemplateData struct {
+	Analyses []database.AnalysisEntry
+}
+
+// AnalysisHtmlFilename returns the filename of the HTML page of an analysis: <date>.html for full days starting at midnight,
+// else <date>_<hhmm>_<id>.html
+func AnalysisHtmlFilename(analysis database.AnalysisEntry) string {
+	if analysis.Hour == 0 && analysis.Minute == 0 && analysis.DurationSec >= 23*60*60 {
+		return fmt.Sprintf("%s.html", analysis.Date)
+	}
+	return fmt.Sprintf("%s_%02d%02d_%d.html", analysis.Date, analysis.Hour, analysis.Minute, analysis.Id)
+}
+
 func (tv *TemplateData) GetTopStats(start int, maxEntries int, sortMethod func(i int, j int) bool, checkMethod func(a database.AnalysisAddressStatsEntryWithAddress) bool) *[]database.AnalysisAddressStatsEntryWithAddress {
 	if start > len(*tv.AddressStats) {
 		return nil
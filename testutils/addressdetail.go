@@ -0,0 +1,50 @@
+package testutils
+
+import (
+	"strings"
+
+	"github.com/metachris/go-ethutils/addressdetail"
+)
+
+// FakeAddressDetailService implements core.IAddressDetailService with a fixed set of address details. Unknown
+// addresses are returned as wallets.
+type FakeAddressDetailService struct {
+	Details map[string]addressdetail.AddressDetail
+}
+
+func NewFakeAddressDetailService(details ...addressdetail.AddressDetail) *FakeAddressDetailService {
+	ads := &FakeAddressDetailService{
+		Details: make(map[string]addressdetail.AddressDetail),
+	}
+	for _, detail := range details {
+		ads.Details[strings.ToLower(detail.Address)] = detail
+	}
+	return ads
+}
+
+func (ads *FakeAddressDetailService) EnsureIsLoaded(a *addressdetail.AddressDetail) {
+	if !a.IsInitial() {
+		return
+	}
+
+	detail, found := ads.Details[strings.ToLower(a.Address)]
+	if !found {
+		a.Type = addressdetail.AddressTypeWallet
+		return
+	}
+
+	a.Type = detail.Type
+	a.Name = detail.Name
+	a.Symbol = detail.Symbol
+	a.Decimals = detail.Decimals
+}
+
+// Erc20Detail returns the address detail of an ERC20 token
+func Erc20Detail(account Account, name string, symbol string, decimals uint8) addressdetail.AddressDetail {
+	return addressdetail.AddressDetail{Address: account.Hex(), Type: addressdetail.AddressTypeErc20, Name: name, Symbol: symbol, Decimals: decimals}
+}
+
+// Erc721Detail returns the address detail of an ERC721 token
+func Erc721Detail(account Account, name string, symbol string) addressdetail.AddressDetail {
+	return addressdetail.AddressDetail{Address: account.Hex(), Type: addressdetail.AddressTypeErc721, Name: name, Symbol: symbol}
+}
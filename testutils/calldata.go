@@ -0,0 +1,50 @@
+package testutils
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/ethereum-go-experiments/consts"
+)
+
+var (
+	MethodIdTransfer     = common.FromHex("a9059cbb")
+	MethodIdTransferFrom = common.FromHex("23b872dd")
+)
+
+func word(b []byte) []byte {
+	return common.LeftPadBytes(b, 32)
+}
+
+// TransferData returns the calldata for transfer(address _to, uint256 _value)
+func TransferData(to common.Address, value *big.Int) []byte {
+	data := append([]byte{}, MethodIdTransfer...)
+	data = append(data, word(to.Bytes())...)
+	return append(data, word(value.Bytes())...)
+}
+
+// TransferFromData returns the calldata for transferFrom(address _from, address _to, uint256 _value)
+func TransferFromData(from common.Address, to common.Address, value *big.Int) []byte {
+	data := append([]byte{}, MethodIdTransferFrom...)
+	data = append(data, word(from.Bytes())...)
+	data = append(data, word(to.Bytes())...)
+	return append(data, word(value.Bytes())...)
+}
+
+// Erc20TransferLog returns the Transfer event of an ERC20 token (value in data)
+func Erc20TransferLog(token common.Address, from common.Address, to common.Address, value *big.Int) *types.Log {
+	return &types.Log{
+		Address: token,
+		Topics:  []common.Hash{consts.TopicTransfer, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:    word(value.Bytes()),
+	}
+}
+
+// Erc721TransferLog returns the Transfer event of an ERC721 token (tokenId as indexed 4th topic)
+func Erc721TransferLog(token common.Address, from common.Address, to common.Address, tokenId *big.Int) *types.Log {
+	return &types.Log{
+		Address: token,
+		Topics:  []common.Hash{consts.TopicTransfer, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes()), common.BigToHash(tokenId)},
+	}
+}
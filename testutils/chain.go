@@ -0,0 +1,147 @@
+// Helpers to build synthetic transactions, receipts and blocks for tests and fixtures, without an Ethereum node
+package testutils
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+var ChainId = big.NewInt(1)
+
+// Miner is the coinbase of all blocks built with NewBlock and NewLondonBlock
+var Miner = NewAccount("miner")
+
+var (
+	Gwei  = big.NewInt(1e9)
+	Ether = big.NewInt(1e18)
+)
+
+// Account is a deterministic key pair to sign transactions with
+type Account struct {
+	Key     *ecdsa.PrivateKey
+	Address common.Address
+}
+
+// NewAccount returns the account for a seed. The same seed always returns the same account.
+func NewAccount(seed string) Account {
+	key, err := crypto.ToECDSA(crypto.Keccak256([]byte(seed)))
+	if err != nil {
+		panic(err)
+	}
+	return Account{
+		Key:     key,
+		Address: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// Hex returns the lowercase address, as used for keys in core.Analysis.Addresses
+func (a Account) Hex() string {
+	return strings.ToLower(a.Address.Hex())
+}
+
+// NewTx returns a signed legacy transaction. to can be nil for contract creation.
+func NewTx(from Account, nonce uint64, to *common.Address, value *big.Int, gasPrice *big.Int, data []byte) *types.Transaction {
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Value:    value,
+		Gas:      1_000_000,
+		GasPrice: gasPrice,
+		Data:     data,
+	})
+
+	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(ChainId), from.Key)
+	if err != nil {
+		panic(err)
+	}
+	return signedTx
+}
+
+// NewDynamicFeeTx returns a signed EIP-1559 transaction. to can be nil for contract creation.
+func NewDynamicFeeTx(from Account, nonce uint64, to *common.Address, value *big.Int, gasTipCap *big.Int, gasFeeCap *big.Int, data []byte) *types.Transaction {
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   ChainId,
+		Nonce:     nonce,
+		To:        to,
+		Value:     value,
+		Gas:       1_000_000,
+		GasTipCap: gasTipCap,
+		GasFeeCap: gasFeeCap,
+		Data:      data,
+	})
+
+	signedTx, err := types.SignTx(tx, types.NewLondonSigner(ChainId), from.Key)
+	if err != nil {
+		panic(err)
+	}
+	return signedTx
+}
+
+// NewReceipt returns the receipt for a transaction
+func NewReceipt(tx *types.Transaction, success bool, gasUsed uint64, logs ...*types.Log) *types.Receipt {
+	receipt := &types.Receipt{
+		Type:    tx.Type(),
+		GasUsed: gasUsed,
+		TxHash:  tx.Hash(),
+		Logs:    logs,
+	}
+	if success {
+		receipt.Status = types.ReceiptStatusSuccessful
+	}
+	if receipt.Logs == nil {
+		receipt.Logs = []*types.Log{}
+	}
+	if tx.To() == nil {
+		receipt.ContractAddress = crypto.CreateAddress(common.Address{}, tx.Nonce())
+	}
+	return receipt
+}
+
+// NewBlock returns a block with the transactions and their receipts (receipts can be nil, to skip a receipt)
+func NewBlock(number int64, timestamp uint64, parentHash common.Hash, txs []*types.Transaction, receipts []*types.Receipt) *blockswithtx.BlockWithTxReceipts {
+	return NewLondonBlock(number, timestamp, parentHash, nil, txs, receipts)
+}
+
+// NewLondonBlock is NewBlock with a base fee (EIP-1559). baseFee nil returns a pre-London block.
+func NewLondonBlock(number int64, timestamp uint64, parentHash common.Hash, baseFee *big.Int, txs []*types.Transaction, receipts []*types.Receipt) *blockswithtx.BlockWithTxReceipts {
+	header := &types.Header{
+		ParentHash: parentHash,
+		Number:     big.NewInt(number),
+		Time:       timestamp,
+		GasLimit:   15_000_000,
+		Difficulty: common.Big1,
+		Coinbase:   Miner.Address,
+		BaseFee:    baseFee,
+	}
+
+	res := &blockswithtx.BlockWithTxReceipts{
+		TxReceipts: make(map[common.Hash]*types.Receipt),
+	}
+
+	cumulativeGasUsed := uint64(0)
+	for i, receipt := range receipts {
+		if receipt == nil {
+			continue
+		}
+		cumulativeGasUsed += receipt.GasUsed
+		receipt.CumulativeGasUsed = cumulativeGasUsed
+		receipt.TransactionIndex = uint(i)
+		res.TxReceipts[txs[i].Hash()] = receipt
+	}
+	header.GasUsed = cumulativeGasUsed
+
+	res.Block = types.NewBlock(header, txs, nil, nil, trie.NewStackTrie(nil))
+	return res
+}
+
+// AddressPtr returns a pointer to the address (for tx recipients)
+func AddressPtr(a common.Address) *common.Address {
+	return &a
+}